@@ -0,0 +1,81 @@
+// Command postwebhook signs a fixture payload the way the provider would and
+// posts it to a running server, for exercising webhook handlers locally:
+//
+//	LOB_WEBHOOK_SECRET=dev go run ./cmd/postwebhook internal/mailer/testdata/lob_letter_certified_delivered.json
+//	SQUARE_WEBHOOK_SIGNATURE_KEY=dev go run ./cmd/postwebhook -provider square \
+//		-url http://localhost:8080/webhooks/square internal/payment/testdata/square_dispute_created.json
+//
+// For Square the -url must match the server's SQUARE_WEBHOOK_URL, since it is
+// part of the signed content.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/payment"
+)
+
+func main() {
+	provider := flag.String("provider", "lob", "lob or square")
+	target := flag.String("url", "http://localhost:8080/webhooks/lob", "webhook endpoint to post to")
+	secret := flag.String("secret", "", "signing secret (defaults to LOB_WEBHOOK_SECRET or SQUARE_WEBHOOK_SIGNATURE_KEY)")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatal("usage: postwebhook [-provider lob|square] [-url URL] [-secret SECRET] fixture.json")
+	}
+
+	if *secret == "" {
+		switch *provider {
+		case "lob":
+			*secret = os.Getenv("LOB_WEBHOOK_SECRET")
+		case "square":
+			*secret = os.Getenv("SQUARE_WEBHOOK_SIGNATURE_KEY")
+		}
+	}
+	if *secret == "" {
+		log.Fatal("no signing secret: pass -secret or set the provider's environment variable")
+	}
+
+	body, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *target, bytes.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	switch *provider {
+	case "lob":
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		req.Header.Set(mailer.SignatureTimestampHeader, timestamp)
+		req.Header.Set(mailer.SignatureHeader, mailer.SignWebhook(*secret, timestamp, body))
+	case "square":
+		req.Header.Set(payment.SignatureHeader, payment.SignWebhook(*secret, *target, body))
+	default:
+		log.Fatalf("unknown provider %q", *provider)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	fmt.Printf("%s\n%s", resp.Status, respBody)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"sendmynotice/internal/blob"
+	"sendmynotice/internal/blob/s3fake"
+	"sendmynotice/internal/orders"
+	"sendmynotice/internal/storage"
+	"sendmynotice/internal/worker"
+)
+
+// blobStoreFromEnv picks where archived PDFs are kept. BLOB_BACKEND=s3 uses
+// the bucket described by the S3_* variables; anything else keeps them under
+// BLOB_DIR. Locally, S3 without an S3_ENDPOINT runs against an in-process
+// stand-in.
+func blobStoreFromEnv(appEnv string) (blob.Store, error) {
+	if os.Getenv("BLOB_BACKEND") != "s3" {
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		log.Printf("🗄️  Archiving PDFs under %s", dir)
+		return blob.NewFS(dir)
+	}
+
+	cfg := blob.S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Region:          os.Getenv("S3_REGION"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+	}
+	if appEnv == "local" && cfg.Endpoint == "" {
+		if cfg.AccessKeyID == "" {
+			cfg.AccessKeyID, cfg.SecretAccessKey = "local", "local-secret"
+		}
+		if cfg.Bucket == "" {
+			cfg.Bucket = "sendmynotice-local"
+		}
+		endpoint, err := s3fake.New(cfg.AccessKeyID, cfg.SecretAccessKey).Start("127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("starting fake S3 server: %w", err)
+		}
+		log.Printf("🪣 Using fake S3 at %s", endpoint)
+		cfg.Endpoint = endpoint
+	}
+	log.Printf("🗄️  Archiving PDFs in bucket %s", cfg.Bucket)
+	return blob.NewS3(cfg)
+}
+
+// proofLinksFromEnv signs proof links with PROOF_LINK_KEY. Customers keep
+// those links as evidence, so the key has its own variable and is never
+// rotated along with CANCEL_LINK_KEY. Only with APP_ENV=local is a missing
+// key replaced by a random one, good until the next restart.
+func proofLinksFromEnv(appEnv, baseURL string) (*orders.ProofLinks, error) {
+	key := []byte(os.Getenv("PROOF_LINK_KEY"))
+	if len(key) == 0 {
+		if appEnv != "local" {
+			return nil, errors.New("PROOF_LINK_KEY not set")
+		}
+		log.Println("⚠️  PROOF_LINK_KEY not set, proof links will stop working on restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generating proof link key: %w", err)
+		}
+	}
+	return &orders.ProofLinks{BaseURL: baseURL, Key: key}, nil
+}
+
+// proofLetter loads the letter a signed proof link points at, and its order.
+// It writes the error response itself and returns nil if the link is no
+// good.
+func (s *Server) proofLetter(w http.ResponseWriter, r *http.Request, orderID, letterID int) (*storage.Order, *storage.OrderLetter) {
+	q := r.URL.Query()
+	if err := s.proofLinks.Verify(orderID, letterID, q.Get("sig")); err != nil {
+		http.Error(w, "This link is not valid. Contact support@sendmynotice.com for a copy of your notice.", http.StatusForbidden)
+		return nil, nil
+	}
+
+	order, err := s.db.GetOrder(r.Context(), orderID)
+	if err != nil || order == nil {
+		log.Printf("Failed to load order %d for proof: %v", orderID, err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return nil, nil
+	}
+	for i := range order.Letters {
+		if order.Letters[i].ID == letterID {
+			return order, &order.Letters[i]
+		}
+	}
+	http.Error(w, "Letter not found", http.StatusNotFound)
+	return nil, nil
+}
+
+// handleProofPDF serves our archived copy of a letter, archiving it first if
+// the worker has not got to it yet.
+func (s *Server) handleProofPDF(w http.ResponseWriter, r *http.Request) {
+	orderID, err1 := strconv.Atoi(chi.URLParam(r, "id"))
+	letterID, err2 := strconv.Atoi(chi.URLParam(r, "letterID"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid link", http.StatusBadRequest)
+		return
+	}
+	o, l := s.proofLetter(w, r, orderID, letterID)
+	if l == nil {
+		return
+	}
+
+	if err := s.archiver.Archive(r.Context(), l); err != nil {
+		if errors.Is(err, worker.ErrPDFNotReady) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Your notice is still being prepared. Please try again in a few minutes.", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Failed to archive letter %s for proof: %v", l.LetterID, err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return
+	}
+
+	pdf, err := s.blobs.Get(r.Context(), l.ArchiveKey)
+	if err != nil {
+		log.Printf("Failed to read archived PDF %s: %v", l.ArchiveKey, err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = pdf.Close()
+	}()
+
+	w.Header().Set("Content-Type", "application/pdf")
+	document := "preliminary-notice"
+	if o.Waiver != nil {
+		document = "lien-waiver"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s-%d-%d.pdf"`, document, l.OrderID, l.ID))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if _, err := io.Copy(w, pdf); err != nil {
+		log.Printf("Failed to send archived PDF %s: %v", l.ArchiveKey, err)
+	}
+}
+
+// checkPDFURL is polled by the success modal until the letter's PDF has been
+// archived. It carries the same signature as the letter's proof link.
+func (s *Server) checkPDFURL(l *storage.OrderLetter) string {
+	proof, err := url.Parse(s.proofLinks.URL(l))
+	if err != nil {
+		return ""
+	}
+	q := proof.Query()
+	q.Set("order", strconv.Itoa(l.OrderID))
+	q.Set("letter", strconv.Itoa(l.ID))
+	return "/web/check-pdf?" + q.Encode()
+}
+
+// handleProofOfServicePDF serves the proof of service for an order, filled
+// in from its mailed letters.
+func (s *Server) handleProofOfServicePDF(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid link", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if err := s.proofLinks.VerifyService(orderID, q.Get("sig")); err != nil {
+		http.Error(w, "This link is not valid. Contact support@sendmynotice.com for a copy of your proof of service.", http.StatusForbidden)
+		return
+	}
+
+	order, err := s.db.GetOrder(r.Context(), orderID)
+	if err != nil || order == nil {
+		log.Printf("Failed to load order %d for proof of service: %v", orderID, err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return
+	}
+	data, ok := orders.ProofOfService(order)
+	if !ok {
+		http.Error(w, "Your notice has not been mailed yet.", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, orders.ProofOfServiceFilename))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if _, err := w.Write(orders.ProofOfServicePDF(data)); err != nil {
+		log.Printf("Failed to send proof of service for order %d: %v", orderID, err)
+	}
+}
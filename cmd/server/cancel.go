@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"sendmynotice/internal/orders"
+	"sendmynotice/internal/storage"
+)
+
+const customerCancelReason = "Cancelled at the customer's request"
+
+// cancelLinksFromEnv signs cancel links with CANCEL_LINK_KEY for pages served
+// at PUBLIC_URL. Outside production a missing key is replaced by a random
+// one, so links only work until the next restart.
+func cancelLinksFromEnv(appEnv string) (*orders.CancelLinks, error) {
+	baseURL := strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+	if baseURL == "" {
+		baseURL = "https://sendmynotice.com"
+		if appEnv == "local" {
+			baseURL = "http://localhost:8080"
+		}
+	}
+
+	key := []byte(os.Getenv("CANCEL_LINK_KEY"))
+	if len(key) == 0 {
+		if appEnv == "production" {
+			return nil, errors.New("CANCEL_LINK_KEY not set")
+		}
+		log.Println("⚠️  CANCEL_LINK_KEY not set, cancel links will stop working on restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generating cancel link key: %w", err)
+		}
+	}
+	return &orders.CancelLinks{BaseURL: baseURL, Key: key}, nil
+}
+
+// orderCancellable is used by the admin dashboard to offer cancellation.
+func orderCancellable(o storage.Order) bool {
+	return orders.Cancellable(&o, time.Now())
+}
+
+type cancelPageData struct {
+	Order   *storage.Order
+	Refund  string
+	Message string
+	// Confirm is set while the order can still be cancelled; the page then
+	// asks the customer to confirm.
+	Confirm bool
+	Action  string
+}
+
+var cancelPage = template.Must(template.New("cancel").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Cancel Notice - SendMyNotice</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <script src="https://cdn.tailwindcss.com"></script>
+</head>
+<body class="bg-gray-100 p-4 sm:p-8">
+    <div class="max-w-md mx-auto bg-white rounded-lg shadow-md overflow-hidden">
+        <div class="bg-blue-900 text-white p-6 text-center">
+            <h1 class="text-lg font-bold">Cancel Preliminary Notice</h1>
+            {{with .Order}}<p class="text-sm opacity-90 mt-1">Ref: {{.PaymentID}}</p>{{end}}
+        </div>
+        <div class="p-6 space-y-4 text-sm text-gray-700">
+            {{if .Message}}<p>{{.Message}}</p>{{end}}
+            {{if .Confirm}}
+            <p>These letters have not gone to print yet. Cancelling stops all of them and refunds <strong>{{.Refund}}</strong> to your card.</p>
+            <ul class="border rounded divide-y">
+                {{range .Order.Letters}}{{if eq .Status "submitted"}}
+                <li class="px-3 py-2"><span class="font-semibold">{{.ToAddress.Name}}</span> <span class="text-xs text-gray-500">{{.Role.Label}} &middot; {{.MailClass.Label}}</span></li>
+                {{end}}{{end}}
+            </ul>
+            <form method="post" action="{{.Action}}">
+                <button type="submit" class="w-full bg-red-600 text-white font-bold py-3 rounded hover:bg-red-700">Cancel &amp; Refund</button>
+            </form>
+            {{end}}
+            <a href="/" class="block text-center text-gray-400 text-xs hover:text-gray-600 hover:underline">Back to SendMyNotice</a>
+        </div>
+    </div>
+</body>
+</html>`))
+
+func renderCancelPage(w http.ResponseWriter, status int, data cancelPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := cancelPage.Execute(w, data); err != nil {
+		log.Printf("Cancel page failed to render: %v", err)
+	}
+}
+
+// cancelLinkOrder loads the order a signed cancel link points at. It writes
+// the error page itself and returns nil if the link is no good.
+func (s *Server) cancelLinkOrder(w http.ResponseWriter, r *http.Request) *storage.Order {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err == nil {
+		q := r.URL.Query()
+		err = s.cancelLinks.Verify(id, q.Get("expires"), q.Get("sig"), time.Now())
+	}
+	if err != nil {
+		renderCancelPage(w, http.StatusForbidden, cancelPageData{
+			Message: "This cancel link has expired. Once a notice has gone to print it can no longer be cancelled. Contact support@sendmynotice.com if you need help.",
+		})
+		return nil
+	}
+
+	order, err := s.db.GetOrder(r.Context(), id)
+	if err != nil || order == nil {
+		log.Printf("Failed to load order %d for cancellation: %v", id, err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return nil
+	}
+	return order
+}
+
+func (s *Server) handleCancelPage(w http.ResponseWriter, r *http.Request) {
+	order := s.cancelLinkOrder(w, r)
+	if order == nil {
+		return
+	}
+
+	data := cancelPageData{Order: order}
+	switch {
+	case order.Cancelled():
+		data.Message = "This notice has already been cancelled."
+	case orders.Cancellable(order, time.Now()):
+		data.Confirm = true
+		data.Action = r.URL.RequestURI()
+		var refund int64
+		for _, l := range order.Letters {
+			if l.Status == storage.LetterSubmitted {
+				refund += l.AmountCents
+			}
+		}
+		data.Refund = orders.FormatCents(refund)
+	default:
+		data.Message = "This notice has already gone to print and can no longer be cancelled."
+	}
+	renderCancelPage(w, http.StatusOK, data)
+}
+
+func (s *Server) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	order := s.cancelLinkOrder(w, r)
+	if order == nil {
+		return
+	}
+
+	// Once a letter is cancelled its refund must go through even if the
+	// browser goes away, so detach from the request's cancellation. A
+	// partly cancelled order still gets the outcome page, which says which
+	// letter is going out anyway.
+	ctx := context.WithoutCancel(r.Context())
+	if err := s.orders.CancelOrder(ctx, order, customerCancelReason); err != nil && !order.Cancelled() {
+		if errors.Is(err, orders.ErrNotCancellable) {
+			renderCancelPage(w, http.StatusConflict, cancelPageData{
+				Order:   order,
+				Message: "This notice has already gone to print and can no longer be cancelled.",
+			})
+			return
+		}
+		log.Printf("Failed to cancel order #%d: %v", order.ID, err)
+		http.Error(w, "System Error: the notice could not be cancelled. Please try again.", http.StatusInternalServerError)
+		return
+	}
+
+	renderCancelPage(w, http.StatusOK, cancelPageData{Order: order, Message: cancelOutcome(order)})
+}
+
+// cancelOutcome tells the customer what became of each letter and its money
+// once a cancellation has run.
+func cancelOutcome(o *storage.Order) string {
+	var refunded, delayed int64
+	var mailed []string
+	for _, l := range o.Letters {
+		switch {
+		case l.CancelledAt == nil && l.Status == storage.LetterSubmitted:
+			mailed = append(mailed, l.ToAddress.Name)
+		case l.CancelledAt == nil:
+		case l.Status == storage.LetterRefunded:
+			refunded += l.AmountCents
+		case l.Status == storage.LetterFailed:
+			delayed += l.AmountCents
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("Your notice has been cancelled.")
+	if refunded > 0 {
+		fmt.Fprintf(&b, " %s has been refunded to your card.", orders.FormatCents(refunded))
+	}
+	if delayed > 0 {
+		fmt.Fprintf(&b, " Your %s refund is delayed. We will keep retrying it automatically.", orders.FormatCents(delayed))
+	}
+	if len(mailed) > 0 {
+		fmt.Fprintf(&b, " The letter to %s could not be cancelled and will still be delivered.", strings.Join(mailed, ", "))
+	}
+	return b.String()
+}
+
+func (s *Server) handleAdminCancelOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	order, err := s.db.GetOrder(r.Context(), id)
+	if err != nil || order == nil {
+		log.Printf("Failed to load order %d for cancellation: %v", id, err)
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	// As for customers, refunds must not stop when the browser does.
+	if err := s.orders.CancelOrder(context.WithoutCancel(r.Context()), order, "Cancelled by admin"); err != nil {
+		log.Printf("Failed to cancel order #%d: %v", id, err)
+		if errors.Is(err, orders.ErrNotCancellable) {
+			http.Error(w, "Order can no longer be cancelled", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Cancel failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
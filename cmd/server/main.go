@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
@@ -9,55 +9,53 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"sendmynotice/internal/apierrors"
+	"sendmynotice/internal/blob"
 	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/mailer/lobfake"
+	"sendmynotice/internal/orders"
 	"sendmynotice/internal/payment"
-	"sendmynotice/internal/templates"
 	"sendmynotice/internal/storage"
 	"sendmynotice/internal/email"
 	"sendmynotice/internal/worker"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
 )
 
 type PageData struct {
     SquareJsURL string
     CurrentDate string
-}
-
-type OrderRecord struct {
-    Time           string `json:"time"`
-    PaymentID      string `json:"payment_id"`
-    TrackingNumber string `json:"tracking_number"`
-    Amount         string `json:"amount"`
-    UserEmail      string `json:"user_email"`
-    JobAddress     string `json:"job_address"`
-}
-
-type ReceiptData struct {
-	PaymentID      string
-	TrackingNumber string
-	TrackingLink   string
-    PDFURL         string
-	Name           string
-	Date           string
-	JobAddress     string
+    MailClasses []mailClassOption
+    States      []stateOption
+    MinSendDate string
+    MaxSendDate string
 }
 
 type Server struct {
-	mailer      *mailer.Client
+	mailer      mailer.Mailer
 	payment     *payment.Client
 	squareAppID string
 	squareLocID string
 	squareJsURL string
 	homeTemplate *template.Template
-	receiptTemplate *template.Template
-	db 			*storage.DB
+	waiversTemplate *template.Template
+	db 			storage.Store
 	email 		*email.Client
+	orders      *orders.Processor
+	reconciler  *worker.Reconciler
+	lobWebhookSecret string
+	squareWebhookKey string
+	squareWebhookURL string
+	cancelLinks      *orders.CancelLinks
+	proofLinks       *orders.ProofLinks
+	blobs            blob.Store
+	archiver         *worker.PDFArchiver
 }
 
 func BasicAuth(username, password string) func(next http.Handler) http.Handler {
@@ -75,7 +73,27 @@ func BasicAuth(username, password string) func(next http.Handler) http.Handler {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	appEnv := os.Getenv("APP_ENV")
+
 	lobKey := os.Getenv("LOB_API_KEY")
+	lobBaseURL := os.Getenv("LOB_BASE_URL")
+	if appEnv == "local" {
+		// Letters go to an in-process fake Lob so checkout works offline.
+		fakeURL, err := lobfake.New().Start("127.0.0.1:0")
+		if err != nil {
+			log.Fatalf("Failed to start fake Lob server: %v", err)
+		}
+		log.Printf("📮 Using fake Lob at %s", fakeURL)
+		lobBaseURL = fakeURL
+		if lobKey == "" {
+			lobKey = "test_local"
+		}
+	}
 	if lobKey == "" {
 		log.Fatal("LOB_API_KEY not set")
 	}
@@ -93,15 +111,29 @@ func main() {
 		log.Fatal("SQUARE_APP_ID or SQUARE_LOCATION_ID not set")
 	}
 	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL not set")
-	}
     resendKey := os.Getenv("RESEND_API_KEY")
 	if resendKey == "" {
 		log.Fatal("RESEND_API_KEY not set")
 	}
 
-	appEnv := os.Getenv("APP_ENV")
+	lobWebhookSecret := os.Getenv("LOB_WEBHOOK_SECRET")
+	squareWebhookKey := os.Getenv("SQUARE_WEBHOOK_SIGNATURE_KEY")
+	squareWebhookURL := os.Getenv("SQUARE_WEBHOOK_URL")
+
+	cancelLinks, err := cancelLinksFromEnv(appEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	proofLinks, err := proofLinksFromEnv(appEnv, cancelLinks.BaseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	blobs, err := blobStoreFromEnv(appEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	adminUser := os.Getenv("ADMIN_USER")
     adminPass := os.Getenv("ADMIN_PASS")
@@ -117,10 +149,10 @@ func main() {
 		log.Println("⚠️  STARTING IN SANDBOX MODE")
 	}
 
-	database, err := storage.NewPostgres(dbURL)
-    if err != nil {
-        log.Fatal(err)
-    }
+	database, err := openStore(dbURL, appEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
 	emailClient := email.NewClient(resendKey)
 
 	emailRunner := worker.NewEmailRunner(database, emailClient)
@@ -132,24 +164,48 @@ func main() {
     if err != nil {
         log.Fatal("Failed to parse index.html: ", err)
     }
-
-	receiptTmpl := template.Must(template.ParseFiles("internal/templates/receipt.html"))
-    if err != nil {
-        log.Fatal("Failed to parse receipt.html: ", err)
-    }
+	waiversTmpl, err := template.ParseFiles("web/waivers.html")
+	if err != nil {
+		log.Fatal("Failed to parse waivers.html: ", err)
+	}
 
 	srv := &Server{
-		mailer:      mailer.NewClient(strings.TrimSpace(lobKey)),
+		mailer:      mailer.NewClient(strings.TrimSpace(lobKey), lobBaseURL),
 		payment:     payClient,
 		squareAppID: squareAppID,
 		squareLocID: squareLocID,
 		squareJsURL: squareJsURL,
 		homeTemplate:    homeTmpl,
-		receiptTemplate: receiptTmpl,
+		waiversTemplate: waiversTmpl,
 		db:    database,
         email: emailClient,
+		lobWebhookSecret: lobWebhookSecret,
+		squareWebhookKey: squareWebhookKey,
+		squareWebhookURL: squareWebhookURL,
+		cancelLinks:      cancelLinks,
+		proofLinks:       proofLinks,
+		blobs:            blobs,
 	}
 
+	srv.orders, err = orders.NewProcessor(database, srv.mailer, payClient, emailClient, srv.sendAdminAlert)
+	if err != nil {
+		log.Fatal("Failed to set up order processor: ", err)
+	}
+	srv.orders.SetCancelLinks(cancelLinks)
+	srv.orders.SetProofLinks(proofLinks)
+
+	srv.archiver = worker.NewPDFArchiver(database, srv.mailer, blobs)
+	go srv.archiver.Start()
+
+	orderResumer := worker.NewOrderResumer(database, srv.orders)
+	go orderResumer.Start()
+
+	refundRunner := worker.NewRefundRunner(database, srv.orders)
+	go refundRunner.Start()
+
+	srv.reconciler = worker.NewReconciler(database, payClient, srv.mailer, srv.sendAdminAlert)
+	go srv.reconciler.Start()
+
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
@@ -175,17 +231,40 @@ func main() {
 
 	r.Post("/web/pay-and-send", srv.handlePayAndSend)
 
+	r.Get("/web/order-status", srv.handleOrderStatus)
+
 	r.Post("/web/lookup-owner", srv.handleLookupOwner)
 
 	r.Get("/web/check-pdf", srv.handleCheckPDFStatus)
 
-	r.Post("/web/capture-lead", srv.handleCaptureLead)
+	r.Post("/web/notice.pdf", srv.handleNoticePDF)
+
+	r.Get("/waivers", srv.handleWaivers)
+
+	r.Post("/web/waivers/preview", srv.handleWaiverPreview)
+
+	r.Post("/web/waivers/waiver.pdf", srv.handleWaiverPDF)
+
+	r.Get("/orders/{id}/cancel", srv.handleCancelPage)
+
+	r.Get("/orders/{id}/letters/{letterID}/proof.pdf", srv.handleProofPDF)
+
+	r.Get("/orders/{id}/proof-of-service.pdf", srv.handleProofOfServicePDF)
+
+	r.Post("/orders/{id}/cancel", srv.handleCancelOrder)
+
+	r.Post("/webhooks/lob", srv.handleLobWebhook)
+
+	r.Post("/webhooks/square", srv.handleSquareWebhook)
 
 	r.Group(func(r chi.Router) {
         if adminUser != "" && adminPass != "" {
             r.Use(BasicAuth(adminUser, adminPass))
         }
         r.Get("/admin", srv.handleAdminDashboard)
+        r.Post("/admin/refunds/{id}/resolve", srv.handleResolveRefund)
+        r.Post("/admin/reconcile", srv.handleRunReconciliation)
+        r.Post("/admin/orders/{id}/cancel", srv.handleAdminCancelOrder)
     })
 
 	port := os.Getenv("PORT")
@@ -210,7 +289,11 @@ func main() {
 func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
     data := PageData{
         SquareJsURL: s.squareJsURL,
-        CurrentDate: time.Now().Format("Jan 02, 2006"),
+        CurrentDate: customerNow().Format("Jan 02, 2006"),
+        MailClasses: mailClassOptions(),
+        States:      stateOptions(),
+        MinSendDate: customerNow().Format("2006-01-02"),
+        MaxSendDate: customerNow().Add(orders.MaxScheduleAhead).Format("2006-01-02"),
     }
     if err := s.homeTemplate.Execute(w, data); err != nil {
         log.Printf("Template execution failed: %v", err)
@@ -224,44 +307,30 @@ func (s *Server) handleWebPreview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.FormValue("sender_role") == "" {
-        http.Error(w, "<div class='text-red-600 font-bold p-4'>Error: You must select a specific Role (e.g., Subcontractor) to generate a valid legal notice.</div>", http.StatusBadRequest)
-        return
-    }
+	req, errs := parseNoticeRequest(r, customerNow())
+	if len(errs) > 0 {
+		renderFieldErrors(w, errs)
+		return
+	}
+	rules, letters, sendDate, window := req.Rules, req.Letters, req.SendDate, req.Deadline
 
 	userEmail := r.FormValue("user_email")
     userName := r.FormValue("from_name")
 	if userEmail != "" {
-        err := s.db.UpsertLead(userEmail, userName) 
+        err := s.db.UpsertLead(r.Context(), userEmail, userName) 
         if err != nil {
             log.Printf("Failed to save lead: %v", err)
         }
     }
 
-	jobSiteAddress := r.FormValue("job_site_address")
-	if jobSiteAddress == "" {
-		jobSiteAddress = fmt.Sprintf("%s, %s, %s %s",
-			r.FormValue("to_address1"),
-			r.FormValue("to_city"),
-			r.FormValue("to_state"),
-			r.FormValue("to_zip"),
-		)
-	}
-
-	modalData := struct {
-		NoticeHTML  template.HTML
-		ToName      string
-		ToAddress   string
-		FromName    string
-		SenderRole  string
-		SquareAppID string
-		SquareLocID string
-		HiddenInputs map[string]string
-	}{
-		ToName:      r.FormValue("to_name"),
-		ToAddress:   r.FormValue("to_address1"),
-		FromName:    r.FormValue("from_name"),
-		SenderRole:  r.FormValue("sender_role"),
+	data := req.Notice
+
+	modalData := paymentModal{
+		Title:       "Confirm & Send",
+		FormID:      "notice-form",
+		Download:    download{Action: "/web/notice.pdf", Label: "No thanks, I'll print it myself"},
+		Deadline:    &window,
+		PayLabel:    "Pay & Send Notice",
 		SquareAppID: s.squareAppID,
 		SquareLocID: s.squareLocID,
 		HiddenInputs: map[string]string{
@@ -279,202 +348,73 @@ func (s *Server) handleWebPreview(w http.ResponseWriter, r *http.Request) {
 			"job_description":  r.FormValue("job_description"),
 			"estimated_price":  r.FormValue("estimated_price"),
 			"lender_name":      r.FormValue("lender_name"),
-			"job_site_address": jobSiteAddress,
+			"mail_class":       string(letters[0].MailClass),
+			"send_date":        r.FormValue("send_date"),
+			"first_furnished_date": r.FormValue("first_furnished_date"),
+			"job_site_address": data.JobSiteAddress,
+			"job_site_state":   rules.Code,
 			"user_email":       r.FormValue("user_email"),
+			"idempotency_key":  uuid.New().String(),
 		},
+		Total: orders.FormatCents(lettersTotal(letters)),
+	}
+	if sendDate != nil {
+		modalData.Details = append(modalData.Details, modalDetail{Label: "Held until mailing date", Value: sendDate.Format("Jan 02, 2006")})
+	}
+	modalData.Details = append(modalData.Details,
+		modalDetail{Label: "Deadline to cover all work", Value: window.MailBy.Format("Jan 02, 2006"), Late: window.Late()},
+		modalDetail{Label: "Work covered from", Value: window.CoveredFrom.Format("Jan 02, 2006"), Late: window.Late()},
+	)
+	for _, l := range letters {
+		if l.Role == storage.RecipientOwner {
+			continue
+		}
+		prefix := recipientPrefix(l.Role)
+		for _, field := range []string{"_name", "_address1", "_city", "_state", "_zip"} {
+			modalData.HiddenInputs[prefix+field] = r.FormValue(prefix + field)
+		}
+	}
+
+	// Catch undeliverable addresses now, before the card is charged, rather
+	// than when Lob rejects the letter and the charge has to be refunded.
+	for _, l := range letters {
+		modalData.Letters = append(modalData.Letters, letterLine{
+			Recipient: l.Role.Label(),
+			Name:      l.ToAddress.Name,
+			MailClass: l.MailClass.Label(),
+			Price:     orders.FormatCents(l.AmountCents),
+		})
+		modalData.AddressChecks = append(modalData.AddressChecks,
+			s.checkAddress(r.Context(), l.Role.Label()+" address", recipientPrefix(l.Role), l.ToAddress))
+	}
+	modalData.AddressChecks = append(modalData.AddressChecks,
+		s.checkAddress(r.Context(), "Your address", "from", req.From))
+	for _, c := range modalData.AddressChecks {
+		if c.Undeliverable {
+			modalData.Blocked = true
+		}
 	}
 
     go func() {
-        err := s.db.CreateLead(userEmail, userName)
+        err := s.db.CreateLead(context.WithoutCancel(r.Context()), userEmail, userName)
 		if err != nil {
 			log.Fatalf("Error creating lead to db, %v", err)
 		}
 		s.sendAdminAlert("New Lead Captured", fmt.Sprintf("Name: %s\n", userName))
     }()
 
-	data := mailer.NoticeData{
-		Date:           time.Now().Format("January 2, 2006"),
-		SenderName:     r.FormValue("from_name"),
-		SenderAddress:  fmt.Sprintf("%s, %s, %s %s", r.FormValue("from_address1"), r.FormValue("from_city"), r.FormValue("from_state"), r.FormValue("from_zip")),
-		SenderRole:     r.FormValue("sender_role"),
-		OwnerName:      r.FormValue("to_name"),
-		OwnerAddress:   fmt.Sprintf("%s, %s, %s %s", r.FormValue("to_address1"), r.FormValue("to_city"), r.FormValue("to_state"), r.FormValue("to_zip")),
-		JobSiteAddress: jobSiteAddress,
-		JobDescription: r.FormValue("job_description"),
-		EstimatedPrice: r.FormValue("estimated_price"),
-		LenderName:     r.FormValue("lender_name"),
-	}
-
-	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
-	w.Header().Set("Pragma", "no-cache")
-
-	noticeTmpl, _ := template.ParseFS(templates.GetNoticeFS(), "notice.html")
-	var noticeBuff bytes.Buffer
-	
-	err := noticeTmpl.Execute(&noticeBuff, data)
+	noticeHTML, err := s.orders.RenderNotice(data)
 	if err != nil {
-		log.Fatalf("Error generating notice templace - %v", err)
-	}
-
-	modalData.NoticeHTML = template.HTML(noticeBuff.String())
-
-	const modalTemplate = `
-	<div class="fixed inset-0 z-50 overflow-y-auto" aria-labelledby="modal-title" role="dialog" aria-modal="true">
-		<div class="flex items-end justify-center min-h-screen pt-4 px-4 pb-20 text-center sm:block sm:p-0">
-			<div class="fixed inset-0 bg-gray-500 bg-opacity-75 transition-opacity" onclick="document.getElementById('result').innerHTML=''"></div>
-
-			<div class="inline-block align-bottom bg-white rounded-lg text-left overflow-hidden shadow-xl transform transition-all sm:my-8 sm:align-middle sm:max-w-lg sm:w-full">
-				<div class="bg-white px-4 pt-5 pb-4 sm:p-6 sm:pb-4">
-					<div class="sm:flex sm:items-start">
-						<div class="mt-3 text-center sm:mt-0 sm:text-left w-full">
-							<div class="flex justify-between items-center mb-4">
-								<h3 class="text-lg leading-6 font-bold text-gray-900" id="modal-title">Confirm & Send</h3>
-								<button onclick="document.getElementById('result').innerHTML=''" class="text-gray-400 hover:text-gray-500">
-									<svg class="h-6 w-6" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M6 18L18 6M6 6l12 12"></path></svg>
-								</button>
-							</div>
-
-							<div class="border border-gray-200 rounded-md bg-gray-50 mb-6 max-h-[500px] overflow-y-auto shadow-inner overflow-x-hidden relative">
-								<div style="width: 60vw; transform: scale(0.45); transform-origin: top left;">
-									{{.NoticeHTML}}
-								</div>
-							</div>
-
-							<div class="bg-blue-50 p-4 rounded-md border border-blue-100">
-								<div class="flex justify-between items-center mb-3">
-									<span class="font-bold text-blue-900">Total</span>
-									<span class="font-bold text-blue-900 text-xl">$29.00</span>
-								</div>
-								
-								<div id="card-container" class="min-h-[50px] mb-4 bg-white rounded p-1"></div>
-
-								<form id="payment-form" hx-post="/web/pay-and-send" hx-target="#result" hx-swap="innerHTML">
-									{{range $key, $value := .HiddenInputs}}
-										<input type="hidden" name="{{$key}}" value="{{$value}}">
-									{{end}}
-									<input type="hidden" name="square_token" id="square_token_input">
-									
-									<div class="mb-4 flex items-start">
-										<div class="flex items-center h-5">
-											<input id="tos_agree" name="tos_agree" type="checkbox" required 
-												class="focus:ring-blue-500 h-4 w-4 text-blue-600 border-gray-300 rounded"
-												onchange="
-													const btn = document.getElementById('card-button');
-													btn.disabled = !this.checked; 
-													btn.classList.toggle('opacity-50', !this.checked);
-													btn.classList.toggle('cursor-not-allowed', !this.checked); 
-													btn.classList.toggle('cursor-pointer', this.checked);
-												">
-										</div>
-										<div class="ml-2 text-xs text-gray-600 text-left">
-											I agree to the <button type="button" onclick="document.getElementById('tos-modal').classList.remove('hidden')" class="text-blue-600 underline">Terms of Service</button> and understand that SendMyNotice is a filing service, not a law firm.
-										</div>
-									</div>
-
-									<div class="bg-yellow-50 border border-yellow-100 p-2 rounded mb-4 flex items-start gap-2">
-										<svg class="w-4 h-4 text-yellow-600 mt-0.5" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M12 8v4l3 3m6-3a9 9 0 11-18 0 9 9 0 0118 0z"></path></svg>
-										<div class="text-[10px] text-yellow-800 text-left">
-											<strong>Deadline Warning:</strong> If you started work more than 20 days ago, you must send this TODAY to protect your rights. USPS pickup is at 4:00 PM.
-										</div>
-									</div>
-
-									<button type="button" id="card-button" disabled class="w-full inline-flex justify-center rounded-md border border-transparent shadow-sm px-4 py-3 bg-green-600 text-base font-medium text-white hover:bg-green-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-green-500 sm:text-sm transition opacity-50 cursor-not-allowed">
-										Pay & Send via Certified Mail
-									</button>
-
-									<div class="mt-4 flex items-center justify-center gap-3 bg-gray-50 p-2 rounded border border-gray-100">
-										<div class="flex items-center gap-1">
-											<svg class="w-4 h-4 text-gray-500" fill="currentColor" viewBox="0 0 24 24"><path d="M18 8h-1V6c0-2.76-2.24-5-5-5S7 3.24 7 6v2H6c-1.1 0-2 .9-2 2v10c0 1.1.9 2 2 2h12c1.1 0 2-.9 2-2V10c0-1.1-.9-2-2-2zm-6 9c-1.1 0-2-.9-2-2s.9-2 2-2 2 .9 2 2-.9 2-2 2zm3.1-9H8.9V6c0-1.71 1.39-3.1 3.1-3.1 1.71 0 3.1 1.39 3.1 3.1v2z"/></svg>
-											<span class="text-[10px] font-bold text-gray-500 uppercase tracking-wide">256-Bit SSL Encrypted</span>
-										</div>
-										<div class="h-3 w-px bg-gray-300"></div>
-										<div class="flex items-center gap-1">
-											<svg class="w-4 h-4 text-gray-500" fill="currentColor" viewBox="0 0 24 24"><path d="M20 4H4c-1.11 0-1.99.89-1.99 2L2 18c0 1.11.89 2 2 2h16c1.11 0 2-.89 2-2V6c0-1.11-.89-2-2-2zm0 14H4v-6h16v6zm0-10H4V6h16v2z"/></svg>
-											<span class="text-[10px] font-bold text-gray-500 uppercase tracking-wide">Secure Payment</span>
-										</div>
-									</div>
-									<p class="text-[9px] text-gray-400 text-center mt-2">
-										We do not store your credit card details. Payments are processed securely by Square®.
-									</p>
-
-								</form>
-								<div id="payment-status-container" class="mt-2 text-center text-xs text-red-600 font-bold min-h-[20px]"></div>
-							</div>
-						</div>
-					</div>
-				</div>
-				<div class="bg-gray-50 px-4 py-3 sm:px-6 flex justify-center">
-					<form hx-post="/web/capture-lead" hx-swap="none">
-						<input type="hidden" name="email" value="{{index .HiddenInputs "user_email"}}">
-						<input type="hidden" name="from_name" value="{{.FromName}}">
-						<input type="hidden" name="sender_role" value="{{.SenderRole}}">
-						<button type="submit" class="text-xs text-gray-400 hover:text-gray-600 underline">
-							No thanks, I'll print it myself
-						</button>
-					</form>
-				</div>
-			</div>
-		</div>
-
-		<script>
-			async function initializeCard(appId, locationId) {
-				if (!window.Square) { 
-					console.error("Square JS not loaded");
-					return;
-				}
-				
-				try {
-					const payments = Square.payments(appId, locationId);
-					const card = await payments.card();
-					await card.attach('#card-container');
-
-					document.getElementById('card-button').addEventListener('click', async () => {
-						const statusContainer = document.getElementById('payment-status-container');
-						const btn = document.getElementById('card-button');
-						
-						// Disable button to prevent double charge
-						btn.disabled = true;
-						btn.innerText = "Processing...";
-						statusContainer.innerText = "";
-						
-						try {
-							const result = await card.tokenize();
-							if (result.status === 'OK') {
-								// Inject token into hidden field inside the form
-								document.getElementById('square_token_input').value = result.token;
-								// Trigger HTMX manually on the form
-								htmx.trigger('#payment-form', 'submit');
-							} else {
-								statusContainer.innerText = result.errors[0].message;
-								btn.disabled = false;
-								btn.innerText = "Pay & Send via Certified Mail";
-							}
-						} catch (e) {
-							console.error(e);
-							statusContainer.innerText = "Payment System Error. Try again.";
-							btn.disabled = false;
-							btn.innerText = "Pay & Send via Certified Mail";
-						}
-					});
-				} catch (e) {
-					console.error("Square Init Error:", e);
-				}
-			}
-			initializeCard('{{.SquareAppID}}', '{{.SquareLocID}}');
-		</script>
-	</div>
-	`
-
-	t, _ := template.New("modal").Parse(modalTemplate)
-	e := t.Execute(w, modalData)
-	if e != nil {
-		log.Fatalf("Error while processing data - %v", e)
+		log.Printf("Failed to render notice: %v", err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return
 	}
+	modalData.DocumentHTML = template.HTML(noticeHTML)
+
+	renderPaymentModal(w, modalData)
 }
 
 func (s *Server) handlePayAndSend(w http.ResponseWriter, r *http.Request) {
-	const amountToCharge int64 = 2900
-
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "<div class='text-red-500'>Error parsing form</div>", http.StatusBadRequest)
 		return
@@ -489,51 +429,108 @@ func (s *Server) handlePayAndSend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.FormValue("sender_role") == "" {
-        _ , err := fmt.Fprintf(w, `<div class="p-4 bg-red-100 text-red-700 border border-red-400 rounded">Error: Role is required. Please refresh and select your role.</div>`)
+	idempotencyKey := r.FormValue("idempotency_key")
+	if idempotencyKey == "" {
+		_, err := fmt.Fprintf(w, `<div class="p-4 bg-red-100 text-red-700 border border-red-400 rounded">Error: Your session expired. Please close this window and preview the notice again.</div>`)
 		if err != nil {
 			log.Fatalf("Error during formatting - %v", err)
 		}
-        return
-    }
-
-	userEmail := r.FormValue("user_email")
+		return
+	}
 
-	finalJobSite := r.FormValue("job_site_address")
-	if finalJobSite == "" {
-		finalJobSite = fmt.Sprintf("%s, %s, %s %s", 
-			r.FormValue("to_address1"), r.FormValue("to_city"), r.FormValue("to_state"), r.FormValue("to_zip"))
+	// A double-submitted form or a browser retry carries the same key as the
+	// original attempt; show that attempt's outcome instead of charging again.
+	existing, err := s.db.GetOrderByIdempotencyKey(r.Context(), idempotencyKey)
+	if err != nil {
+		log.Printf("Failed to look up order %s: %v", idempotencyKey, err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		s.renderExistingOrder(w, existing)
+		return
 	}
 
-	data := mailer.NoticeData{
-		Date:           time.Now().Format("January 2, 2006"),
-		SenderName:     r.FormValue("from_name"),
-		SenderAddress:  fmt.Sprintf("%s, %s, %s %s", r.FormValue("from_address1"), r.FormValue("from_city"), r.FormValue("from_state"), r.FormValue("from_zip")),
-		SenderRole:     r.FormValue("sender_role"),
-		OwnerName:      r.FormValue("to_name"),
-		OwnerAddress:   fmt.Sprintf("%s, %s, %s %s", r.FormValue("to_address1"), r.FormValue("to_city"), r.FormValue("to_state"), r.FormValue("to_zip")),
-		JobSiteAddress: finalJobSite,
-		JobDescription: r.FormValue("job_description"),
-		EstimatedPrice: r.FormValue("estimated_price"),
-		LenderName:     r.FormValue("lender_name"),
+	// The waivers page posts here too; its forms carry waiver_form.
+	var order *storage.Order
+	if r.FormValue("waiver_form") != "" {
+		req, errs := parseWaiverRequest(r, customerNow())
+		if len(errs) == 0 && req.Letter == nil {
+			errs.add("to_address1", "Please enter the customer's mailing address so we can mail the waiver.")
+		}
+		if len(errs) > 0 {
+			renderFieldErrors(w, errs)
+			return
+		}
+		if _, err := s.orders.RenderWaiver(req.Waiver); err != nil {
+			log.Printf("Template Execute Error: %v", err)
+			http.Error(w, "System Error", http.StatusInternalServerError)
+			return
+		}
+		order = req.Order()
+	} else {
+		req, errs := parseNoticeRequest(r, customerNow())
+		if len(errs) > 0 {
+			renderFieldErrors(w, errs)
+			return
+		}
+		if _, err := s.orders.RenderNotice(req.Notice); err != nil {
+			log.Printf("Template Execute Error: %v", err)
+			http.Error(w, "System Error", http.StatusInternalServerError)
+			return
+		}
+		order = &storage.Order{
+			Notice:      req.Notice,
+			FromAddress: req.From,
+			AmountCents: lettersTotal(req.Letters),
+			Letters:     req.Letters,
+			SendDate:    req.SendDate,
+		}
 	}
 
-	tmpl, err := template.ParseFS(templates.GetNoticeFS(), "notice.html")
-	if err != nil {
-		log.Printf("Template Parse Error: %v", err)
-		http.Error(w, "System Error", http.StatusInternalServerError)
+	// Re-check the recipients in case the preview was skipped or the form was
+	// edited since; this is the last point where nobody has been charged.
+	for _, l := range order.Letters {
+		check := s.checkAddress(r.Context(), l.Role.Label()+" address", recipientPrefix(l.Role), l.ToAddress)
+		if !check.Undeliverable {
+			continue
+		}
+		highlightField(w, check.Prefix+"_address1", check.Issue)
+		_, err := fmt.Fprintf(w, `<div class="p-4 bg-yellow-50 text-yellow-800 border border-yellow-400 rounded"><p class="font-bold">Address Error (%s):</p><p>%s</p><p class="text-sm mt-2 font-bold">You have not been charged.</p></div>`,
+			l.Role.Label(), template.HTMLEscapeString(check.Issue))
+		if err != nil {
+			log.Fatalf("Error during formatting - %v", err)
+		}
 		return
 	}
 
-	var htmlBuffer bytes.Buffer
-	if err := tmpl.Execute(&htmlBuffer, data); err != nil {
-		log.Printf("Template Execute Error: %v", err)
+	userEmail := r.FormValue("user_email")
+
+	order.IdempotencyKey = idempotencyKey
+	order.SourceToken = token
+	order.UserEmail = userEmail
+	if err := s.db.CreateOrder(r.Context(), order); err != nil {
+		if errors.Is(err, storage.ErrDuplicateOrder) {
+			if existing, err := s.db.GetOrderByIdempotencyKey(r.Context(), idempotencyKey); err == nil && existing != nil {
+				s.renderExistingOrder(w, existing)
+				return
+			}
+		}
+		log.Printf("Failed to create order for %s: %v", userEmail, err)
 		http.Error(w, "System Error", http.StatusInternalServerError)
 		return
 	}
 
-	paymentID, err := s.payment.ChargeCard(r.Context(), token, amountToCharge, userEmail)
-	if err != nil {
+	// Once the card is charged the order must run to completion even if the
+	// browser goes away, so detach from the request's cancellation.
+	ctx := context.WithoutCancel(r.Context())
+
+	if err := s.orders.Charge(ctx, order); err != nil {
+		if order.Status != storage.OrderFailed {
+			log.Printf("Order #%d charge not confirmed, resumer will finish it: %v", order.ID, err)
+			s.renderExistingOrder(w, order)
+			return
+		}
 		log.Printf("Payment Error: %v", err)
 		_, err := fmt.Fprintf(w, `<div class="p-4 bg-red-100 text-red-700 border border-red-400 rounded">Payment Declined: %s</div>`, err.Error())
 		if err != nil {
@@ -541,45 +538,19 @@ func (s *Server) handlePayAndSend(w http.ResponseWriter, r *http.Request) {
 		}		
 		return
 	}
+	paymentID := order.PaymentID
 
-	req := mailer.LetterRequest{
-		Description: fmt.Sprintf("Notice - Ref: %s", paymentID),
-		To: mailer.Address{
-			Name:           r.FormValue("to_name"),
-			AddressLine1:   r.FormValue("to_address1"),
-			AddressCity:    r.FormValue("to_city"),
-			AddressState:   r.FormValue("to_state"),
-			AddressZip:     r.FormValue("to_zip"),
-			AddressCountry: "US",
-		},
-		From: mailer.Address{
-			Name:           r.FormValue("from_name"),
-			AddressLine1:   r.FormValue("from_address1"),
-			AddressCity:    r.FormValue("from_city"),
-			AddressState:   r.FormValue("from_state"),
-			AddressZip:     r.FormValue("from_zip"),
-			AddressCountry: "US",
-		},
-		Color:        false,
-		File:         htmlBuffer.String(),
-		ExtraService: "certified",
-	}
-
-	resp, err := s.mailer.SendLetter(req)
-	if err != nil {
-		log.Printf("Mailer error: %v", err)
-
-		refundErr := s.payment.RefundPayment(r.Context(), paymentID, amountToCharge)
+	if err := s.orders.SubmitLetters(ctx, order); err != nil {
 		refundMsg := "Your card was refunded automatically."
-		if refundErr != nil {
-			log.Printf("CRITICAL: FAILED TO REFUND %s: %v", paymentID, refundErr)
-			go s.sendAdminAlert("💰 SALE: $29.00", fmt.Sprintf("Customer: %s\nEmail: %s", r.FormValue("from_name"), userEmail))
-
-			refundMsg = fmt.Sprintf("Refund failed. Please contact support with Ref: %s", paymentID)
+		if order.Status != storage.OrderRefunded {
+			refundMsg = fmt.Sprintf("Your refund is delayed. We will keep retrying it automatically. Ref: %s", paymentID)
 		}
 
 		var userErr *apierrors.UserError
 		if errors.As(err, &userErr) {
+			if l := failedLetter(order); l != nil {
+				highlightField(w, letterField(userErr.Field, l.Role), userErr.UserMessage)
+			}
 			_, e := fmt.Fprintf(w, `<div class="p-4 bg-yellow-50 text-yellow-800 border border-yellow-400 rounded"><p class="font-bold">Address Error:</p><p>%s</p><p class="text-sm mt-2 font-bold">%s</p></div>`, userErr.UserMessage, refundMsg)
 			if e != nil {
 				log.Fatalf("Error during formatting - %v", e)
@@ -595,70 +566,165 @@ func (s *Server) handlePayAndSend(w http.ResponseWriter, r *http.Request) {
 	}
 
 	go func() {
-		if err := s.db.MarkPaid(userEmail); err != nil {
-			log.Printf("ERROR: Failed to mark user %s as paid: %v", userEmail, err)
+		if err := s.orders.SendReceipt(ctx, order); err != nil {
+			log.Printf("ERROR: Receipt for order #%d not sent, resumer will retry: %v", order.ID, err)
 		}
 	}()
 
-	go func() {
-		receiptHTML := fmt.Sprintf("<h1>Notice Sent!</h1><p>Tracking: %s</p>", resp.TrackingNumber)
-		if err := s.email.Send(userEmail, "Receipt: Preliminary Notice Sent", receiptHTML); err != nil {
-			log.Printf("ERROR: Failed to send receipt email to %s: %v", userEmail, err)
+	s.renderOrderSuccess(w, order)
+}
+
+func (s *Server) handleOrderStatus(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+
+	order, err := s.db.GetOrderByIdempotencyKey(r.Context(), key)
+	if err != nil {
+		log.Printf("Failed to look up order %s: %v", key, err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return
+	}
+	if order == nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	s.renderExistingOrder(w, order)
+}
+
+// renderExistingOrder replays the outcome of an order that was already
+// submitted, polling until it settles if it is still in flight.
+func (s *Server) renderExistingOrder(w http.ResponseWriter, order *storage.Order) {
+	var err error
+	switch order.Status {
+	case storage.OrderLetterSubmitted, storage.OrderReceiptSent:
+		s.renderOrderSuccess(w, order)
+		return
+	case storage.OrderRefunded:
+		if order.Cancelled() {
+			_, err = fmt.Fprintf(w, `<div class="p-4 bg-yellow-50 text-yellow-800 border border-yellow-400 rounded"><p class="font-bold">This notice was cancelled.</p><p class="text-sm mt-2 font-bold">Your card was refunded.</p></div>`)
+			break
 		}
-	}()
-	
-	encodedURL := url.QueryEscape(resp.URL)
-	trackingLink := fmt.Sprintf("https://tools.usps.com/go/TrackConfirmAction?tLabels=%s", resp.TrackingNumber)
-
-receiptData := ReceiptData{
-        PaymentID:      paymentID,
-        TrackingNumber: resp.TrackingNumber,
-        TrackingLink:   trackingLink,
-        PDFURL:         resp.URL,
-        Name:           r.FormValue("from_name"),
-        Date:           time.Now().Format("Jan 02, 2006"),
-        JobAddress:     r.FormValue("job_site_address"),
-    }
+		_, err = fmt.Fprintf(w, `<div class="p-4 bg-yellow-50 text-yellow-800 border border-yellow-400 rounded"><p class="font-bold">This notice could not be mailed.</p><p class="text-sm mt-2 font-bold">Your card was refunded automatically.</p></div>`)
+	case storage.OrderFailed:
+		if order.Cancelled() {
+			_, err = fmt.Fprintf(w, `<div class="p-4 bg-yellow-50 text-yellow-800 border border-yellow-400 rounded"><p class="font-bold">This notice was cancelled.</p><p class="text-sm mt-2">Your refund is delayed. We will keep retrying it automatically. Ref: %s</p></div>`, order.PaymentID)
+		} else if order.PaymentID != "" {
+			_, err = fmt.Fprintf(w, `<div class="p-4 bg-red-100 text-red-700 border border-red-400 rounded">System Error: Letter generation failed. Your refund is delayed. We will keep retrying it automatically. Ref: %s</div>`, order.PaymentID)
+		} else {
+			_, err = fmt.Fprintf(w, `<div class="p-4 bg-red-100 text-red-700 border border-red-400 rounded">Payment was not completed. Please close this window and try again.</div>`)
+		}
+	default:
+		_, err = fmt.Fprintf(w, `
+			<div hx-get="/web/order-status?key=%s" hx-trigger="load delay:2s" hx-swap="outerHTML"
+				class="p-4 bg-blue-50 text-blue-800 border border-blue-200 rounded text-center">
+				<span class="inline-block animate-pulse">⏳ Your order is already being processed. Please don't pay again...</span>
+			</div>
+		`, url.QueryEscape(order.IdempotencyKey))
+	}
+	if err != nil {
+		log.Fatalf("Error during formatting - %v", err)
+	}
+}
 
-	var receiptBuf bytes.Buffer
-    if err := s.receiptTemplate.Execute(&receiptBuf, receiptData); err == nil {
-		go func() {
-			targetEmail := r.FormValue("user_email")
-			if err := s.email.Send(targetEmail, "Receipt: Preliminary Notice Sent", receiptBuf.String()); err != nil {
-				log.Printf("ERROR: Failed to send receipt (template) to %s: %v", targetEmail, err)
+func (s *Server) renderOrderSuccess(w http.ResponseWriter, order *storage.Order) {
+	var letterCards strings.Builder
+	for _, l := range order.Letters {
+		switch l.Status {
+		case storage.LetterSubmitted:
+			fmt.Fprintf(&letterCards, `
+                    <div class="bg-white border rounded-lg p-3 shadow-sm space-y-3">
+                        <p class="text-xs text-gray-500 uppercase tracking-wide font-semibold mb-1">USPS %s &middot; %s</p>
+                        %s
+                        <div hx-get="%s" hx-trigger="load" hx-swap="outerHTML">
+                            <div class="block w-full bg-gray-50 text-gray-400 px-4 py-3 rounded text-center border border-dashed border-gray-300 text-sm">
+                                <span class="inline-block animate-pulse">⏳ Generating PDF Proof...</span>
+                            </div>
+                        </div>
+                    </div>`,
+				l.MailClass.Label(),
+				template.HTMLEscapeString(l.Role.Label()+": "+l.ToAddress.Name),
+				trackingRow(l),
+				template.HTMLEscapeString(s.checkPDFURL(&l)),
+			)
+		case storage.LetterFailed, storage.LetterRefunded:
+			refund := fmt.Sprintf("%s has been refunded to your card.", orders.FormatCents(l.AmountCents))
+			if l.Status == storage.LetterFailed {
+				refund = fmt.Sprintf("Your %s refund is delayed. We will keep retrying it automatically.", orders.FormatCents(l.AmountCents))
 			}
-		}()
-    } else {
-        log.Printf("Receipt Template Error: %v", err)
-    }
+			outcome := "Not mailed to"
+			if l.CancelledAt != nil {
+				outcome = "Cancelled:"
+			}
+			fmt.Fprintf(&letterCards, `
+                    <div class="p-3 bg-yellow-50 text-yellow-800 border border-yellow-300 rounded text-sm">
+                        <p class="font-bold">%s %s</p>
+                        <p class="text-xs mt-1">%s</p>
+                    </div>`,
+				outcome,
+				template.HTMLEscapeString(l.Role.Label()+": "+l.ToAddress.Name),
+				refund,
+			)
+		}
+	}
+
+	document := "Notice"
+	if order.Waiver != nil {
+		document = "Waiver"
+	}
+	heading := document + " Sent Successfully!"
+	if orders.Scheduled(order) && order.MailedAt == nil {
+		heading = document + " Scheduled for " + order.SendDate.Format("Jan 02, 2006")
+	}
+
+	var service string
+	if data, ok := orders.ProofOfService(order); ok {
+		html, err := s.orders.RenderProofOfService(data)
+		if err != nil {
+			log.Printf("Failed to render proof of service for order #%d: %v", order.ID, err)
+		} else {
+			service = fmt.Sprintf(`
+                    <details class="border rounded-lg p-3 text-sm">
+                        <summary class="font-semibold text-gray-700 cursor-pointer">Proof of Service</summary>
+                        <p class="text-xs text-gray-500 mt-2">Print it, sign it and keep it with your job file. You will need it if you ever have to record a lien.</p>
+                        <div class="border border-gray-200 rounded bg-gray-50 mt-3 max-h-[300px] overflow-y-auto overflow-x-hidden shadow-inner">
+                            <div style="width: 816px; transform: scale(0.45); transform-origin: top left;">%s</div>
+                        </div>
+                        <a href="%s" target="_blank" class="mt-3 block w-full bg-white text-blue-700 border border-blue-600 px-4 py-2 rounded text-center font-semibold hover:bg-blue-50">
+                            Download Proof of Service (PDF)
+                        </a>
+                    </details>`,
+				html,
+				template.HTMLEscapeString(s.proofLinks.ServiceURL(order)),
+			)
+		}
+	}
+
+	var cancel string
+	if orders.Cancellable(order, time.Now()) {
+		cancel = fmt.Sprintf(`
+                    <p class="text-xs text-gray-500 text-center">Spotted a mistake? Until it goes to print you can <a href="%s" target="_blank" class="text-red-600 font-semibold hover:underline">cancel this %s for a full refund</a>.</p>`,
+			template.HTMLEscapeString(s.cancelLinks.URL(order)), strings.ToLower(document))
+	}
 
 	successHTML := fmt.Sprintf(`
         <div class="fixed inset-0 bg-gray-600 bg-opacity-50 flex items-center justify-center p-4 z-50">
-            <div class="bg-white rounded-lg shadow-xl max-w-md w-full animate-fade-in-up overflow-hidden">
+            <div class="bg-white rounded-lg shadow-xl max-w-md w-full max-h-[90vh] animate-fade-in-up overflow-y-auto">
                 <div class="bg-green-50 p-6 text-center border-b border-green-100">
                     <div class="mx-auto flex items-center justify-center h-12 w-12 rounded-full bg-green-100 mb-3">
                         <svg class="h-6 w-6 text-green-600" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M5 13l4 4L19 7"></path></svg>
                     </div>
-                    <h3 class="text-lg font-bold text-gray-900">Notice Sent Successfully!</h3>
+                    <h3 class="text-lg font-bold text-gray-900">%s</h3>
                     <p class="text-sm text-gray-500 mt-1">Ref: %s</p>
                 </div>
 
                 <div class="p-6 space-y-5">
-                    <div class="bg-white border rounded-lg p-3 shadow-sm">
-                        <p class="text-xs text-gray-500 uppercase tracking-wide font-semibold mb-1">USPS Certified Mail®</p>
-                        <div class="flex items-center justify-between">
-                            <span class="text-lg font-mono font-bold text-gray-800 select-all">%s</span>
-                            <a href="%s" target="_blank" class="text-blue-600 hover:text-blue-800 text-sm font-semibold flex items-center gap-1">
-                                Track <svg class="w-3 h-3" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M10 6H6a2 2 0 00-2 2v10a2 2 0 002 2h10a2 2 0 002-2v-4M14 4h6m0 0v6m0-6L10 14"></path></svg>
-                            </a>
-                        </div>
-                    </div>
-
-                    <div hx-get="/web/check-pdf?url=%s" hx-trigger="load" hx-swap="outerHTML">
-                        <div class="block w-full bg-gray-50 text-gray-400 px-4 py-3 rounded text-center border border-dashed border-gray-300 text-sm">
-                            <span class="inline-block animate-pulse">⏳ Generating PDF Proof...</span>
-                        </div>
-                    </div>
+                    %s
+                    %s
+                    %s
 
                     <div class="pt-4 border-t">
                         <p class="text-sm font-medium text-gray-700 mb-2 text-center">Know another contractor?</p>
@@ -676,10 +742,11 @@ receiptData := ReceiptData{
             </div>
         </div>
     `,
-		paymentID,           
-		resp.TrackingNumber, 
-		trackingLink,        
-		encodedURL,          
+		heading,
+		order.PaymentID,
+		letterCards.String(),
+		service,
+		cancel,
 	)
 
 	_, e := w.Write([]byte(successHTML))
@@ -688,6 +755,20 @@ receiptData := ReceiptData{
 	}
 }
 
+// trackingRow shows a letter's tracking number with a link to USPS, or says
+// that the mail class has none.
+func trackingRow(l storage.OrderLetter) string {
+	if l.TrackingNumber == "" {
+		return `<p class="text-sm text-gray-500">No tracking number for this mail class.</p>`
+	}
+	return fmt.Sprintf(`<div class="flex items-center justify-between">
+                            <span class="text-lg font-mono font-bold text-gray-800 select-all">%s</span>
+                            <a href="%s" target="_blank" class="text-blue-600 hover:text-blue-800 text-sm font-semibold flex items-center gap-1">
+                                Track <svg class="w-3 h-3" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M10 6H6a2 2 0 00-2 2v10a2 2 0 002 2h10a2 2 0 002-2v-4M14 4h6m0 0v6m0-6L10 14"></path></svg>
+                            </a>
+                        </div>`, l.TrackingNumber, orders.TrackingLink(l.TrackingNumber))
+}
+
 func (s *Server) handleLookupOwner(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error", http.StatusBadRequest)
@@ -749,25 +830,25 @@ func (s *Server) renderOwnerFields(w http.ResponseWriter, name, addr, city, stat
 }
 
 func (s *Server) handleCheckPDFStatus(w http.ResponseWriter, r *http.Request) {
-	pdfURL := r.URL.Query().Get("url")
-	if pdfURL == "" {
+	orderID, err1 := strconv.Atoi(r.URL.Query().Get("order"))
+	letterID, err2 := strconv.Atoi(r.URL.Query().Get("letter"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid letter", http.StatusBadRequest)
 		return
 	}
-
-	if !strings.HasPrefix(pdfURL, "https://") || !strings.Contains(pdfURL, "lob") {
-		http.Error(w, "Invalid URL", http.StatusForbidden)
+	_, l := s.proofLetter(w, r, orderID, letterID)
+	if l == nil {
 		return
 	}
 
-	resp, err := http.Head(pdfURL)
-
-	if err != nil || resp.StatusCode != http.StatusOK {
-
-		encodedURL := url.QueryEscape(pdfURL)
+	if err := s.archiver.Archive(r.Context(), l); err != nil {
+		if !errors.Is(err, worker.ErrPDFNotReady) {
+			log.Printf("Failed to archive letter %s: %v", l.LetterID, err)
+		}
 
 		_, err := fmt.Fprintf(w, `
-			<div hx-get="/web/check-pdf?url=%s" 
-				hx-trigger="load delay:1s" 
+			<div hx-get="%s" 
+				hx-trigger="load delay:3s" 
 				hx-swap="outerHTML" 
 				class="flex flex-col items-center justify-center w-full bg-gray-50 text-blue-600 px-6 py-6 rounded border border-gray-200">
 				<svg class="animate-spin h-8 w-8 text-blue-600 mb-3" xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24">
@@ -777,7 +858,7 @@ func (s *Server) handleCheckPDFStatus(w http.ResponseWriter, r *http.Request) {
 				<span class="text-sm font-semibold text-gray-600">Encrypting & Finalizing Legal Document...</span>
 				<span class="text-xs text-gray-400 mt-1">This ensures legal compliance.</span>
 			</div>
-		`, encodedURL)
+		`, template.HTMLEscapeString(r.URL.RequestURI()))
 		if err != nil {
 			log.Fatalf("Error during formatting - %v", err)
 		}
@@ -788,26 +869,43 @@ func (s *Server) handleCheckPDFStatus(w http.ResponseWriter, r *http.Request) {
 		<a href="%s" target="_blank" class="block w-full bg-blue-600 text-white px-6 py-3 rounded hover:bg-blue-700 transition text-center shadow-md font-bold">
 			View PDF Proof
 		</a>
-	`, pdfURL)
+	`, template.HTMLEscapeString(s.proofLinks.URL(l)))
 	if e != nil {
 		log.Fatalf("Error during formatting - %v", e)
 	} 
 }
 
-func (s *Server) handleCaptureLead(w http.ResponseWriter, r *http.Request) {
-	userEmail := r.FormValue("email")
+// handleNoticePDF is the "print it myself" path: it keeps the lead and sends
+// back the notice as a PDF to print and mail.
+func (s *Server) handleNoticePDF(w http.ResponseWriter, r *http.Request) {
+	req, errs := parseNoticeRequest(r, customerNow())
+	if len(errs) > 0 {
+		http.Error(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userEmail := r.FormValue("user_email")
 	userName := r.FormValue("from_name")
-	
-	go func() {
-		if err := s.db.UpsertLead(userEmail, userName); err != nil {
-			log.Printf("DB Error: %v", err)
-		}
-	}()
+	if userEmail != "" {
+		go func() {
+			if err := s.db.UpsertLead(context.WithoutCancel(r.Context()), userEmail, userName); err != nil {
+				log.Printf("DB Error: %v", err)
+			}
+		}()
+	}
 
-	w.Header().Set("Content-Type", "text/html")
-	_, err := fmt.Fprintf(w, `<script>window.print();</script>`)
+	notice, err := orders.NoticePDF(req.Notice)
 	if err != nil {
-		log.Fatalf("Error during formatting - %v", err)
+		log.Printf("Failed to lay out notice PDF: %v", err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="preliminary-notice.pdf"`)
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(notice); err != nil {
+		log.Printf("Failed to send notice PDF: %v", err)
 	}
 }
 
@@ -825,13 +923,82 @@ func (s *Server) sendAdminAlert(subject, body string) {
     }()
 }
 
+func (s *Server) handleResolveRefund(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid refund ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.orders.ResolveRefund(r.Context(), id, "Refunded manually by admin"); err != nil {
+		log.Printf("Failed to resolve refund %d: %v", id, err)
+		http.Error(w, "DB Error", 500)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (s *Server) handleRunReconciliation(w http.ResponseWriter, r *http.Request) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if _, err := s.reconciler.Run(ctx, time.Now()); err != nil {
+			log.Printf("Manual reconciliation failed: %v", err)
+		}
+	}()
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
 func (s *Server) handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
-    leads, err := s.db.GetAllLeads()
+    leads, err := s.db.GetAllLeads(r.Context())
+    if err != nil {
+        http.Error(w, "DB Error", 500)
+        return
+    }
+
+    search := strings.TrimSpace(r.URL.Query().Get("email"))
+    var orders []storage.Order
+    if search != "" {
+        orders, err = s.db.GetOrdersByEmail(r.Context(), search)
+    } else {
+        orders, err = s.db.GetRecentOrders(r.Context(), 50)
+    }
+    if err != nil {
+        log.Printf("Failed to load orders: %v", err)
+        http.Error(w, "DB Error", 500)
+        return
+    }
+
+    refunds, err := s.db.GetOpenRefunds(r.Context())
+    if err != nil {
+        log.Printf("Failed to load refund queue: %v", err)
+        http.Error(w, "DB Error", 500)
+        return
+    }
+
+    report, err := s.db.GetLatestReconciliationReport(r.Context())
     if err != nil {
+        log.Printf("Failed to load reconciliation report: %v", err)
         http.Error(w, "DB Error", 500)
         return
     }
 
+    data := struct {
+        Leads   []storage.Lead
+        Orders  []storage.Order
+        Refunds []storage.Refund
+        Report  *storage.ReconciliationReport
+        Search  string
+    }{
+        Leads:   leads,
+        Orders:  orders,
+        Refunds: refunds,
+        Report:  report,
+        Search:  search,
+    }
+
     html := `
     <!DOCTYPE html>
     <html>
@@ -845,6 +1012,67 @@ func (s *Server) handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
                 <h1 class="text-2xl font-bold text-gray-800">Lead Capture Dashboard</h1>
                 <span class="text-sm text-gray-500">Auto-refreshing...</span>
             </div>
+            <div class="bg-white shadow-md rounded-lg overflow-hidden mb-10">
+                <div class="px-5 py-3 border-b border-gray-200 flex justify-between items-center">
+                    <h2 class="text-lg font-bold text-gray-800">Reconciliation</h2>
+                    <form method="post" action="/admin/reconcile">
+                        <button type="submit" class="bg-gray-100 border text-gray-700 text-xs px-3 py-1 rounded hover:bg-gray-200">Run Now</button>
+                    </form>
+                </div>
+                {{with .Report}}
+                <div class="px-5 py-3 text-sm text-gray-600">
+                    Ran {{.CreatedAt.Format "Jan 02 15:04"}} for {{.WindowStart.Format "Jan 02 15:04"}} &ndash; {{.WindowEnd.Format "Jan 02 15:04"}}:
+                    {{.PaymentsChecked}} payments, {{.LettersChecked}} letters, {{.OrdersChecked}} orders checked.
+                    {{if not .Findings}}<span class="font-semibold text-green-700">All clear.</span>{{end}}
+                </div>
+                {{if .Findings}}
+                <table class="min-w-full leading-normal">
+                    <tbody>
+                        {{range .Findings}}
+                        <tr>
+                            <td class="px-5 py-2 border-t border-gray-100 text-xs font-semibold text-red-700">{{.Kind}}</td>
+                            <td class="px-5 py-2 border-t border-gray-100 text-xs text-gray-600">{{if .OrderID}}Order #{{.OrderID}}{{end}}</td>
+                            <td class="px-5 py-2 border-t border-gray-100 text-xs font-mono text-gray-600">{{.PaymentID}}<br>{{.LetterID}}</td>
+                            <td class="px-5 py-2 border-t border-gray-100 text-xs text-gray-700">{{.Detail}}</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                {{end}}
+                {{else}}
+                <div class="px-5 py-3 text-sm text-gray-500">No reconciliation has run yet.</div>
+                {{end}}
+            </div>
+
+            {{if .Refunds}}
+            <div class="bg-red-50 border border-red-200 shadow-md rounded-lg overflow-hidden mb-10">
+                <div class="px-5 py-3 border-b border-red-200">
+                    <h2 class="text-lg font-bold text-red-800">Refund Queue ({{len .Refunds}} unresolved)</h2>
+                </div>
+                <table class="min-w-full leading-normal">
+                    <tbody>
+                        {{range .Refunds}}
+                        <tr>
+                            <td class="px-5 py-3 border-b border-red-100 text-sm">
+                                <p class="font-bold text-gray-900">Order #{{.OrderID}} &middot; ${{printf "%.2f" (cents .AmountCents)}}</p>
+                                <p class="font-mono text-xs text-gray-600">{{.PaymentID}}</p>
+                            </td>
+                            <td class="px-5 py-3 border-b border-red-100 text-sm">
+                                <span class="font-semibold {{if eq .Status "escalated"}}text-red-700{{else}}text-yellow-700{{end}}">{{.Status}}</span>
+                                <span class="text-xs text-gray-500 block">{{.Attempts}} attempts, next {{.NextAttemptAt.Format "Jan 02 15:04"}}</span>
+                            </td>
+                            <td class="px-5 py-3 border-b border-red-100 text-xs text-red-600 max-w-sm truncate" title="{{.LastError}}">{{.LastError}}</td>
+                            <td class="px-5 py-3 border-b border-red-100 text-right">
+                                <form method="post" action="/admin/refunds/{{.ID}}/resolve" onsubmit="return confirm('Only resolve after refunding in the Square dashboard. Continue?')">
+                                    <button type="submit" class="bg-white border border-red-300 text-red-700 text-xs px-3 py-1 rounded hover:bg-red-100">Mark Refunded</button>
+                                </form>
+                            </td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+            </div>
+            {{end}}
             <div class="bg-white shadow-md rounded-lg overflow-hidden">
                 <table class="min-w-full leading-normal">
                     <thead>
@@ -856,7 +1084,7 @@ func (s *Server) handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
                         </tr>
                     </thead>
                     <tbody>
-                        {{range .}}
+                        {{range .Leads}}
                         <tr>
                             <td class="px-5 py-5 border-b border-gray-200 bg-white text-sm">
                                 <p class="text-gray-900 whitespace-no-wrap">{{.CreatedAt.Format "Jan 02 15:04"}}</p>
@@ -887,12 +1115,85 @@ func (s *Server) handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
                     </tbody>
                 </table>
             </div>
+
+            <div class="flex justify-between items-center mt-10 mb-6">
+                <h2 class="text-xl font-bold text-gray-800">Orders</h2>
+                <form method="get" action="/admin" class="flex gap-2">
+                    <input type="email" name="email" value="{{.Search}}" placeholder="Customer email" class="border rounded px-3 py-1 text-sm">
+                    <button type="submit" class="bg-blue-600 text-white text-sm px-3 py-1 rounded">Search</button>
+                </form>
+            </div>
+            <div class="bg-white shadow-md rounded-lg overflow-hidden">
+                <table class="min-w-full leading-normal">
+                    <thead>
+                        <tr>
+                            <th class="px-5 py-3 border-b-2 border-gray-200 bg-gray-100 text-left text-xs font-semibold text-gray-600 uppercase tracking-wider">Paid</th>
+                            <th class="px-5 py-3 border-b-2 border-gray-200 bg-gray-100 text-left text-xs font-semibold text-gray-600 uppercase tracking-wider">Customer</th>
+                            <th class="px-5 py-3 border-b-2 border-gray-200 bg-gray-100 text-left text-xs font-semibold text-gray-600 uppercase tracking-wider">Recipients / Job Site</th>
+                            <th class="px-5 py-3 border-b-2 border-gray-200 bg-gray-100 text-left text-xs font-semibold text-gray-600 uppercase tracking-wider">Tracking</th>
+                            <th class="px-5 py-3 border-b-2 border-gray-200 bg-gray-100 text-left text-xs font-semibold text-gray-600 uppercase tracking-wider">Refs</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Orders}}
+                        <tr>
+                            <td class="px-5 py-5 border-b border-gray-200 bg-white text-sm">
+                                <p class="text-gray-900 whitespace-no-wrap">{{.CreatedAt.Format "Jan 02 15:04"}}</p>
+                                <p class="text-xs text-gray-500">${{printf "%.2f" (cents .AmountCents)}}</p>
+                                {{if pending .}}
+                                <span class="text-xs font-semibold text-yellow-700" title="{{.Status}}">pending</span>
+                                <span class="text-xs text-gray-500 block">Mails {{.SendDate.Format "Jan 02"}}</span>
+                                {{else}}
+                                <span class="text-xs font-semibold {{if eq .Status "receipt_sent"}}text-green-700{{else if eq .Status "failed"}}text-red-700{{else}}text-yellow-700{{end}}">{{.Status}}</span>
+                                {{with .MailedAt}}<span class="text-xs text-gray-500 block">Mailed {{.Format "Jan 02"}}</span>{{end}}
+                                {{end}}
+                                {{if .LastError}}<p class="text-xs text-red-500 max-w-xs truncate" title="{{.LastError}}">{{.LastError}}</p>{{end}}
+                            </td>
+                            <td class="px-5 py-5 border-b border-gray-200 bg-white text-sm">
+                                <p class="text-gray-900 font-bold">{{.Notice.SenderName}}</p>
+                                <p class="text-gray-600">{{.UserEmail}}</p>
+                            </td>
+                            <td class="px-5 py-5 border-b border-gray-200 bg-white text-sm">
+                                {{range .Letters}}
+                                <p class="text-gray-900">{{.ToAddress.Name}} <span class="text-xs text-gray-500">{{.Role.Label}}</span>{{if .CancelledAt}} <span class="text-xs font-semibold text-gray-700">cancelled</span>{{end}}{{if ne .Status "submitted"}} <span class="text-xs font-semibold {{if eq .Status "failed"}}text-red-700{{else}}text-yellow-700{{end}}" title="{{.LastError}}">{{.Status}}</span>{{end}}</p>
+                                {{end}}
+                                <p class="text-xs text-gray-500">{{.Notice.JobSiteAddress}}</p>
+                            </td>
+                            <td class="px-5 py-5 border-b border-gray-200 bg-white text-sm">
+                                {{range .Letters}}{{if .TrackingNumber}}
+                                <div class="mb-2">
+                                    <a href="https://tools.usps.com/go/TrackConfirmAction?tLabels={{.TrackingNumber}}" target="_blank" class="font-mono text-blue-600">{{.TrackingNumber}}</a>
+                                    {{if .TrackingStatus}}<span class="text-xs font-semibold text-gray-700 block">{{.TrackingStatus}}</span>{{end}}
+                                    <span class="text-xs text-gray-400 block">ETA {{.ExpectedDelivery}}</span>
+                                </div>
+                                {{end}}{{end}}
+                            </td>
+                            <td class="px-5 py-5 border-b border-gray-200 bg-white text-xs text-gray-500">
+                                <p>Square: <span class="font-mono">{{.PaymentID}}</span>{{if .PaymentStatus}} <span class="font-semibold {{if or (eq .PaymentStatus "DISPUTED") (eq .PaymentStatus "REFUNDED")}}text-red-700{{end}}">{{.PaymentStatus}}</span>{{end}}</p>
+                                {{range .Letters}}{{if .LetterID}}<p>Lob: <a href="{{.PDFURL}}" target="_blank" class="font-mono text-blue-600">{{.LetterID}}</a></p>{{end}}{{end}}
+                                {{if cancellable .}}
+                                <form method="post" action="/admin/orders/{{.ID}}/cancel" class="mt-2" onsubmit="return confirm('Cancel every letter of this order and refund the customer?')">
+                                    <button type="submit" class="bg-white border border-red-300 text-red-700 text-xs px-3 py-1 rounded hover:bg-red-100">Cancel &amp; Refund</button>
+                                </form>
+                                {{end}}
+                            </td>
+                        </tr>
+                        {{else}}
+                        <tr><td colspan="5" class="px-5 py-5 bg-white text-sm text-gray-500">No orders found.</td></tr>
+                        {{end}}
+                    </tbody>
+                </table>
+            </div>
         </div>
     </body>
     </html>
     `
-    t, _ := template.New("admin").Parse(html)
-    e := t.Execute(w, leads)
+    t, _ := template.New("admin").Funcs(template.FuncMap{
+        "cents": func(c int64) float64 { return float64(c) / 100 },
+        "cancellable": orderCancellable,
+        "pending":     orderPending,
+    }).Parse(html)
+    e := t.Execute(w, data)
 	if e != nil {
 		log.Fatal(e)
 	}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"sendmynotice/internal/storage"
+)
+
+const migrateUsage = "usage: server migrate up | down [steps] | status"
+
+// runMigrate implements `server migrate ...`. Any failure exits non-zero so a
+// deploy step running it stops instead of starting against a half-migrated
+// schema.
+func runMigrate(args []string) {
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		log.Fatal("DATABASE_URL not set")
+	}
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	database, err := storage.NewPostgres(dbURL, dbConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		ran, err := database.MigrateUp(ctx)
+		if err != nil {
+			log.Fatalf("❌ Migration failed: %v", err)
+		}
+		log.Printf("✅ Applied %d migrations", len(ran))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("invalid step count %q\n%s", args[1], migrateUsage)
+			}
+		}
+		reverted, err := database.MigrateDown(ctx, steps)
+		if err != nil {
+			log.Fatalf("❌ Rollback failed: %v", err)
+		}
+		log.Printf("✅ Reverted %d migrations", len(reverted))
+	case "status":
+		states, err := database.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatalf("❌ Reading migration status failed: %v", err)
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-40s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
+
+// openStore connects to Postgres and brings its schema up to date. With
+// APP_ENV=local an empty DATABASE_URL falls back to in-memory storage so the
+// server and workers can run without Docker; anywhere else it is an error.
+func openStore(dbURL, appEnv string) (storage.Store, error) {
+	if dbURL == "" {
+		if appEnv != "local" {
+			return nil, fmt.Errorf("DATABASE_URL not set")
+		}
+		log.Println("⚠️  DATABASE_URL not set, using in-memory storage (nothing survives a restart)")
+		return storage.NewMemory(), nil
+	}
+
+	database, err := storage.NewPostgres(dbURL, dbConfig())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := database.MigrateUp(context.Background()); err != nil {
+		return nil, fmt.Errorf("migration failed: %w", err)
+	}
+	return database, nil
+}
+
+// dbConfig starts from storage.DefaultConfig and applies any DB_* overrides.
+func dbConfig() storage.Config {
+	cfg := storage.DefaultConfig()
+	cfg.MaxOpenConns = envInt("DB_MAX_OPEN_CONNS", cfg.MaxOpenConns)
+	cfg.MaxIdleConns = envInt("DB_MAX_IDLE_CONNS", cfg.MaxIdleConns)
+	cfg.ConnMaxLifetime = envDuration("DB_CONN_MAX_LIFETIME", cfg.ConnMaxLifetime)
+	cfg.ConnMaxIdleTime = envDuration("DB_CONN_MAX_IDLE_TIME", cfg.ConnMaxIdleTime)
+	cfg.QueryTimeout = envDuration("DB_QUERY_TIMEOUT", cfg.QueryTimeout)
+	return cfg
+}
+
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("%s must be a whole number, got %q", key, v)
+	}
+	return n
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("%s must be a duration like 5s or 30m, got %q", key, v)
+	}
+	return d
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"sendmynotice/internal/deadline"
+)
+
+// paymentModal is the preview shown before a customer pays: the rendered
+// document, any address problems, the price and the Square card form. The
+// notice and waiver pages both use it.
+type paymentModal struct {
+	Title string
+	// FormID is the page form that previews again when a USPS suggestion is
+	// accepted.
+	FormID       string
+	DocumentHTML template.HTML
+	Download     download
+
+	AddressChecks []addressCheck
+	Blocked       bool
+	// NotMailed is shown in place of the payment form when there is nothing
+	// to mail.
+	NotMailed string
+
+	// Note is printed above the price.
+	Note    string
+	Letters []letterLine
+	Details []modalDetail
+	Total   string
+	// Deadline, if set, adds the deadline warning above the pay button.
+	Deadline *deadline.Window
+
+	PayLabel     string
+	HiddenInputs map[string]string
+	SquareAppID  string
+	SquareLocID  string
+}
+
+// download posts the same inputs to a handler that returns the document as
+// a PDF. Primary shows it as a button above the price rather than a link
+// below it.
+type download struct {
+	Action  string
+	Label   string
+	Primary bool
+}
+
+// modalDetail is one line under the letters in the price summary.
+type modalDetail struct {
+	Label string
+	Value string
+	Late  bool
+}
+
+// Payable reports whether the card form is shown.
+func (m paymentModal) Payable() bool {
+	return m.NotMailed == "" && !m.Blocked
+}
+
+func renderPaymentModal(w http.ResponseWriter, data paymentModal) {
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	if err := paymentModalTemplate.Execute(w, data); err != nil {
+		log.Printf("Payment modal failed to render: %v", err)
+	}
+}
+
+var paymentModalTemplate = template.Must(template.New("payment-modal").Parse(`
+	<div class="fixed inset-0 z-50 overflow-y-auto" aria-labelledby="modal-title" role="dialog" aria-modal="true">
+		<div class="flex items-end justify-center min-h-screen pt-4 px-4 pb-20 text-center sm:block sm:p-0">
+			<div class="fixed inset-0 bg-gray-500 bg-opacity-75 transition-opacity" onclick="document.getElementById('result').innerHTML=''"></div>
+
+			<div class="inline-block align-bottom bg-white rounded-lg text-left overflow-hidden shadow-xl transform transition-all sm:my-8 sm:align-middle sm:max-w-lg sm:w-full">
+				<div class="bg-white px-4 pt-5 pb-4 sm:p-6 sm:pb-4">
+					<div class="flex justify-between items-center mb-4">
+						<h3 class="text-lg leading-6 font-bold text-gray-900" id="modal-title">{{.Title}}</h3>
+						<button onclick="document.getElementById('result').innerHTML=''" class="text-gray-400 hover:text-gray-500">
+							<svg class="h-6 w-6" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M6 18L18 6M6 6l12 12"></path></svg>
+						</button>
+					</div>
+
+					<div class="border border-gray-200 rounded-md bg-gray-50 mb-6 max-h-[500px] overflow-y-auto shadow-inner overflow-x-hidden relative">
+						<div style="width: 60vw; transform: scale(0.45); transform-origin: top left;">
+							{{.DocumentHTML}}
+						</div>
+					</div>
+
+					{{if .Download.Primary}}
+					<form method="post" action="{{.Download.Action}}" class="mb-4">
+						{{range $key, $value := .HiddenInputs}}
+							<input type="hidden" name="{{$key}}" value="{{$value}}">
+						{{end}}
+						<button type="submit" class="w-full inline-flex justify-center rounded-md border border-blue-600 px-4 py-3 bg-white text-base font-medium text-blue-700 hover:bg-blue-50 sm:text-sm">
+							{{.Download.Label}}
+						</button>
+					</form>
+					{{end}}
+
+					{{range $check := .AddressChecks}}
+						{{if .Undeliverable}}
+						<div class="bg-red-50 border border-red-200 p-3 rounded mb-3 text-left">
+							<p class="text-sm font-bold text-red-800">{{.Label}} cannot be delivered</p>
+							<p class="text-xs text-red-700 mt-1">{{.Issue}} Close this window, correct it and preview again.</p>
+						</div>
+						{{else if or .Issue .Suggestion}}
+						<div class="bg-yellow-50 border border-yellow-200 p-3 rounded mb-3 text-left">
+							<p class="text-sm font-bold text-yellow-800">{{.Label}}</p>
+							{{if .Issue}}<p class="text-xs text-yellow-800 mt-1">{{.Issue}}</p>{{end}}
+							{{with .Suggestion}}
+							<p class="text-xs text-yellow-800 mt-1">USPS writes this address as:</p>
+							<p class="text-xs font-mono text-gray-800 mt-1">{{.AddressLine1}}, {{.AddressCity}}, {{.AddressState}} {{.AddressZip}}</p>
+							<button type="button" class="mt-2 text-xs font-bold text-blue-700 underline"
+								onclick="useCorrectedAddress('{{$check.Prefix}}', '{{.AddressLine1}}', '{{.AddressCity}}', '{{.AddressState}}', '{{.AddressZip}}')">
+								Use this address
+							</button>
+							{{end}}
+						</div>
+						{{end}}
+					{{end}}
+
+					{{if .NotMailed}}
+					<div class="bg-gray-50 p-4 rounded-md border border-gray-200 text-center text-xs text-gray-600">
+						{{.NotMailed}}
+					</div>
+					{{else if .Blocked}}
+					<div class="bg-gray-50 p-4 rounded-md border border-gray-200 text-center text-sm text-gray-600">
+						Payment is disabled until the address can be delivered. You have not been charged.
+					</div>
+					{{else}}
+					<div class="bg-blue-50 p-4 rounded-md border border-blue-100">
+						{{if .Note}}<p class="text-xs text-blue-900 mb-3">{{.Note}}</p>{{end}}
+						{{range .Letters}}
+						<div class="flex justify-between items-center text-xs text-blue-900 mb-1">
+							<span>{{.MailClass}} to {{.Name}} ({{.Recipient}})</span>
+							<span>{{.Price}}</span>
+						</div>
+						{{end}}
+						{{range .Details}}
+						<div class="flex justify-between items-center text-xs text-blue-900 mb-1">
+							<span>{{.Label}}</span>
+							<span class="font-semibold {{if .Late}}text-red-600{{end}}">{{.Value}}</span>
+						</div>
+						{{end}}
+						<div class="flex justify-between items-center mb-3 mt-2">
+							<span class="font-bold text-blue-900">Total</span>
+							<span class="font-bold text-blue-900 text-xl">{{.Total}}</span>
+						</div>
+
+						<div id="card-container" class="min-h-[50px] mb-4 bg-white rounded p-1"></div>
+
+						<form id="payment-form" hx-post="/web/pay-and-send" hx-target="#result" hx-swap="innerHTML">
+							{{range $key, $value := .HiddenInputs}}
+								<input type="hidden" name="{{$key}}" value="{{$value}}">
+							{{end}}
+							<input type="hidden" name="square_token" id="square_token_input">
+
+							<div class="mb-4 flex items-start">
+								<div class="flex items-center h-5">
+									<input id="tos_agree" name="tos_agree" type="checkbox" required
+										class="focus:ring-blue-500 h-4 w-4 text-blue-600 border-gray-300 rounded"
+										onchange="
+											const btn = document.getElementById('card-button');
+											btn.disabled = !this.checked;
+											btn.classList.toggle('opacity-50', !this.checked);
+											btn.classList.toggle('cursor-not-allowed', !this.checked);
+											btn.classList.toggle('cursor-pointer', this.checked);
+										">
+								</div>
+								<div class="ml-2 text-xs text-gray-600 text-left">
+									I agree to the <button type="button" onclick="document.getElementById('tos-modal').classList.remove('hidden')" class="text-blue-600 underline">Terms of Service</button> and understand that SendMyNotice is a filing service, not a law firm.
+								</div>
+							</div>
+
+							{{with .Deadline}}
+							<div class="{{if .Late}}bg-red-50 border-red-100{{else}}bg-yellow-50 border-yellow-100{{end}} border p-2 rounded mb-4 flex items-start gap-2">
+								<svg class="w-4 h-4 text-yellow-600 mt-0.5" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M12 8v4l3 3m6-3a9 9 0 11-18 0 9 9 0 0118 0z"></path></svg>
+								<div class="text-[10px] text-yellow-800 text-left">
+									<strong>Deadline Warning:</strong> {{.Summary}} USPS pickup is at 4:00 PM.
+								</div>
+							</div>
+							{{end}}
+
+							<button type="button" id="card-button" disabled class="w-full inline-flex justify-center rounded-md border border-transparent shadow-sm px-4 py-3 bg-green-600 text-base font-medium text-white hover:bg-green-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-green-500 sm:text-sm transition opacity-50 cursor-not-allowed">
+								{{.PayLabel}}
+							</button>
+
+							<div class="mt-4 flex items-center justify-center gap-3 bg-gray-50 p-2 rounded border border-gray-100">
+								<div class="flex items-center gap-1">
+									<svg class="w-4 h-4 text-gray-500" fill="currentColor" viewBox="0 0 24 24"><path d="M18 8h-1V6c0-2.76-2.24-5-5-5S7 3.24 7 6v2H6c-1.1 0-2 .9-2 2v10c0 1.1.9 2 2 2h12c1.1 0 2-.9 2-2V10c0-1.1-.9-2-2-2zm-6 9c-1.1 0-2-.9-2-2s.9-2 2-2 2 .9 2 2-.9 2-2 2zm3.1-9H8.9V6c0-1.71 1.39-3.1 3.1-3.1 1.71 0 3.1 1.39 3.1 3.1v2z"/></svg>
+									<span class="text-[10px] font-bold text-gray-500 uppercase tracking-wide">256-Bit SSL Encrypted</span>
+								</div>
+								<div class="h-3 w-px bg-gray-300"></div>
+								<div class="flex items-center gap-1">
+									<svg class="w-4 h-4 text-gray-500" fill="currentColor" viewBox="0 0 24 24"><path d="M20 4H4c-1.11 0-1.99.89-1.99 2L2 18c0 1.11.89 2 2 2h16c1.11 0 2-.89 2-2V6c0-1.11-.89-2-2-2zm0 14H4v-6h16v6zm0-10H4V6h16v2z"/></svg>
+									<span class="text-[10px] font-bold text-gray-500 uppercase tracking-wide">Secure Payment</span>
+								</div>
+							</div>
+							<p class="text-[9px] text-gray-400 text-center mt-2">
+								We do not store your credit card details. Payments are processed securely by Square®.
+							</p>
+						</form>
+						<div id="payment-status-container" class="mt-2 text-center text-xs text-red-600 font-bold min-h-[20px]"></div>
+					</div>
+					{{end}}
+				</div>
+				{{if not .Download.Primary}}
+				<div class="bg-gray-50 px-4 py-3 sm:px-6 flex justify-center">
+					<form method="post" action="{{.Download.Action}}">
+						{{range $key, $value := .HiddenInputs}}
+							<input type="hidden" name="{{$key}}" value="{{$value}}">
+						{{end}}
+						<button type="submit" class="text-xs text-gray-400 hover:text-gray-600 underline">
+							{{.Download.Label}}
+						</button>
+					</form>
+				</div>
+				{{end}}
+			</div>
+		</div>
+
+		<script>
+			async function initializeCard(appId, locationId, payLabel) {
+				if (!window.Square) {
+					console.error("Square JS not loaded");
+					return;
+				}
+
+				try {
+					const payments = Square.payments(appId, locationId);
+					const card = await payments.card();
+					await card.attach('#card-container');
+
+					document.getElementById('card-button').addEventListener('click', async () => {
+						const statusContainer = document.getElementById('payment-status-container');
+						const btn = document.getElementById('card-button');
+
+						// Disable button to prevent double charge
+						btn.disabled = true;
+						btn.innerText = "Processing...";
+						statusContainer.innerText = "";
+
+						try {
+							const result = await card.tokenize();
+							if (result.status === 'OK') {
+								// Inject token into hidden field inside the form
+								document.getElementById('square_token_input').value = result.token;
+								// Trigger HTMX manually on the form
+								htmx.trigger('#payment-form', 'submit');
+							} else {
+								statusContainer.innerText = result.errors[0].message;
+								btn.disabled = false;
+								btn.innerText = payLabel;
+							}
+						} catch (e) {
+							console.error(e);
+							statusContainer.innerText = "Payment System Error. Try again.";
+							btn.disabled = false;
+							btn.innerText = payLabel;
+						}
+					});
+				} catch (e) {
+					console.error("Square Init Error:", e);
+				}
+			}
+			// Writes the USPS version of an address back into the page's form
+			// and previews again, so the document and the letter both use it.
+			function useCorrectedAddress(prefix, line1, city, state, zip) {
+				const form = document.getElementById({{.FormID}});
+				form.querySelector('[name="' + prefix + '_address1"]').value = line1;
+				form.querySelector('[name="' + prefix + '_city"]').value = city;
+				form.querySelector('[name="' + prefix + '_state"]').value = state;
+				form.querySelector('[name="' + prefix + '_zip"]').value = zip;
+				htmx.trigger(form, 'submit');
+			}
+			{{if .Payable}}initializeCard('{{.SquareAppID}}', '{{.SquareLocID}}', {{.PayLabel}});{{end}}
+		</script>
+	</div>
+`))
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"sendmynotice/internal/deadline"
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/states"
+)
+
+func TestPaymentModal(t *testing.T) {
+	window := deadline.Compute(states.California.Deadline,
+		time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC))
+	notice := paymentModal{
+		Title:        "Confirm & Send",
+		FormID:       "notice-form",
+		DocumentHTML: "<p>notice body</p>",
+		Download:     download{Action: "/web/notice.pdf", Label: "No thanks, I'll print it myself"},
+		Letters:      []letterLine{{Recipient: "Owner", Name: "Pat Owner", MailClass: "Certified", Price: "$29.00"}},
+		Details:      []modalDetail{{Label: "Deadline to cover all work", Value: "Mar 21, 2026"}},
+		Total:        "$29.00",
+		Deadline:     &window,
+		PayLabel:     "Pay & Send Notice",
+		HiddenInputs: map[string]string{"idempotency_key": "key-1"},
+		SquareAppID:  "app-1",
+		SquareLocID:  "loc-1",
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(*paymentModal)
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "notice ready to pay",
+			want: []string{
+				"<p>notice body</p>", "Pat Owner", "$29.00", "Deadline Warning:", `action="/web/notice.pdf"`,
+				`name="idempotency_key" value="key-1"`, `getElementById("notice-form")`,
+				`initializeCard('app-1', 'loc-1', "Pay \u0026 Send Notice")`,
+			},
+		},
+		{
+			name: "suggested address",
+			edit: func(m *paymentModal) {
+				m.AddressChecks = []addressCheck{{Label: "Owner address", Prefix: "to", Suggestion: &mailer.Address{AddressLine1: "1 MAIN ST", AddressCity: "SACRAMENTO", AddressState: "CA", AddressZip: "95814"}}}
+			},
+			want: []string{"USPS writes this address as:", "useCorrectedAddress('to', '1 MAIN ST'", "initializeCard("},
+		},
+		{
+			name: "undeliverable address blocks payment",
+			edit: func(m *paymentModal) {
+				m.AddressChecks = []addressCheck{{Label: "Owner address", Undeliverable: true, Issue: "No such street."}}
+				m.Blocked = true
+			},
+			want:    []string{"Owner address cannot be delivered", "Payment is disabled"},
+			notWant: []string{`id="card-container"`, "initializeCard('"},
+		},
+		{
+			name: "waiver without a letter",
+			edit: func(m *paymentModal) {
+				m.Title = "Conditional Waiver and Release on Progress Payment"
+				m.Download = download{Action: "/web/waivers/waiver.pdf", Label: "Download to Print & Sign (PDF)", Primary: true}
+				m.Deadline = nil
+				m.Letters = nil
+				m.NotMailed = "Want us to mail it?"
+			},
+			want:    []string{"Conditional Waiver", "Download to Print &amp; Sign (PDF)", "Want us to mail it?"},
+			notWant: []string{`id="card-container"`, "Deadline Warning:", "initializeCard('"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := notice
+			if tt.edit != nil {
+				tt.edit(&m)
+			}
+			var b strings.Builder
+			if err := paymentModalTemplate.Execute(&b, m); err != nil {
+				t.Fatal(err)
+			}
+			out := b.String()
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("modal is missing %q", s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(out, s) {
+					t.Errorf("modal contains %q", s)
+				}
+			}
+		})
+	}
+}
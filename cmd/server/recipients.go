@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/orders"
+	"sendmynotice/internal/states"
+	"sendmynotice/internal/storage"
+)
+
+// recipientFields says which form fields hold each recipient's address, in
+// the order the letters are created.
+var recipientFields = []struct {
+	Role   storage.Recipient
+	Prefix string
+}{
+	{storage.RecipientOwner, "to"},
+	{storage.RecipientDirectContractor, "contractor"},
+	{storage.RecipientLender, "lender"},
+}
+
+// letterLine is one row of the price breakdown shown before payment.
+type letterLine struct {
+	Recipient string
+	Name      string
+	MailClass string
+	Price     string
+}
+
+// mailClassOption is one choice of mail class on the home page form.
+type mailClassOption struct {
+	Value    mailer.MailClass
+	Label    string
+	Price    string
+	Tracked  bool
+	Selected bool
+}
+
+func mailClassOptions() []mailClassOption {
+	var opts []mailClassOption
+	for _, c := range mailer.MailClasses {
+		cents, err := orders.LetterPrice(c)
+		if err != nil {
+			continue
+		}
+		opts = append(opts, mailClassOption{
+			Value:    c,
+			Label:    c.Label(),
+			Price:    orders.FormatCents(cents),
+			Tracked:  c.Tracked(),
+			Selected: c == mailer.Certified,
+		})
+	}
+	return opts
+}
+
+// formLetters returns one letter per recipient on the form, all sent with
+// the chosen mail class. The owner is always included; the direct
+// contractor and the lender only when their address was filled in and the
+// job site's state serves them. Problems with any of them are added to errs.
+func formLetters(r *http.Request, rules *states.Rules, errs *FieldErrors) []storage.OrderLetter {
+	class := mailer.MailClass(r.FormValue("mail_class"))
+	if class == "" {
+		class = rules.MailClass
+	}
+	price, err := orders.LetterPrice(class)
+	if err != nil {
+		errs.add("mail_class", "Please choose how the notice should be mailed.")
+	}
+
+	var letters []storage.OrderLetter
+	for _, f := range recipientFields {
+		addr := formAddress(r, f.Prefix)
+		if f.Role != storage.RecipientOwner && addr.AddressLine1 == "" {
+			continue
+		}
+		if !rules.Serves(f.Role) {
+			errs.add(f.Prefix+"_address1", fmt.Sprintf("%s notices are not sent to the %s. Please leave that address blank.", rules.Name, strings.ToLower(f.Role.Label())))
+			continue
+		}
+		if addr.Name == "" {
+			errs.add(f.Prefix+"_name", fmt.Sprintf("Please enter the %s's name.", f.Role.Label()))
+		}
+		validateAddress(errs, f.Prefix, "the "+f.Role.Label()+"'s", addr)
+		letters = append(letters, storage.OrderLetter{
+			Role:        f.Role,
+			ToAddress:   addr,
+			MailClass:   class,
+			AmountCents: price,
+		})
+	}
+	return letters
+}
+
+func recipientPrefix(role storage.Recipient) string {
+	for _, f := range recipientFields {
+		if f.Role == role {
+			return f.Prefix
+		}
+	}
+	if role == waiverRecipient.Role {
+		return waiverRecipient.Prefix
+	}
+	return ""
+}
+
+// letterField moves a form field from a Lob error onto the inputs of the
+// recipient the letter was for; Lob calls every recipient "to".
+func letterField(field string, role storage.Recipient) string {
+	if rest, ok := strings.CutPrefix(field, "to_"); ok {
+		return recipientPrefix(role) + "_" + rest
+	}
+	return field
+}
+
+// failedLetter is the first letter of o that could not be mailed. Its error
+// is the one SubmitLetters returns.
+func failedLetter(o *storage.Order) *storage.OrderLetter {
+	for i := range o.Letters {
+		if l := &o.Letters[i]; l.Status != storage.LetterSubmitted && l.CancelledAt == nil {
+			return l
+		}
+	}
+	return nil
+}
+
+func lettersTotal(letters []storage.OrderLetter) int64 {
+	var total int64
+	for _, l := range letters {
+		total += l.AmountCents
+	}
+	return total
+}
+
+func oneLine(a mailer.Address) string {
+	if a.AddressLine1 == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s, %s, %s %s", a.AddressLine1, a.AddressCity, a.AddressState, a.AddressZip)
+}
+
+// addRecipients prints every recipient of the letters on the notice itself.
+func addRecipients(data *mailer.NoticeData, letters []storage.OrderLetter) {
+	for _, l := range letters {
+		switch l.Role {
+		case storage.RecipientDirectContractor:
+			data.DirectContractorName = l.ToAddress.Name
+			data.DirectContractorAddress = oneLine(l.ToAddress)
+		case storage.RecipientLender:
+			data.LenderName = l.ToAddress.Name
+			data.LenderAddress = oneLine(l.ToAddress)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"sendmynotice/internal/deadline"
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/states"
+	"sendmynotice/internal/storage"
+)
+
+// NoticeRequest is the notice form once it has been read and checked. The
+// preview, the printable PDF and payment all start from one.
+type NoticeRequest struct {
+	Rules    *states.Rules
+	From     mailer.Address
+	Letters  []storage.OrderLetter
+	SendDate *time.Time
+	Deadline deadline.Window
+	// Notice is what is printed, filled in from everything above.
+	Notice mailer.NoticeData
+}
+
+// FieldError is what is wrong with one input of the notice form.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FieldErrors are all the problems found on the form.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Message
+	}
+	return strings.Join(messages, " ")
+}
+
+func (e *FieldErrors) add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// senderRoles are the roles offered on the home page form.
+var senderRoles = []string{"Subcontractor", "Direct Contractor", "Material Supplier", "Equipment Lessor"}
+
+// maxAddressLine is the longest name or address line Lob accepts.
+const maxAddressLine = 40
+
+var (
+	zipPattern   = regexp.MustCompile(`^\d{5}(-\d{4})?$`)
+	statePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// parseNoticeRequest reads the notice form and checks every field of it. If
+// anything is wrong the request is nil and every problem is returned, not
+// just the first.
+func parseNoticeRequest(r *http.Request, now time.Time) (*NoticeRequest, FieldErrors) {
+	var errs FieldErrors
+	req := &NoticeRequest{From: formAddress(r, "from")}
+
+	if req.From.Name == "" {
+		errs.add("from_name", "Please enter your company name.")
+	}
+	validateAddress(&errs, "from", "your", req.From)
+
+	rules, err := formRules(r)
+	if err != nil {
+		errs.add("job_site_state", err.Error())
+	}
+	req.Rules = rules
+
+	role := strings.TrimSpace(r.FormValue("sender_role"))
+	if !slices.Contains(senderRoles, role) {
+		errs.add("sender_role", "You must select a specific Role (e.g., Subcontractor) to generate a valid legal notice.")
+	}
+	description := strings.TrimSpace(r.FormValue("job_description"))
+	if description == "" {
+		errs.add("job_description", "Please describe the labor or materials you furnished.")
+	}
+	price := strings.TrimPrefix(strings.TrimSpace(r.FormValue("estimated_price")), "$")
+	if !validPrice(price) {
+		errs.add("estimated_price", "Please enter the estimated price as a number, e.g. 5,000.00.")
+	}
+
+	if rules != nil {
+		req.Letters = formLetters(r, rules, &errs)
+	}
+
+	req.SendDate, err = formSendDate(r, now)
+	if err != nil {
+		errs.add("send_date", err.Error())
+	}
+	if rules != nil {
+		req.Deadline, err = formDeadline(r, rules, req.SendDate, now)
+		if err != nil {
+			errs.add("first_furnished_date", err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	owner := req.Letters[0].ToAddress
+	jobSite := strings.TrimSpace(r.FormValue("job_site_address"))
+	if jobSite == "" {
+		jobSite = oneLine(owner)
+	}
+	req.Notice = mailer.NoticeData{
+		Date:           noticeDate(req.SendDate, now),
+		SenderName:     req.From.Name,
+		SenderAddress:  oneLine(req.From),
+		SenderRole:     role,
+		OwnerName:      owner.Name,
+		OwnerAddress:   oneLine(owner),
+		JobSiteAddress: jobSite,
+		JobDescription: description,
+		EstimatedPrice: price,
+		State:          rules.Code,
+
+		FirstFurnishedDate: req.Deadline.FirstFurnished.Format(deadline.Layout),
+		MailBy:             req.Deadline.MailBy.Format(deadline.Layout),
+		CoveredFrom:        req.Deadline.CoveredFrom.Format(deadline.Layout),
+	}
+	if rules.Serves(storage.RecipientLender) {
+		req.Notice.LenderName = strings.TrimSpace(r.FormValue("lender_name"))
+	}
+	addRecipients(&req.Notice, req.Letters)
+	return req, nil
+}
+
+// validateAddress checks the address fields with the given prefix the way
+// Lob will once the letter is sent. whose says in messages whose address it
+// is, e.g. "your" or "the Owner's". A missing name is left to the caller.
+func validateAddress(errs *FieldErrors, prefix, whose string, a mailer.Address) {
+	if utf8.RuneCountInString(a.Name) > maxAddressLine {
+		errs.add(prefix+"_name", fmt.Sprintf("Please shorten %s name to %d characters; USPS cannot print a longer line.", whose, maxAddressLine))
+	}
+	switch {
+	case a.AddressLine1 == "":
+		errs.add(prefix+"_address1", fmt.Sprintf("Please enter %s mailing address.", whose))
+	case utf8.RuneCountInString(a.AddressLine1) > maxAddressLine:
+		errs.add(prefix+"_address1", fmt.Sprintf("Please shorten %s address to %d characters; USPS cannot print a longer line.", whose, maxAddressLine))
+	}
+	if a.AddressCity == "" {
+		errs.add(prefix+"_city", fmt.Sprintf("Please enter %s city.", whose))
+	}
+	if !statePattern.MatchString(a.AddressState) {
+		errs.add(prefix+"_state", fmt.Sprintf("Please enter %s state as two letters, e.g. CA.", whose))
+	}
+	if !zipPattern.MatchString(a.AddressZip) {
+		errs.add(prefix+"_zip", fmt.Sprintf("Please enter %s ZIP code as 5 digits, or ZIP+4.", whose))
+	}
+}
+
+// validPrice reports whether price is a positive amount of dollars, written
+// with or without thousands separators.
+func validPrice(price string) bool {
+	v, err := strconv.ParseFloat(strings.ReplaceAll(price, ",", ""), 64)
+	return err == nil && v > 0 && !math.IsInf(v, 0)
+}
+
+// renderFieldErrors answers the form with every problem found on it: a
+// summary where the preview would have gone, and a fieldErrors event that
+// the home page uses to mark each input.
+func renderFieldErrors(w http.ResponseWriter, errs FieldErrors) {
+	trigger, err := json.Marshal(map[string]any{
+		"fieldErrors": map[string]any{"errors": errs},
+	})
+	if err != nil {
+		log.Printf("Failed to encode field errors: %v", err)
+	} else {
+		w.Header().Set("HX-Trigger", string(trigger))
+	}
+
+	var items strings.Builder
+	for _, fe := range errs {
+		fmt.Fprintf(&items, "<li>%s</li>", template.HTMLEscapeString(fe.Message))
+	}
+	_, err = fmt.Fprintf(w, `<div class="p-4 bg-red-100 text-red-700 border border-red-400 rounded"><p class="font-bold">Please fix the highlighted fields:</p><ul class="list-disc ml-5 mt-2 text-sm">%s</ul></div>`, items.String())
+	if err != nil {
+		log.Fatalf("Error during formatting - %v", err)
+	}
+}
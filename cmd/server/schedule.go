@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	// The image has no zoneinfo, so the forms' time zone is built in.
+	_ "time/tzdata"
+
+	"sendmynotice/internal/deadline"
+	"sendmynotice/internal/orders"
+	"sendmynotice/internal/states"
+	"sendmynotice/internal/storage"
+)
+
+// customerZone is the time zone whose date is "today" on the forms. Every
+// state we serve is on Pacific time or ahead of it, so whatever day a
+// customer calls today has begun there too.
+var customerZone = func() *time.Location {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}()
+
+// customerNow is the current time in customerZone. The forms are checked
+// against it rather than the server's clock, which runs on UTC.
+func customerNow() time.Time {
+	return time.Now().In(customerZone)
+}
+
+// dateOf is the calendar day of t where t was taken, as midnight UTC like
+// the dates parsed from the forms.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// formSendDate reads the optional mailing date from the form. No date, or
+// today's, means the notice is mailed right away and nil is returned.
+func formSendDate(r *http.Request, now time.Time) (*time.Time, error) {
+	v := strings.TrimSpace(r.FormValue("send_date"))
+	if v == "" {
+		return nil, nil
+	}
+	day, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return nil, errors.New("Please enter the mailing date as YYYY-MM-DD.")
+	}
+
+	today := dateOf(now)
+	switch {
+	case day.Before(today):
+		return nil, errors.New("The mailing date can't be in the past.")
+	case day.Equal(today):
+		return nil, nil
+	case day.After(today.Add(orders.MaxScheduleAhead)):
+		return nil, errors.New("Notices can be scheduled at most 180 days ahead.")
+	}
+	return &day, nil
+}
+
+// formDeadline reads the day work was first furnished and works out the
+// notice's deadline for the job site's state, given the day it is mailed.
+func formDeadline(r *http.Request, rules *states.Rules, sendDate *time.Time, now time.Time) (deadline.Window, error) {
+	v := strings.TrimSpace(r.FormValue("first_furnished_date"))
+	if v == "" {
+		return deadline.Window{}, errors.New("Please enter the date you first furnished labor or materials.")
+	}
+	first, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return deadline.Window{}, errors.New("Please enter the first furnished date as YYYY-MM-DD.")
+	}
+	mailOn := now
+	if sendDate != nil {
+		mailOn = *sendDate
+	}
+	if first.After(mailOn.Add(orders.MaxScheduleAhead)) {
+		return deadline.Window{}, errors.New("The first furnished date is too far in the future.")
+	}
+	return deadline.Compute(rules.Deadline, first, mailOn), nil
+}
+
+// noticeDate is the date printed on the notice: the day it is mailed.
+func noticeDate(sendDate *time.Time, now time.Time) string {
+	if sendDate != nil {
+		return sendDate.Format("January 2, 2006")
+	}
+	return now.Format("January 2, 2006")
+}
+
+// orderPending is used by the admin dashboard to mark scheduled orders that
+// are paid for but still waiting for their mailing date.
+func orderPending(o storage.Order) bool {
+	if o.Status != storage.OrderLetterSubmitted && o.Status != storage.OrderReceiptSent {
+		return false
+	}
+	return orders.Scheduled(&o) && o.MailedAt == nil
+}
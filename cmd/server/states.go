@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sendmynotice/internal/states"
+	"sendmynotice/internal/storage"
+)
+
+// stateOption is one job site state on the home page form.
+type stateOption struct {
+	Code         string
+	Name         string
+	ServesLender bool
+	Selected     bool
+}
+
+func stateOptions() []stateOption {
+	var opts []stateOption
+	for _, rules := range states.All {
+		opts = append(opts, stateOption{
+			Code:         rules.Code,
+			Name:         rules.Name,
+			ServesLender: rules.Serves(storage.RecipientLender),
+			Selected:     rules == states.California,
+		})
+	}
+	return opts
+}
+
+// formRules are the rules of the job site's state picked on the form.
+func formRules(r *http.Request) (*states.Rules, error) {
+	rules, err := states.For(r.FormValue("job_site_state"))
+	if err != nil {
+		var names []string
+		for _, s := range states.All {
+			names = append(names, s.Name)
+		}
+		return nil, fmt.Errorf("We can only prepare notices for job sites in %s.", strings.Join(names, ", "))
+	}
+	return rules, nil
+}
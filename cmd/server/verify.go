@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"sendmynotice/internal/mailer"
+)
+
+// addressCheck is what the preview modal shows about one address after
+// asking Lob whether USPS can deliver to it.
+type addressCheck struct {
+	Label         string
+	Prefix        string
+	Issue         string
+	Undeliverable bool
+	// Suggestion is set when USPS writes the address differently from how
+	// it was typed; the customer can accept it with one click.
+	Suggestion *mailer.Address
+}
+
+func formAddress(r *http.Request, prefix string) mailer.Address {
+	field := func(name string) string {
+		return strings.TrimSpace(r.FormValue(prefix + name))
+	}
+	return mailer.Address{
+		Name:           field("_name"),
+		AddressLine1:   field("_address1"),
+		AddressCity:    field("_city"),
+		AddressState:   strings.ToUpper(field("_state")),
+		AddressZip:     field("_zip"),
+		AddressCountry: "US",
+	}
+}
+
+// checkAddress verifies addr with Lob. If Lob cannot be reached the address
+// is let through; a bad address will still be caught when the letter is
+// submitted, and the customer refunded.
+func (s *Server) checkAddress(ctx context.Context, label, prefix string, addr mailer.Address) addressCheck {
+	check := addressCheck{Label: label, Prefix: prefix}
+
+	v, err := s.mailer.VerifyAddress(ctx, addr)
+	if err != nil {
+		log.Printf("Address verification for %s failed, skipping: %v", label, err)
+		return check
+	}
+
+	check.Issue = v.Issue()
+	check.Undeliverable = !v.Deliverable()
+	if v.Deliverable() && v.Changed(addr) {
+		corrected := v.Corrected(addr)
+		check.Suggestion = &corrected
+	}
+	return check
+}
+
+// highlightField tells the home page which input an error is about. htmx
+// raises the HX-Trigger event once the response is swapped in, and the page
+// marks the input. It must be called before anything is written to w.
+func highlightField(w http.ResponseWriter, field, message string) {
+	if field == "" {
+		return
+	}
+	trigger, err := json.Marshal(map[string]any{
+		"fieldError": map[string]string{"field": field, "message": message},
+	})
+	if err != nil {
+		log.Printf("Failed to encode field error for %s: %v", field, err)
+		return
+	}
+	w.Header().Set("HX-Trigger", string(trigger))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/mailer/lobfake"
+)
+
+func TestCheckAddress(t *testing.T) {
+	srv := httptest.NewServer(lobfake.New())
+	defer srv.Close()
+	s := &Server{mailer: mailer.NewClient("test_key", srv.URL+"/v1")}
+
+	addr := func(line1, zip string) mailer.Address {
+		return mailer.Address{Name: "Owner", AddressLine1: line1, AddressCity: "Sacramento", AddressState: "CA", AddressZip: zip, AddressCountry: "US"}
+	}
+
+	tests := []struct {
+		name          string
+		addr          mailer.Address
+		undeliverable bool
+		issue         bool
+		suggestion    string
+	}{
+		{name: "deliverable as typed", addr: addr("123 MAIN ST", "95814")},
+		{name: "corrected", addr: addr("123 Main Street", "95814"), suggestion: "123 MAIN ST"},
+		{name: "missing unit", addr: addr("deliverable_missing_unit", "95814"), issue: true},
+		{name: "undeliverable", addr: addr("123 Main St", "9581"), undeliverable: true, issue: true},
+		// When Lob fails the address is let through unchecked.
+		{name: "Lob rejects the key", addr: addr("123 Main St lob-error:unauthorized", "95814")},
+		{name: "Lob rejects the request", addr: addr("", "95814")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.checkAddress(context.Background(), "Owner", "owner", tt.addr)
+			if got.Label != "Owner" || got.Prefix != "owner" {
+				t.Errorf("Label, Prefix = %q, %q", got.Label, got.Prefix)
+			}
+			if got.Undeliverable != tt.undeliverable {
+				t.Errorf("Undeliverable = %v, want %v", got.Undeliverable, tt.undeliverable)
+			}
+			if (got.Issue != "") != tt.issue {
+				t.Errorf("Issue = %q, want an issue %v", got.Issue, tt.issue)
+			}
+			switch {
+			case tt.suggestion == "" && got.Suggestion != nil:
+				t.Errorf("Suggestion = %+v, want none", got.Suggestion)
+			case tt.suggestion != "" && got.Suggestion == nil:
+				t.Errorf("no Suggestion, want %q", tt.suggestion)
+			case tt.suggestion != "":
+				if got.Suggestion.AddressLine1 != tt.suggestion || got.Suggestion.Name != "Owner" || got.Suggestion.AddressCity != "SACRAMENTO" {
+					t.Errorf("Suggestion = %+v, want %q", got.Suggestion, tt.suggestion)
+				}
+			}
+		})
+	}
+}
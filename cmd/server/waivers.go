@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/orders"
+	"sendmynotice/internal/storage"
+	"sendmynotice/internal/waivers"
+)
+
+// WaiverRequest is the lien waiver form once it has been read and checked.
+// The preview, the printable PDF and payment all start from one.
+type WaiverRequest struct {
+	Form *waivers.Form
+	From mailer.Address
+	// Letter mails the waiver to the customer. It is nil when no mailing
+	// address was given, and the waiver can only be printed.
+	Letter *storage.OrderLetter
+	// Waiver is what is printed, filled in from the form.
+	Waiver mailer.WaiverData
+}
+
+// WaiverPageData is what waivers.html is executed with.
+type WaiverPageData struct {
+	SquareJsURL string
+	CurrentDate string
+	Forms       []waiverOption
+	MailClasses []mailClassOption
+}
+
+// waiverOption is one choice of form on the waivers page.
+type waiverOption struct {
+	Code        string
+	Title       string
+	Conditional bool
+	Progress    bool
+}
+
+func waiverOptions() []waiverOption {
+	var opts []waiverOption
+	for _, f := range waivers.All {
+		opts = append(opts, waiverOption{Code: f.Code, Title: f.Title, Conditional: f.Conditional, Progress: f.Progress()})
+	}
+	return opts
+}
+
+// waiverRecipient is who a mailed waiver goes to, and the prefix of the
+// form fields holding the address.
+var waiverRecipient = struct {
+	Role   storage.Recipient
+	Prefix string
+}{storage.RecipientCustomer, "to"}
+
+// waiverFields are the inputs of the waivers page that are carried from the
+// preview to payment and the printable PDF.
+var waiverFields = []string{
+	"waiver_form", "user_email", "claimant_title", "customer_name", "job_location", "owner_name",
+	"through_date", "check_maker", "check_amount", "check_payable_to", "payment_amount",
+	"prior_waiver_dates", "prior_unpaid_amount", "disputed_amount", "mail_class",
+	"from_name", "from_address1", "from_city", "from_state", "from_zip",
+	"to_address1", "to_city", "to_state", "to_zip",
+}
+
+// parseWaiverRequest reads the waiver form and checks every field the
+// chosen form asks for. If anything is wrong the request is nil and every
+// problem is returned, not just the first.
+func parseWaiverRequest(r *http.Request, now time.Time) (*WaiverRequest, FieldErrors) {
+	var errs FieldErrors
+	form, ok := waivers.Lookup(r.FormValue("waiver_form"))
+	if !ok {
+		errs.add("waiver_form", "Please choose which waiver and release form you need.")
+		return nil, errs
+	}
+
+	field := func(name string) string {
+		return strings.TrimSpace(r.FormValue(name))
+	}
+	required := func(name, message string) string {
+		v := field(name)
+		if v == "" {
+			errs.add(name, message)
+		}
+		return v
+	}
+	amount := func(name, message string, required bool) string {
+		v := strings.TrimPrefix(field(name), "$")
+		if (v != "" || required) && !validPrice(v) {
+			errs.add(name, message)
+		}
+		return v
+	}
+
+	req := &WaiverRequest{Form: form, From: formAddress(r, "from")}
+	if req.From.Name == "" {
+		errs.add("from_name", "Please enter your company name.")
+	}
+	req.Waiver = mailer.WaiverData{
+		Form:          form.Code,
+		Date:          noticeDate(nil, now),
+		ClaimantName:  req.From.Name,
+		ClaimantTitle: field("claimant_title"),
+		CustomerName:  required("customer_name", "Please enter the name of the customer who is paying you."),
+		JobLocation:   required("job_location", "Please enter the job location."),
+		OwnerName:     required("owner_name", "Please enter the owner's name."),
+	}
+	data := &req.Waiver
+
+	if form.Progress() {
+		through, err := time.Parse("2006-01-02", field("through_date"))
+		switch {
+		case err != nil:
+			errs.add("through_date", "Please enter the last day of work this payment covers.")
+		case through.After(dateOf(now)):
+			errs.add("through_date", "The through date cannot be in the future.")
+		default:
+			data.ThroughDate = through.Format("January 2, 2006")
+		}
+	}
+	if form.Conditional {
+		data.CheckMaker = required("check_maker", "Please enter who wrote the check.")
+		data.CheckAmount = amount("check_amount", "Please enter the amount of the check as a number, e.g. 5,000.00.", true)
+		data.CheckPayableTo = required("check_payable_to", "Please enter who the check is payable to.")
+	} else if form.Progress() {
+		data.PaymentAmount = amount("payment_amount", "Please enter the payment you received as a number, e.g. 5,000.00.", true)
+	}
+	if form.Disputed() {
+		data.DisputedAmount = amount("disputed_amount", "Please enter the disputed extras as a number, or leave it blank.", false)
+	} else {
+		data.PriorWaiverDates = field("prior_waiver_dates")
+		data.PriorUnpaidAmount = amount("prior_unpaid_amount", "Please enter the unpaid amount as a number, or leave it blank.", data.PriorWaiverDates != "")
+	}
+
+	to := formAddress(r, waiverRecipient.Prefix)
+	if to.AddressLine1 != "" {
+		// The letter is addressed to the customer named above.
+		validateAddress(&errs, waiverRecipient.Prefix, "the customer's", to)
+		if utf8.RuneCountInString(data.CustomerName) > maxAddressLine {
+			errs.add("customer_name", fmt.Sprintf("Please shorten the customer's name to %d characters to mail the waiver; USPS cannot print a longer line.", maxAddressLine))
+		}
+		to.Name = data.CustomerName
+		validateAddress(&errs, "from", "your", req.From)
+		class := mailer.MailClass(r.FormValue("mail_class"))
+		if class == "" {
+			class = mailer.Certified
+		}
+		price, err := orders.LetterPrice(class)
+		if err != nil {
+			errs.add("mail_class", "Please choose how the waiver should be mailed.")
+		}
+		req.Letter = &storage.OrderLetter{
+			Role:        waiverRecipient.Role,
+			ToAddress:   to,
+			MailClass:   class,
+			AmountCents: price,
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return req, nil
+}
+
+// Order is the order that mails the waiver. Its notice only carries what
+// receipts and alerts show about any order.
+func (req *WaiverRequest) Order() *storage.Order {
+	waiver := req.Waiver
+	return &storage.Order{
+		Notice: mailer.NoticeData{
+			Date:           waiver.Date,
+			SenderName:     waiver.ClaimantName,
+			SenderAddress:  oneLine(req.From),
+			OwnerName:      waiver.OwnerName,
+			JobSiteAddress: waiver.JobLocation,
+			State:          "CA",
+		},
+		Waiver:      &waiver,
+		FromAddress: req.From,
+		AmountCents: req.Letter.AmountCents,
+		Letters:     []storage.OrderLetter{*req.Letter},
+	}
+}
+
+func (s *Server) handleWaivers(w http.ResponseWriter, r *http.Request) {
+	data := WaiverPageData{
+		SquareJsURL: s.squareJsURL,
+		CurrentDate: customerNow().Format("Jan 02, 2006"),
+		Forms:       waiverOptions(),
+		MailClasses: mailClassOptions(),
+	}
+	if err := s.waiversTemplate.Execute(w, data); err != nil {
+		log.Printf("Template execution failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleWaiverPreview(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	req, errs := parseWaiverRequest(r, customerNow())
+	if len(errs) > 0 {
+		renderFieldErrors(w, errs)
+		return
+	}
+
+	waiverHTML, err := s.orders.RenderWaiver(req.Waiver)
+	if err != nil {
+		log.Printf("Failed to render waiver: %v", err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return
+	}
+
+	userEmail := r.FormValue("user_email")
+	if userEmail != "" {
+		go func() {
+			if err := s.db.UpsertLead(context.WithoutCancel(r.Context()), userEmail, req.From.Name); err != nil {
+				log.Printf("Failed to save lead: %v", err)
+			}
+		}()
+	}
+
+	modalData := paymentModal{
+		Title:        req.Form.Title,
+		FormID:       "waiver-form",
+		DocumentHTML: template.HTML(waiverHTML),
+		Download:     download{Action: "/web/waivers/waiver.pdf", Label: "Download to Print & Sign (PDF)", Primary: true},
+		Note:         "We print this waiver and mail it to your customer. A waiver only takes effect once you sign it, so keep a signed copy with your job file.",
+		PayLabel:     "Pay & Mail Waiver",
+		SquareAppID:  s.squareAppID,
+		SquareLocID:  s.squareLocID,
+		HiddenInputs: map[string]string{"idempotency_key": uuid.New().String()},
+	}
+	for _, name := range waiverFields {
+		modalData.HiddenInputs[name] = r.FormValue(name)
+	}
+	if l := req.Letter; l != nil {
+		modalData.Letters = []letterLine{{
+			Recipient: l.Role.Label(),
+			Name:      l.ToAddress.Name,
+			MailClass: l.MailClass.Label(),
+			Price:     orders.FormatCents(l.AmountCents),
+		}}
+		modalData.Total = orders.FormatCents(l.AmountCents)
+		modalData.AddressChecks = []addressCheck{
+			s.checkAddress(r.Context(), "Customer address", waiverRecipient.Prefix, l.ToAddress),
+			s.checkAddress(r.Context(), "Your address", "from", req.From),
+		}
+		for _, c := range modalData.AddressChecks {
+			if c.Undeliverable {
+				modalData.Blocked = true
+			}
+		}
+	} else {
+		modalData.NotMailed = "Want us to mail it? Close this window and add the customer's mailing address."
+	}
+
+	renderPaymentModal(w, modalData)
+}
+
+// handleWaiverPDF sends back the waiver as a PDF to print and sign.
+func (s *Server) handleWaiverPDF(w http.ResponseWriter, r *http.Request) {
+	req, errs := parseWaiverRequest(r, customerNow())
+	if len(errs) > 0 {
+		http.Error(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+
+	waiver, err := orders.WaiverPDF(req.Waiver)
+	if err != nil {
+		log.Printf("Failed to lay out waiver PDF: %v", err)
+		http.Error(w, "System Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="lien-waiver-`+req.Form.Code+`.pdf"`)
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(waiver); err != nil {
+		log.Printf("Failed to send waiver PDF: %v", err)
+	}
+}
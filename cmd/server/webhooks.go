@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/orders"
+	"sendmynotice/internal/payment"
+	"sendmynotice/internal/storage"
+)
+
+const maxWebhookBody = 1 << 20
+
+// Statuses that mean the notice may not have been served; someone needs to
+// look at these before the customer's deadline passes.
+var lobProblemStatuses = map[string]bool{
+	"returned_to_sender": true,
+	"re-routed":          true,
+	"issue":              true,
+	"failed":             true,
+}
+
+// Statuses that mean USPS has the letter: it has entered the mail stream.
+var lobMailStreamStatuses = map[string]bool{
+	"mailed":                 true,
+	"in_transit":             true,
+	"in_local_area":          true,
+	"processed_for_delivery": true,
+	"delivered":              true,
+	"pickup_available":       true,
+	"re-routed":              true,
+	"returned_to_sender":     true,
+}
+
+func (s *Server) handleLobWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.lobWebhookSecret == "" {
+		log.Println("⚠️ LOB_WEBHOOK_SECRET not set, rejecting Lob webhook")
+		http.Error(w, "Webhooks not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "Error reading body", http.StatusBadRequest)
+		return
+	}
+
+	event, err := mailer.VerifyWebhook(
+		s.lobWebhookSecret,
+		r.Header.Get(mailer.SignatureHeader),
+		r.Header.Get(mailer.SignatureTimestampHeader),
+		body,
+		time.Now(),
+	)
+	if err != nil {
+		log.Printf("Rejected Lob webhook: %v", err)
+		if errors.Is(err, mailer.ErrInvalidSignature) {
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if !event.IsLetterEvent() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	order, err := s.db.GetOrderByLetterID(r.Context(), event.LetterID())
+	if err != nil {
+		log.Printf("Lob webhook %s: order lookup failed: %v", event.ID, err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+
+	stored := &storage.OrderEvent{
+		Source:     storage.EventSourceLob,
+		EventID:    event.ID,
+		EventType:  event.EventType.ID,
+		ResourceID: event.LetterID(),
+		OccurredAt: event.OccurredAt(),
+		Payload:    body,
+	}
+	if order != nil {
+		stored.OrderID = order.ID
+	}
+
+	// The event and its effect on the order are saved together, so a failed
+	// update is retried in full when Lob redelivers. Events that are not USPS
+	// tracking updates are only recorded.
+	status, tracked := event.TrackingStatus()
+	var letter *storage.OrderLetter
+	if order != nil {
+		letter = order.Letter(event.LetterID())
+	}
+	var inserted, mailed bool
+	err = s.db.WithTx(r.Context(), func(tx storage.Store) error {
+		var err error
+		inserted, err = tx.RecordOrderEvent(r.Context(), stored)
+		if err != nil || !inserted || letter == nil || !tracked {
+			return err
+		}
+		if err := tx.UpdateTrackingStatus(r.Context(), letter.ID, status, stored.OccurredAt); err != nil {
+			return err
+		}
+		if !orders.Scheduled(order) || !lobMailStreamStatuses[status] {
+			return nil
+		}
+		mailed, err = tx.MarkOrderMailed(r.Context(), order.ID, stored.OccurredAt)
+		return err
+	})
+	if err != nil {
+		log.Printf("Lob webhook %s: storing event failed: %v", event.ID, err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	if !inserted {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if letter == nil {
+		log.Printf("Lob webhook %s (%s) for unknown letter %s", event.ID, event.EventType.ID, event.LetterID())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	log.Printf("📬 Order #%d letter %s (%s): %s", order.ID, letter.LetterID, letter.Role, status)
+
+	if mailed {
+		at := stored.OccurredAt.UTC()
+		order.MailedAt = &at
+		if err := s.orders.SendMailedConfirmation(order); err != nil {
+			log.Printf("ERROR: Lob webhook %s: %v", event.ID, err)
+			s.sendAdminAlert("⚠️ Mailed confirmation not sent", fmt.Sprintf("Order #%d\nEmail: %s\nError: %v", order.ID, order.UserEmail, err))
+		}
+	}
+
+	if lobProblemStatuses[status] {
+		s.sendAdminAlert(fmt.Sprintf("📭 Letter %s: %s", status, letter.TrackingNumber),
+			fmt.Sprintf("Order #%d\nCustomer: %s\nEmail: %s\nRecipient: %s (%s)\nLetter: %s",
+				order.ID, order.Notice.SenderName, order.UserEmail, letter.ToAddress.Name, letter.Role.Label(), letter.LetterID))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleSquareWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.squareWebhookKey == "" || s.squareWebhookURL == "" {
+		log.Println("⚠️ SQUARE_WEBHOOK_SIGNATURE_KEY or SQUARE_WEBHOOK_URL not set, rejecting Square webhook")
+		http.Error(w, "Webhooks not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "Error reading body", http.StatusBadRequest)
+		return
+	}
+
+	event, err := payment.VerifyWebhook(s.squareWebhookKey, s.squareWebhookURL, r.Header.Get(payment.SignatureHeader), body)
+	if err != nil {
+		log.Printf("Rejected Square webhook: %v", err)
+		if errors.Is(err, payment.ErrInvalidSignature) {
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	switch event.Type {
+	case "payment.updated", "refund.updated", "dispute.created":
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	paymentID := event.PaymentID()
+	order, err := s.db.GetOrderByPaymentID(r.Context(), paymentID)
+	if err != nil {
+		log.Printf("Square webhook %s: order lookup failed: %v", event.EventID, err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+
+	stored := &storage.OrderEvent{
+		Source:     storage.EventSourceSquare,
+		EventID:    event.EventID,
+		EventType:  event.Type,
+		ResourceID: event.Data.ID,
+		OccurredAt: event.OccurredAt(),
+		Payload:    body,
+	}
+	if order != nil {
+		stored.OrderID = order.ID
+	} else if ref := event.Data.Object.Payment; ref != nil && ref.ReferenceID != "" {
+		// The reference is the order's idempotency key. If that order is
+		// still in created, the charge has not been recorded on it yet;
+		// Square retries the event, by when it has been.
+		pending, err := s.db.GetOrderByIdempotencyKey(r.Context(), ref.ReferenceID)
+		if err != nil {
+			log.Printf("Square webhook %s: order lookup failed: %v", event.EventID, err)
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		if pending != nil && pending.Status == storage.OrderCreated {
+			log.Printf("Square webhook %s: order #%d has not recorded payment %s yet", event.EventID, pending.ID, paymentID)
+			http.Error(w, "Order not ready", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	// The event, its effect on the order and the settling of any queued
+	// refunds commit together. If any of it fails nothing is stored and
+	// Square's redelivery tries again.
+	var inserted bool
+	err = s.db.WithTx(r.Context(), func(tx storage.Store) error {
+		var err error
+		inserted, err = tx.RecordOrderEvent(r.Context(), stored)
+		if err != nil || !inserted || order == nil {
+			return err
+		}
+		if err := applySquareEvent(r.Context(), tx, order, event, stored.OccurredAt); err != nil {
+			return err
+		}
+		obj := event.Data.Object
+		if (obj.Refund != nil && obj.Refund.Status == "COMPLETED") || (obj.Payment != nil && obj.Payment.RefundedMoney.Amount > 0) {
+			if err := orders.RefundCompleted(r.Context(), tx, order.PaymentID); err != nil {
+				return fmt.Errorf("settling refunds for order #%d: %w", order.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Square webhook %s: storing event failed: %v", event.EventID, err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	if !inserted {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if order == nil {
+		log.Printf("Square webhook %s (%s) for unknown payment %s", event.EventID, event.Type, paymentID)
+		if event.Type == "dispute.created" {
+			s.sendAdminAlert("⚖️ DISPUTE on unknown payment", fmt.Sprintf("Payment: %s\nEvent: %s", paymentID, event.EventID))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Alerts go out after the commit, so they are only sent once.
+	if dispute := event.Data.Object.Dispute; dispute != nil {
+		var tracking strings.Builder
+		for _, l := range order.Letters {
+			if l.TrackingNumber != "" {
+				fmt.Fprintf(&tracking, "\nTracking (%s): %s (%s)", l.Role.Label(), l.TrackingNumber, l.TrackingStatus)
+			}
+		}
+		s.sendAdminAlert(fmt.Sprintf("⚖️ DISPUTE: $%.2f (%s)", float64(dispute.AmountMoney.Amount)/100, dispute.Reason),
+			fmt.Sprintf("Order #%d\nCustomer: %s\nEmail: %s\nPayment: %s\nDispute: %s\nState: %s\nEvidence due: %s%s",
+				order.ID, order.Notice.SenderName, order.UserEmail, order.PaymentID, dispute.ID, dispute.State,
+				dispute.DueAt, tracking.String()))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func applySquareEvent(ctx context.Context, tx storage.Store, order *storage.Order, event *payment.WebhookEvent, at time.Time) error {
+	obj := event.Data.Object
+
+	switch {
+	case obj.Payment != nil:
+		// refunded_money is everything refunded on the payment so far.
+		status := obj.Payment.Status
+		refunded := obj.Payment.RefundedMoney.Amount
+		if refunded > 0 {
+			status = refundedStatus(refunded, order.AmountCents)
+		}
+		log.Printf("💳 Order #%d payment %s: %s", order.ID, order.PaymentID, status)
+		return tx.UpdatePaymentStatus(ctx, order.ID, status, refunded, at)
+
+	case obj.Refund != nil:
+		refund := obj.Refund
+		if refund.Status != "COMPLETED" {
+			log.Printf("💳 Order #%d refund %s: %s", order.ID, refund.ID, refund.Status)
+			return tx.UpdatePaymentStatus(ctx, order.ID, "REFUND_"+refund.Status, 0, at)
+		}
+		// A refund carries only its own amount, and an order is refunded one
+		// letter at a time. The total comes from the payment.updated event
+		// Square sends alongside it.
+		log.Printf("💸 Order #%d refund %s of %s completed", order.ID, refund.ID, orders.FormatCents(refund.AmountMoney.Amount))
+		return nil
+
+	case obj.Dispute != nil:
+		log.Printf("⚖️ Order #%d payment %s disputed: %s", order.ID, order.PaymentID, obj.Dispute.Reason)
+		return tx.UpdatePaymentStatus(ctx, order.ID, "DISPUTED", 0, at)
+	}
+	return nil
+}
+
+func refundedStatus(refundedCents, amountCents int64) string {
+	if refundedCents >= amountCents {
+		return "REFUNDED"
+	}
+	return "PARTIALLY_REFUNDED"
+}
@@ -1,50 +1,134 @@
 package apierrors
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 type UserError struct {
 	Code        string
-	DevMessage  string 
-	UserMessage string 
+	DevMessage  string
+	UserMessage string
+	// Field is the form input the error points at, e.g. "to_address1" or
+	// "from_zip", so the page can highlight it. Empty when no single input
+	// is to blame.
+	Field string
 }
 
 func (e *UserError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Code, e.DevMessage)
 }
 
+// lobError is what we tell the customer about one Lob error code. field is
+// the address part it is about when Lob's message does not name one.
+type lobError struct {
+	userMessage string
+	field       string
+}
+
+const (
+	msgSystem  = "Our mail provider is having trouble right now. Please try again in a few minutes."
+	msgAddress = "The address format is incorrect. Please ensure you have a valid City, State, and Zip."
+	msgInvalid = "The mail carrier rejected this request. Please verify the information is correct."
+)
+
+// lobErrors covers every code in Lob's error reference. Codes about checks,
+// bank accounts and templates cannot come from a letter we send, but are
+// listed so they are reported as our fault rather than the customer's.
+//
+// Source: https://docs.lob.com/#tag/Errors
+var lobErrors = map[string]lobError{
+	// Address problems the customer can fix.
+	"failed_deliverability_strictness": {"We could not verify this address exists. Please double-check the street number and spelling.", "address1"},
+	"invalid_address":                  {msgAddress, ""},
+	"address_length_exceeds_limit":     {"The address line is too long (max 40 chars). Please abbreviate (e.g., 'St' instead of 'Street').", "address1"},
+	"special_characters_restricted":    {"The address contains characters the mail carrier cannot print. Please use plain letters and numbers.", "address1"},
+	"foreign_return_address":           {"Your return address must be in the United States.", "from_address1"},
+	"invalid_country_covid":            {"The mail carrier is not delivering to this country right now.", "address1"},
+	"invalid_international_feature":    {"Certified mail is only available for addresses in the United States.", "address1"},
+	"invalid":                          {msgInvalid, ""},
+	"bad_request":                      {msgInvalid, ""},
+
+	// Too busy; the transport already retried these.
+	"rate_limit_exceeded":   {"We are sending too many requests at once. Please wait a moment and try again.", ""},
+	"request_timeout":       {msgSystem, ""},
+	"internal_server_error": {msgSystem, ""},
+	"service_unavailable":   {msgSystem, ""},
+	"conflict":              {"This notice is already being mailed. Please wait a moment before trying again.", ""},
+
+	// Problems with the letter we generated or with our Lob account.
+	"file_size_too_large":                 {msgSystem, ""},
+	"file_pages_below_min":                {msgSystem, ""},
+	"file_pages_exceed_max":               {msgSystem, ""},
+	"inconsistent_page_dimensions":        {msgSystem, ""},
+	"invalid_file":                        {msgSystem, ""},
+	"invalid_file_dimensions":             {msgSystem, ""},
+	"invalid_file_download_time":          {msgSystem, ""},
+	"invalid_file_url":                    {msgSystem, ""},
+	"invalid_image_dpi":                   {msgSystem, ""},
+	"invalid_template_html":               {msgSystem, ""},
+	"merge_variable_required":             {msgSystem, ""},
+	"merge_variable_whitespace":           {msgSystem, ""},
+	"invalid_perforation_return_envelope": {msgSystem, ""},
+	"invalid_check_interior":              {msgSystem, ""},
+	"invalid_bank_account":                {msgSystem, ""},
+	"invalid_bank_account_verification":   {msgSystem, ""},
+	"deleted_bank_account":                {msgSystem, ""},
+	"payment_method_unverified":           {msgSystem, ""},
+	"feature_limit_reached":               {msgSystem, ""},
+	"email_required":                      {msgSystem, ""},
+	"not_deletable":                       {msgSystem, ""},
+	"not_found":                           {msgSystem, ""},
+	"forbidden":                           {msgSystem, ""},
+	"unauthorized":                        {msgSystem, ""},
+	"unauthorized_token":                  {msgSystem, ""},
+	"unrecognized_endpoint":               {msgSystem, ""},
+	"unsupported_lob_version":             {msgSystem, ""},
+}
+
+// lobFieldPattern finds the address field Lob names in a message such as
+// "to.address_zip is required".
+var lobFieldPattern = regexp.MustCompile(`\b(to|from)\.(name|address_line1|address_line2|address_city|address_state|address_zip)\b`)
+
+// formFields maps Lob's address fields to the suffix of our form inputs.
+// The form has a single street line.
+var formFields = map[string]string{
+	"name":          "name",
+	"address_line1": "address1",
+	"address_line2": "address1",
+	"address_city":  "city",
+	"address_state": "state",
+	"address_zip":   "zip",
+}
 
 // Source: https://docs.lob.com/#errors
 func MapLobError(code string, originalMsg string) *UserError {
-	switch code {
-	case "failed_deliverability_strictness":
-		return &UserError{
-			Code:        code,
-			DevMessage:  originalMsg,
-			UserMessage: "We could not verify this address exists. Please double-check the street number and spelling.",
-		}
-	case "invalid_address":
-		return &UserError{
-			Code:        code,
-			DevMessage:  originalMsg,
-			UserMessage: "The address format is incorrect. Please ensure you have a valid City, State, and Zip.",
-		}
-	case "address_length_exceeds_limit":
-		return &UserError{
-			Code:        code,
-			DevMessage:  originalMsg,
-			UserMessage: "The address line is too long (max 40 chars). Please abbreviate (e.g., 'St' instead of 'Street').",
-		}
-	case "rate_limit_exceeded":
-		return &UserError{
-			Code:        code,
-			DevMessage:  originalMsg,
-			UserMessage: "We are sending too many requests at once. Please wait a moment and try again.",
-		}
-	default:
+	e, ok := lobErrors[code]
+	if !ok {
 		return &UserError{
 			Code:        "unknown_validation_error",
 			DevMessage:  originalMsg,
-			UserMessage: "The mail carrier rejected this request. Please verify the information is correct.",
+			UserMessage: msgInvalid,
+			Field:       lobField(originalMsg, ""),
 		}
 	}
-}
\ No newline at end of file
+	return &UserError{
+		Code:        code,
+		DevMessage:  originalMsg,
+		UserMessage: e.userMessage,
+		Field:       lobField(originalMsg, e.field),
+	}
+}
+
+// lobField is the form input named in msg. Failing that it is fallback,
+// taken to be on the recipient's address unless it says otherwise.
+func lobField(msg, fallback string) string {
+	if m := lobFieldPattern.FindStringSubmatch(msg); m != nil {
+		return m[1] + "_" + formFields[m[2]]
+	}
+	if fallback == "" || strings.HasPrefix(fallback, "to_") || strings.HasPrefix(fallback, "from_") {
+		return fallback
+	}
+	return "to_" + fallback
+}
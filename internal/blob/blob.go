@@ -0,0 +1,35 @@
+// Package blob keeps files we must hold on to, such as the PDF of every
+// letter we mail, which is our evidence that a notice was served. Objects are
+// never deleted.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for a key that was never stored.
+var ErrNotFound = errors.New("blob not found")
+
+// Store is a flat namespace of immutable objects addressed by slash-separated
+// keys, e.g. "letters/42/ltr_123.pdf".
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// validKey rejects keys that could escape the store's root.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FS stores objects as files under a directory.
+type FS struct {
+	dir string
+}
+
+var _ Store = (*FS)(nil)
+
+// NewFS stores objects under dir, creating it if needed.
+func NewFS(dir string) (*FS, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating blob directory: %w", err)
+	}
+	return &FS{dir: dir}, nil
+}
+
+// Put writes to a temporary file first so a crash never leaves a truncated
+// object under key.
+func (f *FS) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	path := filepath.Join(f.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("creating blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("creating blob: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("storing blob: %w", err)
+	}
+	return nil
+}
+
+func (f *FS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(f.dir, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening blob: %w", err)
+	}
+	return file, nil
+}
@@ -0,0 +1,209 @@
+// Package s3fake is an in-process stand-in for the parts of the S3 API that
+// blob.S3 uses: PUT and GET of single objects in path-style buckets. It backs
+// APP_ENV=local with BLOB_BACKEND=s3, so the S3 backend can be exercised
+// without a bucket.
+//
+// Like S3, it checks each request's Signature Version 4 against the one
+// secret it was created with and rejects a payload that does not match its
+// signed hash. Objects live in memory and are lost on restart.
+package s3fake
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type object struct {
+	data        []byte
+	contentType string
+}
+
+type Server struct {
+	accessKeyID     string
+	secretAccessKey string
+
+	mu      sync.Mutex
+	objects map[string]object
+	now     func() time.Time
+}
+
+// New accepts requests signed with the given credentials.
+func New(accessKeyID, secretAccessKey string) *Server {
+	return &Server{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		objects:         make(map[string]object),
+		now:             time.Now,
+	}
+}
+
+// Start serves the fake on addr ("127.0.0.1:0" picks a free port) and
+// returns the endpoint to put in blob.S3Config.
+func (s *Server) Start(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		if err := http.Serve(ln, s); err != nil {
+			log.Printf("s3fake stopped: %v", err)
+		}
+	}()
+	return "http://" + ln.Addr().String(), nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if code, msg := s.verify(r, body); code != "" {
+		writeError(w, http.StatusForbidden, code, msg)
+		return
+	}
+
+	// The path is /bucket/key; buckets are created on first use.
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.Contains(path, "/") {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "only object requests are supported")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.mu.Lock()
+		s.objects[path] = object{data: body, contentType: r.Header.Get("Content-Type")}
+		s.mu.Unlock()
+		w.Header().Set("ETag", `"`+sha256Hex(body)[:32]+`"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		s.mu.Lock()
+		obj, ok := s.objects[path]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		if obj.contentType != "" {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not supported")
+	}
+}
+
+// verify recomputes the request's signature from the headers it says it
+// signed. It returns an S3 error code if the request is not acceptable.
+func (s *Server) verify(r *http.Request, body []byte) (code, msg string) {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return "AccessDenied", "missing or unsupported Authorization header"
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(auth, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[k] = v
+	}
+	credential := strings.SplitN(fields["Credential"], "/", 2)
+	if len(credential) != 2 || credential[0] != s.accessKeyID {
+		return "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."
+	}
+	scope := credential[1]
+	scopeParts := strings.Split(scope, "/")
+	if len(scopeParts) != 4 || scopeParts[2] != "s3" || scopeParts[3] != "aws4_request" {
+		return "AuthorizationHeaderMalformed", "bad credential scope " + scope
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, scopeParts[0]) {
+		return "AccessDenied", "missing or malformed X-Amz-Date"
+	}
+	if d := s.now().Sub(signedAt); d > 15*time.Minute || d < -15*time.Minute {
+		return "RequestTimeTooSkewed", "The difference between the request time and the current time is too large."
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != sha256Hex(body) {
+		return "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + s.secretAccessKey)
+	for _, part := range scopeParts {
+		key = hmacSHA256(key, part)
+	}
+	want := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."
+	}
+	return "", ""
+}
+
+func canonicalQuery(q url.Values) string {
+	var parts []string
+	for k, values := range q {
+		for _, v := range values {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	sort.Strings(parts)
+	return strings.ReplaceAll(strings.Join(parts, "&"), "+", "%20")
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: msg})
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
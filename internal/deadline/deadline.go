@@ -0,0 +1,107 @@
+// Package deadline works out when a preliminary notice must be mailed to
+// protect all of a claimant's work, and how much of it a late notice still
+// protects.
+package deadline
+
+import (
+	"fmt"
+	"time"
+
+	"sendmynotice/internal/states"
+)
+
+// Layout is how deadline dates are written on the notice and in the order.
+const Layout = "January 2, 2006"
+
+// Window is the deadline of one notice.
+type Window struct {
+	// FirstFurnished is the day labor or materials were first furnished.
+	FirstFurnished time.Time
+	// MailBy is the last day the notice can be mailed and still cover
+	// everything furnished from FirstFurnished on.
+	MailBy time.Time
+	// MailOn is the day the notice is mailed.
+	MailOn time.Time
+	// CoveredFrom is the first day of work the notice covers. It is
+	// FirstFurnished unless the notice is mailed after MailBy; work furnished
+	// before it is not protected.
+	CoveredFrom time.Time
+}
+
+// Compute finds the window of a notice mailed on mailOn for work first
+// furnished on firstFurnished, under the state's deadline d. Times of day
+// are ignored.
+func Compute(d states.Deadline, firstFurnished, mailOn time.Time) Window {
+	w := Window{
+		FirstFurnished: day(firstFurnished),
+		MailOn:         day(mailOn),
+	}
+	w.MailBy = cutoff(d, w.FirstFurnished)
+	w.CoveredFrom = w.FirstFurnished
+	if earliest := earliestCovered(d, w.MailOn); earliest.After(w.CoveredFrom) {
+		w.CoveredFrom = earliest
+	}
+	return w
+}
+
+// Late reports whether the notice is mailed after MailBy, so that some of
+// the work is not covered.
+func (w Window) Late() bool {
+	return w.MailOn.After(w.MailBy)
+}
+
+// DaysLeft is how many days remain from MailOn until MailBy; negative once
+// the deadline has passed.
+func (w Window) DaysLeft() int {
+	return days(w.MailOn, w.MailBy)
+}
+
+// Uncovered is how many days of work, counted from FirstFurnished, fall
+// before CoveredFrom.
+func (w Window) Uncovered() int {
+	return days(w.FirstFurnished, w.CoveredFrom)
+}
+
+// Summary is the deadline in a sentence, shown before payment.
+func (w Window) Summary() string {
+	switch {
+	case w.Late():
+		return fmt.Sprintf("Your deadline to cover all of your work passed on %s. Mailed on %s, this notice covers labor and materials furnished on or after %s; the first %d day(s) of work are not protected.",
+			w.MailBy.Format(Layout), w.MailOn.Format(Layout), w.CoveredFrom.Format(Layout), w.Uncovered())
+	case w.DaysLeft() == 0:
+		return fmt.Sprintf("Mailed on %s, the last day of your deadline, this notice covers all of your work from %s.", w.MailOn.Format(Layout), w.FirstFurnished.Format(Layout))
+	default:
+		return fmt.Sprintf("Mail by %s to cover all of your work from %s. Mailed on %s, everything is protected with %d day(s) to spare.",
+			w.MailBy.Format(Layout), w.FirstFurnished.Format(Layout), w.MailOn.Format(Layout), w.DaysLeft())
+	}
+}
+
+// cutoff is the last day a notice can be mailed and cover work furnished on
+// worked.
+func cutoff(d states.Deadline, worked time.Time) time.Time {
+	if d.Months > 0 {
+		return time.Date(worked.Year(), worked.Month()+time.Month(d.Months), d.DayOfMonth, 0, 0, 0, 0, time.UTC)
+	}
+	return worked.AddDate(0, 0, d.Days)
+}
+
+// earliestCovered is the first day of work a notice mailed on mailOn still
+// covers: the earliest day whose cutoff is not before mailOn.
+func earliestCovered(d states.Deadline, mailOn time.Time) time.Time {
+	if d.Months > 0 {
+		month := mailOn.Month() - time.Month(d.Months)
+		if mailOn.Day() > d.DayOfMonth {
+			month++
+		}
+		return time.Date(mailOn.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return mailOn.AddDate(0, 0, -d.Days)
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func days(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
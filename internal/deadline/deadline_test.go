@@ -0,0 +1,161 @@
+package deadline
+
+import (
+	"testing"
+	"time"
+
+	"sendmynotice/internal/states"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name           string
+		rules          *states.Rules
+		firstFurnished time.Time
+		mailOn         time.Time
+		mailBy         time.Time
+		coveredFrom    time.Time
+		late           bool
+		daysLeft       int
+		uncovered      int
+	}{
+		{
+			name:           "California with days to spare",
+			rules:          states.California,
+			firstFurnished: date(2026, time.March, 1),
+			mailOn:         date(2026, time.March, 10),
+			mailBy:         date(2026, time.March, 21),
+			coveredFrom:    date(2026, time.March, 1),
+			daysLeft:       11,
+		},
+		{
+			name:           "California mailed on the last day",
+			rules:          states.California,
+			firstFurnished: date(2026, time.March, 1),
+			mailOn:         date(2026, time.March, 21),
+			mailBy:         date(2026, time.March, 21),
+			coveredFrom:    date(2026, time.March, 1),
+		},
+		{
+			name:           "California one day late",
+			rules:          states.California,
+			firstFurnished: date(2026, time.March, 1),
+			mailOn:         date(2026, time.March, 22),
+			mailBy:         date(2026, time.March, 21),
+			coveredFrom:    date(2026, time.March, 2),
+			late:           true,
+			daysLeft:       -1,
+			uncovered:      1,
+		},
+		{
+			name:           "California first furnished after mailing",
+			rules:          states.California,
+			firstFurnished: date(2026, time.March, 10),
+			mailOn:         date(2026, time.March, 5),
+			mailBy:         date(2026, time.March, 30),
+			coveredFrom:    date(2026, time.March, 10),
+			daysLeft:       25,
+		},
+		{
+			name:           "time of day is ignored",
+			rules:          states.California,
+			firstFurnished: time.Date(2026, time.March, 1, 23, 30, 0, 0, time.UTC),
+			mailOn:         time.Date(2026, time.March, 21, 23, 59, 0, 0, time.UTC),
+			mailBy:         date(2026, time.March, 21),
+			coveredFrom:    date(2026, time.March, 1),
+		},
+		{
+			name:           "Nevada across a month end",
+			rules:          states.Nevada,
+			firstFurnished: date(2026, time.January, 31),
+			mailOn:         date(2026, time.March, 4),
+			mailBy:         date(2026, time.March, 3),
+			coveredFrom:    date(2026, time.February, 1),
+			late:           true,
+			daysLeft:       -1,
+			uncovered:      1,
+		},
+		{
+			name:           "Texas December work runs into March",
+			rules:          states.Texas,
+			firstFurnished: date(2025, time.December, 10),
+			mailOn:         date(2026, time.February, 1),
+			mailBy:         date(2026, time.March, 15),
+			coveredFrom:    date(2025, time.December, 10),
+			daysLeft:       42,
+		},
+		{
+			name:           "Texas mailed after the 15th of the second month is on time",
+			rules:          states.Texas,
+			firstFurnished: date(2025, time.December, 10),
+			mailOn:         date(2026, time.February, 16),
+			mailBy:         date(2026, time.March, 15),
+			coveredFrom:    date(2025, time.December, 10),
+			daysLeft:       27,
+		},
+		{
+			name:           "Texas mailed on the 15th of the third month",
+			rules:          states.Texas,
+			firstFurnished: date(2025, time.December, 31),
+			mailOn:         date(2026, time.March, 15),
+			mailBy:         date(2026, time.March, 15),
+			coveredFrom:    date(2025, time.December, 31),
+		},
+		{
+			name:           "Texas one day late drops the first month",
+			rules:          states.Texas,
+			firstFurnished: date(2025, time.December, 10),
+			mailOn:         date(2026, time.March, 16),
+			mailBy:         date(2026, time.March, 15),
+			coveredFrom:    date(2026, time.January, 1),
+			late:           true,
+			daysLeft:       -1,
+			uncovered:      22,
+		},
+		{
+			name:           "Texas late in January reaches back into the previous year",
+			rules:          states.Texas,
+			firstFurnished: date(2025, time.October, 5),
+			mailOn:         date(2026, time.January, 16),
+			mailBy:         date(2026, time.January, 15),
+			coveredFrom:    date(2025, time.November, 1),
+			late:           true,
+			daysLeft:       -1,
+			uncovered:      27,
+		},
+		{
+			name:           "Texas first furnished after mailing",
+			rules:          states.Texas,
+			firstFurnished: date(2026, time.April, 20),
+			mailOn:         date(2026, time.April, 1),
+			mailBy:         date(2026, time.July, 15),
+			coveredFrom:    date(2026, time.April, 20),
+			daysLeft:       105,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := Compute(tt.rules.Deadline, tt.firstFurnished, tt.mailOn)
+			if !w.MailBy.Equal(tt.mailBy) {
+				t.Errorf("MailBy = %s, want %s", w.MailBy.Format(Layout), tt.mailBy.Format(Layout))
+			}
+			if !w.CoveredFrom.Equal(tt.coveredFrom) {
+				t.Errorf("CoveredFrom = %s, want %s", w.CoveredFrom.Format(Layout), tt.coveredFrom.Format(Layout))
+			}
+			if w.Late() != tt.late {
+				t.Errorf("Late() = %v, want %v", w.Late(), tt.late)
+			}
+			if w.DaysLeft() != tt.daysLeft {
+				t.Errorf("DaysLeft() = %d, want %d", w.DaysLeft(), tt.daysLeft)
+			}
+			if w.Uncovered() != tt.uncovered {
+				t.Errorf("Uncovered() = %d, want %d", w.Uncovered(), tt.uncovered)
+			}
+		})
+	}
+}
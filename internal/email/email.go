@@ -5,17 +5,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
+
+	"github.com/google/uuid"
+
+	"sendmynotice/internal/transport"
 )
 
 const resendEndpoint = "https://api.resend.com/emails"
 
 type Client struct {
-	apiKey string
+	apiKey     string
+	httpClient *http.Client
 }
 
 func NewClient(apiKey string) *Client {
-	return &Client{apiKey: apiKey}
+	return &Client{
+		apiKey: apiKey,
+		// Resend allows 2 requests per second per team.
+		httpClient: transport.NewClient(transport.Config{
+			Name:          "resend",
+			RatePerSecond: 2,
+			Burst:         2,
+		}),
+	}
 }
 
 type EmailRequest struct {
@@ -23,14 +35,23 @@ type EmailRequest struct {
 	To      []string `json:"to"`
 	Subject string `json:"subject"`
 	Html    string `json:"html"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is a file sent along with an email. Resend takes the content
+// base64 encoded, which is how encoding/json writes a []byte.
+type Attachment struct {
+	Filename string `json:"filename"`
+	Content  []byte `json:"content"`
 }
 
-func (c *Client) Send(to, subject, htmlBody string) error {
+func (c *Client) Send(to, subject, htmlBody string, attachments ...Attachment) error {
 	reqBody := EmailRequest{
 		From:    "SendMyNotice <updates@sendmynotice.com>",
 		To:      []string{to},
 		Subject: subject,
 		Html:    htmlBody,
+		Attachments: attachments,
 	}
 
 	jsonBytes, err := json.Marshal(reqBody)
@@ -41,9 +62,11 @@ func (c *Client) Send(to, subject, htmlBody string) error {
 
 	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	req.Header.Set("Content-Type", "application/json")
+	// One key per email lets the transport retry it without Resend sending
+	// it twice.
+	req.Header.Set("Idempotency-Key", uuid.New().String())
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
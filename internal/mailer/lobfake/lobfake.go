@@ -0,0 +1,582 @@
+// Package lobfake is an in-process stand-in for the parts of Lob's letters
+// API that the mailer package uses. It backs tests and APP_ENV=local, so the
+// whole checkout can run without a Lob key.
+//
+// Errors can be injected in two ways: call FailNext from a test, or put
+// "lob-error:<code>" (for example "lob-error:failed_deliverability_strictness")
+// in any line of the recipient's address when clicking through locally.
+//
+// Address verification follows Lob's test-key convention: a primary line of
+// exactly "undeliverable", "deliverable_missing_unit" and so on returns that
+// result. Anything else is standardised and reported deliverable, unless it
+// has no street number or a malformed ZIP.
+package lobfake
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sendmynotice/internal/mailer"
+)
+
+// Letter is a letter the fake has accepted.
+type Letter struct {
+	ID             string         `json:"id"`
+	Description    string         `json:"description"`
+	To             mailer.Address `json:"to"`
+	From           mailer.Address `json:"from"`
+	Color          bool           `json:"color"`
+	ExtraService   string         `json:"extra_service,omitempty"`
+	TrackingNumber string         `json:"tracking_number,omitempty"`
+	URL            string         `json:"url"`
+	ExpectedDel    string         `json:"expected_delivery_date"`
+	DateCreated    string         `json:"date_created"`
+	SendDate       string         `json:"send_date"`
+	Deleted        bool           `json:"deleted"`
+
+	// File is the HTML that was submitted for printing.
+	File string `json:"-"`
+
+	created time.Time
+}
+
+// Codes the fake knows the HTTP status for. Anything else is reported as 422,
+// which is what Lob uses for most validation failures.
+var errorStatus = map[string]int{
+	"failed_deliverability_strictness": http.StatusUnprocessableEntity,
+	"invalid_address":                  http.StatusUnprocessableEntity,
+	"address_length_exceeds_limit":     http.StatusUnprocessableEntity,
+	"unauthorized":                     http.StatusUnauthorized,
+	"not_found":                        http.StatusNotFound,
+	"rate_limit_exceeded":              http.StatusTooManyRequests,
+	"internal_server_error":            http.StatusInternalServerError,
+	"service_unavailable":              http.StatusServiceUnavailable,
+	"letter_not_cancelable":            http.StatusUnprocessableEntity,
+	"bad_request":                      http.StatusBadRequest,
+	"request_timeout":                  http.StatusRequestTimeout,
+	"conflict":                         http.StatusConflict,
+	"forbidden":                        http.StatusForbidden,
+}
+
+// DefaultCancelWindow is how long after creation a letter can be cancelled,
+// matching the window on a new Lob account.
+const DefaultCancelWindow = 5 * time.Minute
+
+var extraServices = map[string]bool{
+	"":                         true,
+	"certified":                true,
+	"certified_return_receipt": true,
+	"registered":               true,
+}
+
+type Server struct {
+	mu          sync.Mutex
+	letters     map[string]*Letter
+	idempotency map[string]string
+	failures    []string
+	window      time.Duration
+	now         func() time.Time
+	mux         *http.ServeMux
+}
+
+func New() *Server {
+	s := &Server{
+		letters:     map[string]*Letter{},
+		idempotency: map[string]string{},
+		window:      DefaultCancelWindow,
+		now:         time.Now,
+		mux:         http.NewServeMux(),
+	}
+	s.mux.HandleFunc("POST /v1/letters", s.createLetter)
+	s.mux.HandleFunc("GET /v1/letters", s.listLetters)
+	s.mux.HandleFunc("GET /v1/letters/{id}", s.getLetter)
+	s.mux.HandleFunc("DELETE /v1/letters/{id}", s.cancelLetter)
+	s.mux.HandleFunc("POST /v1/us_verifications", s.verifyAddress)
+	s.mux.HandleFunc("GET /pdfs/{file}", s.servePDF)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Start serves the fake on addr (use "127.0.0.1:0" for any free port) and
+// returns the base URL to hand to mailer.NewClient.
+func (s *Server) Start(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		if err := http.Serve(ln, s); err != nil {
+			log.Printf("Fake Lob server stopped: %v", err)
+		}
+	}()
+	return "http://" + ln.Addr().String() + "/v1", nil
+}
+
+// FailNext makes the next letter creations fail with the given Lob error
+// codes, one code per request, in order.
+func (s *Server) FailNext(codes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, codes...)
+}
+
+// SetClock replaces the clock used for creation and delivery dates.
+func (s *Server) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// SetCancelWindow changes how long new letters wait before their send date.
+func (s *Server) SetCancelWindow(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = d
+}
+
+// Letters returns every accepted letter, oldest first.
+func (s *Server) Letters() []Letter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedLetters()
+}
+
+func (s *Server) createLetter(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		writeError(w, "unauthorized", "Your API key is not valid.")
+		return
+	}
+
+	var req mailer.LetterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "bad_request", "Request body is not valid JSON.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := r.Header.Get("Idempotency-Key")
+	if id, ok := s.idempotency[key]; ok && key != "" {
+		writeJSON(w, http.StatusOK, s.letters[id])
+		return
+	}
+
+	if len(s.failures) > 0 {
+		code := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, code, "Injected failure: "+code)
+		return
+	}
+	if code := injectedCode(req.To); code != "" {
+		writeError(w, code, "Injected failure: "+code)
+		return
+	}
+	if code, msg := validate(req); code != "" {
+		writeError(w, code, msg)
+		return
+	}
+
+	now := s.now().UTC()
+	sendDate := now.Add(s.window)
+	if req.SendDate != "" {
+		scheduled, err := parseSendDate(req.SendDate)
+		if err != nil || !scheduled.After(now) || scheduled.After(now.AddDate(0, 0, 180)) {
+			writeError(w, "invalid", "send_date must be a date after now and at most 180 days in the future.")
+			return
+		}
+		sendDate = scheduled
+	}
+	l := &Letter{
+		ID:           "ltr_" + randomHex(8),
+		Description:  req.Description,
+		To:           req.To,
+		From:         req.From,
+		Color:        req.Color,
+		ExtraService: req.ExtraService,
+		ExpectedDel:  addBusinessDays(sendDate, 5).Format("2006-01-02"),
+		DateCreated:  now.Format(time.RFC3339),
+		SendDate:     sendDate.Format(time.RFC3339),
+		File:         req.File,
+		created:      now,
+	}
+	if req.ExtraService != "" {
+		l.TrackingNumber = trackingNumber()
+	}
+	l.URL = pdfURL(r.Host, l.ID, now)
+
+	s.letters[l.ID] = l
+	if key != "" {
+		s.idempotency[key] = l.ID
+	}
+	writeJSON(w, http.StatusOK, l)
+}
+
+func (s *Server) getLetter(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		writeError(w, "unauthorized", "Your API key is not valid.")
+		return
+	}
+
+	s.mu.Lock()
+	l, ok := s.letters[r.PathValue("id")]
+	var resp Letter
+	if ok {
+		// Lob signs a new PDF link every time a letter is fetched.
+		l.URL = pdfURL(r.Host, l.ID, s.now())
+		resp = *l
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, "not_found", "letter not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// cancelLetter deletes a letter that has not reached its send date yet.
+func (s *Server) cancelLetter(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		writeError(w, "unauthorized", "Your API key is not valid.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.letters[r.PathValue("id")]
+	if !ok {
+		writeError(w, "not_found", "letter not found")
+		return
+	}
+	sendDate, _ := time.Parse(time.RFC3339, l.SendDate)
+	if !l.Deleted && !s.now().Before(sendDate) {
+		writeError(w, "letter_not_cancelable", "This letter has already been sent to print and can no longer be canceled.")
+		return
+	}
+	l.Deleted = true
+	writeJSON(w, http.StatusOK, map[string]any{"id": l.ID, "deleted": true})
+}
+
+// listLetters supports the date_created[gte]/[lt] filters and offset-based
+// next_url pagination that mailer.ListLetters follows.
+func (s *Server) listLetters(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		writeError(w, "unauthorized", "Your API key is not valid.")
+		return
+	}
+
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 10
+	}
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	gte, _ := time.Parse(time.RFC3339, q.Get("date_created[gte]"))
+	lt, _ := time.Parse(time.RFC3339, q.Get("date_created[lt]"))
+
+	s.mu.Lock()
+	var matched []Letter
+	for _, l := range s.sortedLetters() {
+		if !gte.IsZero() && l.created.Before(gte) {
+			continue
+		}
+		if !lt.IsZero() && !l.created.Before(lt) {
+			continue
+		}
+		matched = append(matched, l)
+	}
+	s.mu.Unlock()
+
+	page := struct {
+		Data    []Letter `json:"data"`
+		NextURL string   `json:"next_url"`
+		Count   int      `json:"count"`
+	}{Data: []Letter{}}
+	if offset < len(matched) {
+		end := min(offset+limit, len(matched))
+		page.Data = matched[offset:end]
+		if end < len(matched) {
+			q.Set("offset", strconv.Itoa(end))
+			page.NextURL = fmt.Sprintf("http://%s%s?%s", r.Host, r.URL.Path, q.Encode())
+		}
+	}
+	page.Count = len(page.Data)
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) verifyAddress(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		writeError(w, "unauthorized", "Your API key is not valid.")
+		return
+	}
+
+	var req struct {
+		PrimaryLine   string `json:"primary_line"`
+		SecondaryLine string `json:"secondary_line"`
+		City          string `json:"city"`
+		State         string `json:"state"`
+		ZipCode       string `json:"zip_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "bad_request", "Request body is not valid JSON.")
+		return
+	}
+	if code := injectedCode(mailer.Address{AddressLine1: req.PrimaryLine, AddressLine2: req.SecondaryLine}); code != "" {
+		writeError(w, code, "Injected failure: "+code)
+		return
+	}
+	if req.PrimaryLine == "" {
+		writeError(w, "invalid_address", "primary_line is required")
+		return
+	}
+
+	zip, _, _ := strings.Cut(strings.TrimSpace(req.ZipCode), "-")
+	v := mailer.Verification{
+		ID:            "us_ver_" + randomHex(10),
+		PrimaryLine:   standardize(req.PrimaryLine),
+		SecondaryLine: standardize(req.SecondaryLine),
+		Components: mailer.VerificationComponents{
+			City:         strings.ToUpper(strings.TrimSpace(req.City)),
+			State:        strings.ToUpper(strings.TrimSpace(req.State)),
+			ZipCode:      zip,
+			ZipCodePlus4: "1234",
+		},
+	}
+	v.LastLine = fmt.Sprintf("%s %s %s-%s", v.Components.City, v.Components.State, zip, v.Components.ZipCodePlus4)
+
+	switch d := strings.ToLower(strings.TrimSpace(req.PrimaryLine)); {
+	case d == mailer.Deliverable || d == mailer.DeliverableMissingUnit || d == mailer.DeliverableIncorrectUnit ||
+		d == mailer.DeliverableUnnecessaryUnit || d == mailer.Undeliverable:
+		v.Deliverability = d
+	case !startsWithDigit(req.PrimaryLine) || len(zip) != 5 || !allDigits(zip):
+		v.Deliverability = mailer.Undeliverable
+	default:
+		v.Deliverability = mailer.Deliverable
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+var streetAbbreviations = map[string]string{
+	"STREET": "ST", "AVENUE": "AVE", "BOULEVARD": "BLVD", "ROAD": "RD", "DRIVE": "DR",
+	"LANE": "LN", "COURT": "CT", "PLACE": "PL", "HIGHWAY": "HWY", "PARKWAY": "PKWY",
+	"SUITE": "STE", "APARTMENT": "APT", "NORTH": "N", "SOUTH": "S", "EAST": "E", "WEST": "W",
+}
+
+// standardize writes an address line the way USPS does: upper case, no
+// punctuation, common words abbreviated.
+func standardize(line string) string {
+	line = strings.NewReplacer(".", "", ",", "").Replace(strings.ToUpper(line))
+	words := strings.Fields(line)
+	for i, w := range words {
+		if abbr, ok := streetAbbreviations[w]; ok {
+			words[i] = abbr
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func startsWithDigit(s string) bool {
+	s = strings.TrimSpace(s)
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// PDFLinkTTL is how long a letter's PDF link works, like the signed links
+// Lob hands out.
+const PDFLinkTTL = 30 * 24 * time.Hour
+
+func pdfURL(host, id string, now time.Time) string {
+	return fmt.Sprintf("http://%s/pdfs/%s.pdf?expires=%d", host, id, now.Add(PDFLinkTTL).Unix())
+}
+
+func (s *Server) servePDF(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(r.PathValue("file"), ".pdf")
+	s.mu.Lock()
+	_, ok := s.letters[id]
+	now := s.now()
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || !now.Before(time.Unix(expires, 0)) {
+		http.Error(w, "Request has expired", http.StatusForbidden)
+		return
+	}
+
+	pdf := placeholderPDF(id)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(pdf)
+}
+
+// sortedLetters must be called with s.mu held.
+func (s *Server) sortedLetters() []Letter {
+	letters := make([]Letter, 0, len(s.letters))
+	for _, l := range s.letters {
+		letters = append(letters, *l)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		if letters[i].created.Equal(letters[j].created) {
+			return letters[i].ID < letters[j].ID
+		}
+		return letters[i].created.Before(letters[j].created)
+	})
+	return letters
+}
+
+func validate(req mailer.LetterRequest) (code, msg string) {
+	for _, a := range []struct {
+		field string
+		addr  mailer.Address
+	}{{"to", req.To}, {"from", req.From}} {
+		for _, f := range []struct{ name, value string }{
+			{"name", a.addr.Name},
+			{"address_line1", a.addr.AddressLine1},
+			{"address_city", a.addr.AddressCity},
+			{"address_state", a.addr.AddressState},
+			{"address_zip", a.addr.AddressZip},
+		} {
+			if f.value == "" {
+				return "invalid", fmt.Sprintf("%s.%s is required", a.field, f.name)
+			}
+		}
+		if len(a.addr.AddressLine1) > 64 {
+			return "address_length_exceeds_limit", fmt.Sprintf("%s.address_line1 must be at most 64 characters", a.field)
+		}
+		if len(a.addr.AddressLine2) > 64 {
+			return "address_length_exceeds_limit", fmt.Sprintf("%s.address_line2 must be at most 64 characters", a.field)
+		}
+	}
+	if req.File == "" {
+		return "invalid", "file is required"
+	}
+	if !extraServices[req.ExtraService] {
+		return "invalid", fmt.Sprintf("extra_service %q is not supported", req.ExtraService)
+	}
+	return "", ""
+}
+
+// parseSendDate accepts the two forms Lob does: a bare date or a full
+// timestamp.
+func parseSendDate(v string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func injectedCode(a mailer.Address) string {
+	for _, line := range []string{a.Name, a.AddressLine1, a.AddressLine2} {
+		if _, code, ok := strings.Cut(line, "lob-error:"); ok {
+			return strings.Fields(code + " ")[0]
+		}
+	}
+	return ""
+}
+
+// authorized accepts any non-empty API key, as Lob's test keys accept any
+// letter.
+func authorized(r *http.Request) bool {
+	key, _, ok := r.BasicAuth()
+	return ok && key != ""
+}
+
+func writeError(w http.ResponseWriter, code, msg string) {
+	status, ok := errorStatus[code]
+	if !ok {
+		status = http.StatusUnprocessableEntity
+	}
+	var body mailer.LobErrorResponse
+	body.Error.Code = code
+	body.Error.Message = msg
+	body.Error.StatusCode = status
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// trackingNumber returns a USPS-style 22-digit certified mail number.
+func trackingNumber() string {
+	var sb strings.Builder
+	sb.WriteString("9407111")
+	for sb.Len() < 22 {
+		d, _ := rand.Int(rand.Reader, big.NewInt(10))
+		sb.WriteString(d.String())
+	}
+	return sb.String()
+}
+
+func addBusinessDays(t time.Time, days int) time.Time {
+	for days > 0 {
+		t = t.AddDate(0, 0, 1)
+		if t.Weekday() != time.Saturday && t.Weekday() != time.Sunday {
+			days--
+		}
+	}
+	return t
+}
+
+// placeholderPDF builds a one-page letter-size PDF that names the letter.
+func placeholderPDF(letterID string) []byte {
+	text := fmt.Sprintf("BT /F1 18 Tf 72 700 Td (Fake Lob letter %s) Tj ET", letterID)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(text), text),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+
+	var sb strings.Builder
+	sb.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = sb.Len()
+		fmt.Fprintf(&sb, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := sb.Len()
+	fmt.Fprintf(&sb, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&sb, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&sb, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(sb.String())
+}
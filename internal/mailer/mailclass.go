@@ -0,0 +1,53 @@
+package mailer
+
+// MailClass is the USPS service a letter is sent with.
+type MailClass string
+
+const (
+	FirstClass             MailClass = "first_class"
+	Certified              MailClass = "certified"
+	CertifiedReturnReceipt MailClass = "certified_return_receipt"
+	Registered             MailClass = "registered"
+)
+
+// MailClasses lists every class, in the order customers are offered them.
+var MailClasses = []MailClass{FirstClass, Certified, CertifiedReturnReceipt, Registered}
+
+func (c MailClass) Valid() bool {
+	for _, known := range MailClasses {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtraService is the value Lob expects in LetterRequest.ExtraService.
+// First class is Lob's default and has none.
+func (c MailClass) ExtraService() string {
+	if c == FirstClass {
+		return ""
+	}
+	return string(c)
+}
+
+// Tracked reports whether USPS gives the letter a tracking number and a
+// record of delivery.
+func (c MailClass) Tracked() bool {
+	return c != FirstClass
+}
+
+// Label is how the class is described to customers.
+func (c MailClass) Label() string {
+	switch c {
+	case FirstClass:
+		return "First Class Mail"
+	case Certified:
+		return "Certified Mail®"
+	case CertifiedReturnReceipt:
+		return "Certified Mail® with Return Receipt"
+	case Registered:
+		return "Registered Mail™"
+	}
+	return string(c)
+}
@@ -2,17 +2,37 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"sendmynotice/internal/apierrors"
+	"sendmynotice/internal/transport"
 )
 
-const lobEndpoint = "https://api.lob.com/v1/letters"
+// DefaultBaseURL is Lob's live API. Tests and local development point the
+// client at lobfake instead.
+const DefaultBaseURL = "https://api.lob.com/v1"
+
+// Mailer is the part of Lob the rest of the app depends on.
+type Mailer interface {
+	SendLetter(ctx context.Context, l LetterRequest) (*LetterResponse, error)
+	ListLetters(ctx context.Context, begin, end time.Time) ([]LetterSummary, error)
+	VerifyAddress(ctx context.Context, a Address) (*Verification, error)
+	CancelLetter(ctx context.Context, letterID string) error
+	GetLetter(ctx context.Context, letterID string) (*LetterResponse, error)
+}
+
+// ErrNotCancellable is returned by CancelLetter once a letter's send date has
+// passed and it has gone to print.
+var ErrNotCancellable = errors.New("letter can no longer be cancelled")
 
 type Address struct {
 	Name           string `json:"name"`
@@ -31,6 +51,13 @@ type LetterRequest struct {
 	Color        bool    `json:"color"`
 	File         string  `json:"file"`
 	ExtraService string  `json:"extra_service,omitempty"` 
+	// SendDate holds a letter until that day (YYYY-MM-DD, at most 180 days
+	// ahead) before it goes to print. Empty sends it right away.
+	SendDate string `json:"send_date,omitempty"`
+
+	// IdempotencyKey is sent as Lob's Idempotency-Key header so a retried
+	// request returns the original letter instead of printing a second one.
+	IdempotencyKey string `json:"-"`
 }
 
 type LetterResponse struct {
@@ -38,6 +65,9 @@ type LetterResponse struct {
 	ExpectedDel string `json:"expected_delivery_date"`
 	URL         string `json:"url"`
 	TrackingNumber string `json:"tracking_number"`
+	// SendDate is when Lob sends the letter to print. Until then it can
+	// still be cancelled.
+	SendDate string `json:"send_date"`
 }
 
 
@@ -58,40 +88,101 @@ type NoticeData struct {
 	OwnerName       string
 	OwnerAddress    string
 	LenderName     string
+	LenderAddress  string
+	DirectContractorName    string
+	DirectContractorAddress string
 	JobDescription  string
 	JobSiteAddress  string
 	EstimatedPrice  string
+	// State is the job site's state, which decides the notice's wording.
+	// Empty means California.
+	State           string
+	// FirstFurnishedDate is when labor or materials were first furnished.
+	// MailBy is the last day the notice covers all of that work, and
+	// CoveredFrom the first day of work it does cover. Orders from before
+	// the deadline was computed have none of them.
+	FirstFurnishedDate string
+	MailBy             string
+	CoveredFrom        string
+}
+
+// WaiverData fills in waiver.html, one of the statutory lien waiver and
+// release forms. Which of the payment fields are printed depends on Form.
+type WaiverData struct {
+	// Form is the form's Civil Code section, e.g. "8132".
+	Form          string
+	Date          string
+	ClaimantName  string
+	ClaimantTitle string
+	CustomerName  string
+	JobLocation   string
+	OwnerName     string
+	// ThroughDate is the last day of work a progress payment covers.
+	ThroughDate string
+	// CheckMaker, CheckAmount and CheckPayableTo identify the check a
+	// conditional waiver depends on.
+	CheckMaker     string
+	CheckAmount    string
+	CheckPayableTo string
+	// PaymentAmount is the progress payment an unconditional progress
+	// waiver acknowledges.
+	PaymentAmount string
+	// PriorWaiverDates and PriorUnpaidAmount list earlier conditional
+	// waivers that were never paid (form 8132 only).
+	PriorWaiverDates  string
+	PriorUnpaidAmount string
+	// DisputedAmount is held back from the release as disputed extras.
+	DisputedAmount string
 }
+
 type Client struct {
 	apiKey     string
+	baseURL    string
 	httpClient *http.Client
 }
 
-func NewClient(apiKey string) *Client {
+var _ Mailer = (*Client)(nil)
+
+// NewClient talks to Lob at baseURL, or at DefaultBaseURL if it is empty.
+func NewClient(apiKey, baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
 	return &Client{
-		apiKey: apiKey,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		// Lob allows 150 requests per 5 seconds per endpoint.
+		httpClient: transport.NewClient(transport.Config{
+			Name:          "lob",
+			RatePerSecond: 25,
+			Burst:         25,
+			Idempotent:    idempotent,
+		}),
 	}
 }
 
-func (c *Client) SendLetter(l LetterRequest) (*LetterResponse, error) {
+// idempotent lets the transport retry letters sent with an idempotency key
+// and address verifications, which change nothing on Lob's side.
+func idempotent(req *http.Request) bool {
+	return transport.DefaultIdempotent(req) || strings.HasSuffix(req.URL.Path, "/us_verifications")
+}
+
+func (c *Client) SendLetter(ctx context.Context, l LetterRequest) (*LetterResponse, error) {
 	jsonBytes, err := json.Marshal(l)
 	if err != nil {
 		return nil, fmt.Errorf("marshalling error: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, lobEndpoint, bytes.NewBuffer(jsonBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/letters", bytes.NewBuffer(jsonBytes))
 	if err != nil {
 		return nil, fmt.Errorf("request creation error: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	
-	authString := c.apiKey + ":"
-	authHeader := "Basic " + base64.StdEncoding.EncodeToString([]byte(authString))
-	req.Header.Set("Authorization", authHeader)
+	c.authorize(req)
+	if l.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", l.IdempotencyKey)
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -121,4 +212,130 @@ func (c *Client) SendLetter(l LetterRequest) (*LetterResponse, error) {
 	}
 
 	return &result, nil
-}
\ No newline at end of file
+}
+
+// CancelLetter stops a letter that has not gone to print yet. Lob only
+// allows this before the letter's send date.
+func (c *Client) CancelLetter(ctx context.Context, letterID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.baseURL+"/letters/"+url.PathEscape(letterID), nil)
+	if err != nil {
+		return fmt.Errorf("request creation error: %w", err)
+	}
+	c.authorize(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("network error: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusForbidden, http.StatusUnprocessableEntity:
+		var lobErr LobErrorResponse
+		_ = json.Unmarshal(body, &lobErr)
+		return fmt.Errorf("%w: %s", ErrNotCancellable, lobErr.Error.Message)
+	}
+	return fmt.Errorf("cancelling letter %s failed (status %d): %s", letterID, resp.StatusCode, string(body))
+}
+
+// GetLetter fetches a letter as Lob has it now. Its URL is freshly signed,
+// so this is how an expired PDF link is renewed.
+func (c *Client) GetLetter(ctx context.Context, letterID string) (*LetterResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/letters/"+url.PathEscape(letterID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request creation error: %w", err)
+	}
+	c.authorize(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching letter %s failed (status %d): %s", letterID, resp.StatusCode, string(body))
+	}
+
+	var result LetterResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("response decoding error: %w", err)
+	}
+	return &result, nil
+}
+
+func (c *Client) authorize(req *http.Request) {
+	authString := c.apiKey + ":"
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(authString)))
+}
+
+// LetterSummary is the part of a Lob letter needed to reconcile it against
+// orders and payments.
+type LetterSummary struct {
+	ID             string `json:"id"`
+	Description    string `json:"description"`
+	TrackingNumber string `json:"tracking_number"`
+	DateCreated    string `json:"date_created"`
+	SendDate       string `json:"send_date"`
+	Deleted        bool   `json:"deleted"`
+}
+
+type letterList struct {
+	Data    []LetterSummary `json:"data"`
+	NextURL string          `json:"next_url"`
+}
+
+// ListLetters returns every letter created in [begin, end), following Lob's
+// pagination to the end.
+func (c *Client) ListLetters(ctx context.Context, begin, end time.Time) ([]LetterSummary, error) {
+	q := url.Values{}
+	q.Set("limit", "100")
+	q.Set("date_created[gte]", begin.UTC().Format(time.RFC3339))
+	q.Set("date_created[lt]", end.UTC().Format(time.RFC3339))
+	next := c.baseURL + "/letters?" + q.Encode()
+
+	var letters []LetterSummary
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, fmt.Errorf("request creation error: %w", err)
+		}
+		c.authorize(req)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("network error: %w", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("listing letters failed (status %d): %s", resp.StatusCode, string(body))
+		}
+
+		var page letterList
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("response decoding error: %w", err)
+		}
+		letters = append(letters, page.Data...)
+		next = page.NextURL
+	}
+	return letters, nil
+}
@@ -0,0 +1,217 @@
+package mailer_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sendmynotice/internal/apierrors"
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/mailer/lobfake"
+)
+
+// newFake serves a fresh lobfake and returns a client pointed at it.
+func newFake(t *testing.T) (*lobfake.Server, *mailer.Client) {
+	t.Helper()
+	fake := lobfake.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, mailer.NewClient("test_key", srv.URL+"/v1")
+}
+
+func address(name, line1 string) mailer.Address {
+	return mailer.Address{
+		Name:           name,
+		AddressLine1:   line1,
+		AddressCity:    "Sacramento",
+		AddressState:   "CA",
+		AddressZip:     "95814",
+		AddressCountry: "US",
+	}
+}
+
+func letter(key string) mailer.LetterRequest {
+	return mailer.LetterRequest{
+		Description:    "Notice - Ref: pay_1",
+		To:             address("Owner", "1 Main St"),
+		From:           address("Contractor", "2 Oak Ave"),
+		File:           "<html>notice</html>",
+		ExtraService:   "certified",
+		IdempotencyKey: key,
+	}
+}
+
+func TestSendLetter(t *testing.T) {
+	fake, client := newFake(t)
+
+	resp, err := client.SendLetter(context.Background(), letter("order-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.ID, "ltr_") {
+		t.Errorf("ID = %q", resp.ID)
+	}
+	if resp.TrackingNumber == "" {
+		t.Error("certified letter has no tracking number")
+	}
+	if resp.URL == "" || resp.SendDate == "" || resp.ExpectedDel == "" {
+		t.Errorf("response missing fields: %+v", resp)
+	}
+
+	letters := fake.Letters()
+	if len(letters) != 1 {
+		t.Fatalf("fake has %d letters, want 1", len(letters))
+	}
+	if l := letters[0]; l.ID != resp.ID || l.To.Name != "Owner" || l.File != "<html>notice</html>" {
+		t.Errorf("fake stored %+v", l)
+	}
+}
+
+func TestSendLetterErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		inject    string
+		edit      func(*mailer.LetterRequest)
+		wantCode  string
+		wantField string
+	}{
+		{
+			name:      "undeliverable",
+			inject:    "failed_deliverability_strictness",
+			wantCode:  "failed_deliverability_strictness",
+			wantField: "to_address1",
+		},
+		{
+			name:      "missing field names the input",
+			edit:      func(l *mailer.LetterRequest) { l.From.AddressZip = "" },
+			wantCode:  "invalid",
+			wantField: "from_zip",
+		},
+		{
+			name:      "line too long",
+			edit:      func(l *mailer.LetterRequest) { l.To.AddressLine1 = strings.Repeat("x", 65) },
+			wantCode:  "address_length_exceeds_limit",
+			wantField: "to_address1",
+		},
+		{
+			name:     "code we do not know",
+			inject:   "something_new",
+			wantCode: "unknown_validation_error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFake(t)
+			if tt.inject != "" {
+				fake.FailNext(tt.inject)
+			}
+			req := letter("")
+			if tt.edit != nil {
+				tt.edit(&req)
+			}
+
+			_, err := client.SendLetter(context.Background(), req)
+			var ue *apierrors.UserError
+			if !errors.As(err, &ue) {
+				t.Fatalf("err = %v, want *apierrors.UserError", err)
+			}
+			if ue.Code != tt.wantCode {
+				t.Errorf("Code = %q, want %q", ue.Code, tt.wantCode)
+			}
+			if ue.Field != tt.wantField {
+				t.Errorf("Field = %q, want %q", ue.Field, tt.wantField)
+			}
+			if ue.UserMessage == "" {
+				t.Error("no UserMessage")
+			}
+			if n := len(fake.Letters()); n != 0 {
+				t.Errorf("fake has %d letters, want 0", n)
+			}
+		})
+	}
+}
+
+func TestSendLetterIdempotencyReplay(t *testing.T) {
+	tests := []struct {
+		name        string
+		first, then string
+		wantSame    bool
+	}{
+		{name: "same key returns the first letter", first: "order-1", then: "order-1", wantSame: true},
+		{name: "different keys make two letters", first: "order-1", then: "order-2"},
+		{name: "no key makes two letters", first: "", then: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFake(t)
+			first, err := client.SendLetter(context.Background(), letter(tt.first))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A replay is answered from the key before anything else is
+			// checked, so it succeeds even when a new letter would fail.
+			if tt.wantSame {
+				fake.FailNext("failed_deliverability_strictness")
+			}
+			second, err := client.SendLetter(context.Background(), letter(tt.then))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := second.ID == first.ID; got != tt.wantSame {
+				t.Errorf("second letter %s, first %s, want same %v", second.ID, first.ID, tt.wantSame)
+			}
+			want := 2
+			if tt.wantSame {
+				want = 1
+			}
+			if n := len(fake.Letters()); n != want {
+				t.Errorf("fake has %d letters, want %d", n, want)
+			}
+		})
+	}
+}
+
+func TestCancelLetter(t *testing.T) {
+	tests := []struct {
+		name           string
+		window         time.Duration
+		id             string
+		wantErr        bool
+		notCancellable bool
+	}{
+		{name: "before send date", window: time.Hour},
+		{name: "after send date", window: 0, wantErr: true, notCancellable: true},
+		{name: "unknown letter", window: time.Hour, id: "ltr_missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFake(t)
+			fake.SetCancelWindow(tt.window)
+			sent, err := client.SendLetter(context.Background(), letter("order-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := tt.id
+			if id == "" {
+				id = sent.ID
+			}
+
+			err = client.CancelLetter(context.Background(), id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CancelLetter() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := errors.Is(err, mailer.ErrNotCancellable); got != tt.notCancellable {
+				t.Errorf("errors.Is(err, ErrNotCancellable) = %v, want %v (err %v)", got, tt.notCancellable, err)
+			}
+			if deleted := fake.Letters()[0].Deleted; deleted == tt.wantErr {
+				t.Errorf("Deleted = %v after CancelLetter error %v", deleted, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"sendmynotice/internal/apierrors"
+)
+
+// Deliverability values returned by Lob's US verification API.
+const (
+	Deliverable                = "deliverable"
+	DeliverableUnnecessaryUnit = "deliverable_unnecessary_unit"
+	DeliverableIncorrectUnit   = "deliverable_incorrect_unit"
+	DeliverableMissingUnit     = "deliverable_missing_unit"
+	Undeliverable              = "undeliverable"
+)
+
+type verificationRequest struct {
+	PrimaryLine   string `json:"primary_line"`
+	SecondaryLine string `json:"secondary_line,omitempty"`
+	City          string `json:"city"`
+	State         string `json:"state"`
+	ZipCode       string `json:"zip_code"`
+}
+
+// VerificationComponents is the subset of Lob's parsed address we use.
+type VerificationComponents struct {
+	City         string `json:"city"`
+	State        string `json:"state"`
+	ZipCode      string `json:"zip_code"`
+	ZipCodePlus4 string `json:"zip_code_plus_4"`
+}
+
+type Verification struct {
+	ID             string                 `json:"id"`
+	PrimaryLine    string                 `json:"primary_line"`
+	SecondaryLine  string                 `json:"secondary_line"`
+	LastLine       string                 `json:"last_line"`
+	Deliverability string                 `json:"deliverability"`
+	Components     VerificationComponents `json:"components"`
+}
+
+// Deliverable reports whether USPS will deliver to the address, possibly
+// after fixing the unit number.
+func (v *Verification) Deliverable() bool {
+	return v.Deliverability != Undeliverable && v.Deliverability != ""
+}
+
+// Issue explains a deliverability result in words a customer understands,
+// or returns "" when there is nothing to say.
+func (v *Verification) Issue() string {
+	switch v.Deliverability {
+	case Undeliverable:
+		return "USPS does not recognise this address. Please check the street number, street name and ZIP code."
+	case DeliverableMissingUnit:
+		return "This address needs a suite or unit number to be delivered."
+	case DeliverableIncorrectUnit:
+		return "The suite or unit number does not exist at this address."
+	case DeliverableUnnecessaryUnit:
+		return "This address does not need a suite or unit number."
+	}
+	return ""
+}
+
+// Corrected returns a with its street, city, state and ZIP replaced by the
+// USPS-standardised version. The name and country are kept.
+func (v *Verification) Corrected(a Address) Address {
+	a.AddressLine1 = strings.TrimSpace(v.PrimaryLine + " " + v.SecondaryLine)
+	a.AddressLine2 = ""
+	a.AddressCity = v.Components.City
+	a.AddressState = v.Components.State
+	a.AddressZip = v.Components.ZipCode
+	return a
+}
+
+// Changed reports whether the standardised address differs from a in
+// anything other than case, spacing or punctuation.
+func (v *Verification) Changed(a Address) bool {
+	c := v.Corrected(a)
+	return normalize(a.AddressLine1+" "+a.AddressLine2) != normalize(c.AddressLine1) ||
+		normalize(a.AddressCity) != normalize(c.AddressCity) ||
+		normalize(a.AddressState) != normalize(c.AddressState) ||
+		normalize(zip5(a.AddressZip)) != normalize(c.AddressZip)
+}
+
+func normalize(s string) string {
+	s = strings.ToUpper(s)
+	s = strings.NewReplacer(".", "", ",", "", "#", " ").Replace(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func zip5(zip string) string {
+	if len(zip) > 5 {
+		return zip[:5]
+	}
+	return zip
+}
+
+// VerifyAddress asks Lob whether USPS can deliver to a, and how it would
+// write the address.
+func (c *Client) VerifyAddress(ctx context.Context, a Address) (*Verification, error) {
+	jsonBytes, err := json.Marshal(verificationRequest{
+		PrimaryLine:   a.AddressLine1,
+		SecondaryLine: a.AddressLine2,
+		City:          a.AddressCity,
+		State:         a.AddressState,
+		ZipCode:       a.AddressZip,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/us_verifications", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return nil, fmt.Errorf("request creation error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.authorize(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var lobErr LobErrorResponse
+		if jsonErr := json.Unmarshal(body, &lobErr); jsonErr == nil && lobErr.Error.Code != "" {
+			return nil, apierrors.MapLobError(lobErr.Error.Code, lobErr.Error.Message)
+		}
+		return nil, fmt.Errorf("address verification failed (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var result Verification
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("response decoding error: %w", err)
+	}
+	return &result, nil
+}
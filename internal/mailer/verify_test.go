@@ -0,0 +1,90 @@
+package mailer_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"sendmynotice/internal/apierrors"
+	"sendmynotice/internal/mailer"
+)
+
+func TestVerifyAddress(t *testing.T) {
+	tests := []struct {
+		name           string
+		addr           mailer.Address
+		deliverability string
+		changed        bool
+		corrected      mailer.Address
+		issue          bool
+	}{
+		{
+			name:           "deliverable as typed",
+			addr:           mailer.Address{Name: "Owner", AddressLine1: "123 Main St.", AddressCity: "Sacramento", AddressState: "CA", AddressZip: "95814-0001"},
+			deliverability: mailer.Deliverable,
+			corrected:      mailer.Address{Name: "Owner", AddressLine1: "123 MAIN ST", AddressCity: "SACRAMENTO", AddressState: "CA", AddressZip: "95814"},
+		},
+		{
+			name:           "corrected by USPS",
+			addr:           mailer.Address{Name: "Owner", AddressLine1: "123 North Main Street", AddressLine2: "Suite 4", AddressCity: "sacramento", AddressState: "ca", AddressZip: "95814", AddressCountry: "US"},
+			deliverability: mailer.Deliverable,
+			changed:        true,
+			corrected:      mailer.Address{Name: "Owner", AddressLine1: "123 N MAIN ST STE 4", AddressCity: "SACRAMENTO", AddressState: "CA", AddressZip: "95814", AddressCountry: "US"},
+		},
+		{
+			name:           "missing unit",
+			addr:           mailer.Address{AddressLine1: "deliverable_missing_unit", AddressCity: "Sacramento", AddressState: "CA", AddressZip: "95814"},
+			deliverability: mailer.DeliverableMissingUnit,
+			corrected:      mailer.Address{AddressLine1: "DELIVERABLE_MISSING_UNIT", AddressCity: "SACRAMENTO", AddressState: "CA", AddressZip: "95814"},
+			issue:          true,
+		},
+		{
+			name:           "undeliverable",
+			addr:           mailer.Address{AddressLine1: "undeliverable", AddressCity: "Sacramento", AddressState: "CA", AddressZip: "95814"},
+			deliverability: mailer.Undeliverable,
+			corrected:      mailer.Address{AddressLine1: "UNDELIVERABLE", AddressCity: "SACRAMENTO", AddressState: "CA", AddressZip: "95814"},
+			issue:          true,
+		},
+		{
+			name:           "no street number",
+			addr:           mailer.Address{AddressLine1: "Main St", AddressCity: "Sacramento", AddressState: "CA", AddressZip: "95814"},
+			deliverability: mailer.Undeliverable,
+			corrected:      mailer.Address{AddressLine1: "MAIN ST", AddressCity: "SACRAMENTO", AddressState: "CA", AddressZip: "95814"},
+			issue:          true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newFake(t)
+			v, err := client.VerifyAddress(context.Background(), tt.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Deliverability != tt.deliverability {
+				t.Errorf("Deliverability = %q, want %q", v.Deliverability, tt.deliverability)
+			}
+			if got, want := v.Deliverable(), tt.deliverability != mailer.Undeliverable; got != want {
+				t.Errorf("Deliverable() = %v, want %v", got, want)
+			}
+			if got := v.Changed(tt.addr); got != tt.changed {
+				t.Errorf("Changed() = %v, want %v", got, tt.changed)
+			}
+			if got := v.Corrected(tt.addr); got != tt.corrected {
+				t.Errorf("Corrected() = %+v, want %+v", got, tt.corrected)
+			}
+			if got := v.Issue() != ""; got != tt.issue {
+				t.Errorf("Issue() = %q, want an issue %v", v.Issue(), tt.issue)
+			}
+		})
+	}
+}
+
+func TestVerifyAddressError(t *testing.T) {
+	_, client := newFake(t)
+	_, err := client.VerifyAddress(context.Background(), mailer.Address{AddressCity: "Sacramento", AddressState: "CA", AddressZip: "95814"})
+	var ue *apierrors.UserError
+	if !errors.As(err, &ue) || ue.Code != "invalid_address" {
+		t.Errorf("err = %v, want invalid_address", err)
+	}
+}
@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lob signs every webhook with the endpoint's secret. See
+// https://docs.lob.com/#tag/Webhooks
+const (
+	SignatureHeader          = "Lob-Signature"
+	SignatureTimestampHeader = "Lob-Signature-Timestamp"
+
+	// Deliveries older than this are rejected so a captured request can't be
+	// replayed later.
+	webhookTolerance = 5 * time.Minute
+)
+
+var ErrInvalidSignature = errors.New("invalid lob webhook signature")
+
+type TrackingEvent struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	Time     string `json:"time"`
+}
+
+type WebhookEvent struct {
+	ID          string `json:"id"`
+	ReferenceID string `json:"reference_id"`
+	DateCreated string `json:"date_created"`
+	EventType   struct {
+		ID       string `json:"id"`
+		Resource string `json:"resource"`
+	} `json:"event_type"`
+	Body struct {
+		ID             string          `json:"id"`
+		TrackingNumber string          `json:"tracking_number"`
+		TrackingEvents []TrackingEvent `json:"tracking_events"`
+	} `json:"body"`
+}
+
+// LetterID is the letter the event is about.
+func (e *WebhookEvent) LetterID() string {
+	if e.Body.ID != "" {
+		return e.Body.ID
+	}
+	return e.ReferenceID
+}
+
+// IsLetterEvent reports whether this is a letter.* event.
+func (e *WebhookEvent) IsLetterEvent() bool {
+	return strings.HasPrefix(e.EventType.ID, "letter.")
+}
+
+// trackingEvents are the letter events that report where USPS has the
+// letter. Every letter.certified.* event is one as well.
+var trackingEvents = map[string]bool{
+	"mailed":                 true,
+	"in_transit":             true,
+	"in_local_area":          true,
+	"processed_for_delivery": true,
+	"re-routed":              true,
+	"returned_to_sender":     true,
+	"delivered":              true,
+}
+
+// TrackingStatus collapses the event type into the status we show customers,
+// e.g. "letter.certified.delivered" becomes "delivered". ok is false for
+// events that say nothing about the letter's progress through the mail,
+// such as letter.rendered_pdf or letter.informed_delivery.*.
+func (e *WebhookEvent) TrackingStatus() (status string, ok bool) {
+	status = strings.TrimPrefix(e.EventType.ID, "letter.")
+	if certified, found := strings.CutPrefix(status, "certified."); found {
+		return certified, true
+	}
+	return status, trackingEvents[status]
+}
+
+// OccurredAt is when Lob recorded the event, falling back to now.
+func (e *WebhookEvent) OccurredAt() time.Time {
+	if t, err := time.Parse(time.RFC3339, e.DateCreated); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// SignWebhook computes the signature Lob would send for body at timestamp.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature headers of a Lob delivery and decodes it.
+func VerifyWebhook(secret, signature, timestamp string, body []byte, now time.Time) (*WebhookEvent, error) {
+	if secret == "" || signature == "" || timestamp == "" {
+		return nil, ErrInvalidSignature
+	}
+
+	expected := SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, ErrInvalidSignature
+	}
+
+	sent, err := parseWebhookTimestamp(timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if d := now.Sub(sent); d > webhookTolerance || d < -webhookTolerance {
+		return nil, fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("decoding webhook event: %w", err)
+	}
+	if event.ID == "" {
+		return nil, errors.New("webhook event has no id")
+	}
+	return &event, nil
+}
+
+// Lob sends epoch milliseconds; seconds and RFC 3339 are accepted as well.
+func parseWebhookTimestamp(ts string) (time.Time, error) {
+	if n, err := strconv.ParseInt(ts, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, ts)
+}
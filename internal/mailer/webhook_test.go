@@ -0,0 +1,80 @@
+package mailer_test
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"sendmynotice/internal/mailer"
+)
+
+func TestTrackingStatus(t *testing.T) {
+	tests := []struct {
+		eventType string
+		want      string
+		tracked   bool
+	}{
+		{eventType: "letter.mailed", want: "mailed", tracked: true},
+		{eventType: "letter.in_transit", want: "in_transit", tracked: true},
+		{eventType: "letter.in_local_area", want: "in_local_area", tracked: true},
+		{eventType: "letter.processed_for_delivery", want: "processed_for_delivery", tracked: true},
+		{eventType: "letter.re-routed", want: "re-routed", tracked: true},
+		{eventType: "letter.returned_to_sender", want: "returned_to_sender", tracked: true},
+		{eventType: "letter.delivered", want: "delivered", tracked: true},
+		{eventType: "letter.certified.delivered", want: "delivered", tracked: true},
+		{eventType: "letter.certified.pickup_available", want: "pickup_available", tracked: true},
+		{eventType: "letter.certified.issue", want: "issue", tracked: true},
+		{eventType: "letter.created", want: "created"},
+		{eventType: "letter.rendered_pdf", want: "rendered_pdf"},
+		{eventType: "letter.rendered_thumbnails", want: "rendered_thumbnails"},
+		{eventType: "letter.viewed", want: "viewed"},
+		{eventType: "letter.informed_delivery.email_sent", want: "informed_delivery.email_sent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.eventType, func(t *testing.T) {
+			var e mailer.WebhookEvent
+			e.EventType.ID = tt.eventType
+			got, tracked := e.TrackingStatus()
+			if got != tt.want || tracked != tt.tracked {
+				t.Errorf("TrackingStatus() = %q, %v, want %q, %v", got, tracked, tt.want, tt.tracked)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhookFixtures(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "lob_letter_certified_delivered.json", want: "delivered"},
+		{file: "lob_letter_certified_in_transit.json", want: "in_transit"},
+		{file: "lob_letter_returned_to_sender.json", want: "returned_to_sender"},
+	}
+
+	now := time.Now()
+	ts := strconv.FormatInt(now.UnixMilli(), 10)
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			body, err := os.ReadFile("testdata/" + tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := mailer.VerifyWebhook("secret", mailer.SignWebhook("other", ts, body), ts, body, now); err == nil {
+				t.Error("accepted a signature made with another secret")
+			}
+			e, err := mailer.VerifyWebhook("secret", mailer.SignWebhook("secret", ts, body), ts, body, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status, tracked := e.TrackingStatus(); status != tt.want || !tracked {
+				t.Errorf("TrackingStatus() = %q, %v, want %q", status, tracked, tt.want)
+			}
+			if e.LetterID() == "" || !e.IsLetterEvent() {
+				t.Errorf("event %+v is not about a letter", e)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package orders
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/storage"
+)
+
+// ErrNotCancellable is returned by CancelOrder once any letter of the order
+// has gone to print.
+var ErrNotCancellable = errors.New("order can no longer be cancelled")
+
+// ErrInvalidCancelLink is returned for a cancel link that was not signed by
+// us or whose order can no longer be cancelled anyway.
+var ErrInvalidCancelLink = errors.New("invalid or expired cancel link")
+
+// Cancellable reports whether o was mailed and every mailed letter is still
+// waiting for its send date at now. Orders are cancelled whole, so one letter
+// that has gone to print rules the order out.
+func Cancellable(o *storage.Order, now time.Time) bool {
+	if o.Status != storage.OrderLetterSubmitted && o.Status != storage.OrderReceiptSent {
+		return false
+	}
+	mailed := false
+	for _, l := range o.Letters {
+		if l.Status != storage.LetterSubmitted {
+			continue
+		}
+		if l.SendDate == nil || !now.Before(*l.SendDate) {
+			return false
+		}
+		mailed = true
+	}
+	return mailed
+}
+
+// CancelDeadline is the earliest send date among o's mailed letters, after
+// which the order cannot be cancelled.
+func CancelDeadline(o *storage.Order) time.Time {
+	var deadline time.Time
+	for _, l := range o.Letters {
+		if l.Status != storage.LetterSubmitted || l.SendDate == nil {
+			continue
+		}
+		if deadline.IsZero() || l.SendDate.Before(deadline) {
+			deadline = *l.SendDate
+		}
+	}
+	return deadline
+}
+
+// CancelOrder stops every mailed letter of o at Lob and refunds each one's
+// share of the payment, queueing any refund Square turns down. reason is kept
+// on the order and its letters. If Lob refuses a letter part way through, the
+// letters already stopped stay cancelled and refunded, and the error is
+// returned.
+func (p *Processor) CancelOrder(ctx context.Context, o *storage.Order, reason string) error {
+	if !Cancellable(o, time.Now()) {
+		return ErrNotCancellable
+	}
+
+	var firstErr error
+	var cancelledCents int64
+	now := time.Now().UTC()
+	for i := range o.Letters {
+		l := &o.Letters[i]
+		if l.Status != storage.LetterSubmitted {
+			continue
+		}
+		if err := p.mailer.CancelLetter(ctx, l.LetterID); err != nil {
+			log.Printf("Could not cancel %s letter %s of order #%d: %v", l.Role, l.LetterID, o.ID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		l.CancelledAt = &now
+		cancelledCents += l.AmountCents
+		p.refundLetter(ctx, o, i, errors.New(reason))
+	}
+
+	if cancelledCents > 0 {
+		p.alert(fmt.Sprintf("↩️ ORDER CANCELLED: $%.2f", float64(cancelledCents)/100),
+			fmt.Sprintf("Order #%d\nCustomer: %s\nEmail: %s\nPayment: %s\nReason: %s", o.ID, o.Notice.SenderName, o.UserEmail, o.PaymentID, reason))
+	}
+	if firstErr != nil {
+		if cancelledCents > 0 {
+			p.alert("⚠️ Order only partly cancelled", fmt.Sprintf("Order #%d\nPayment: %s\nError: %v", o.ID, o.PaymentID, firstErr))
+		}
+		if errors.Is(firstErr, mailer.ErrNotCancellable) {
+			return fmt.Errorf("%w: %v", ErrNotCancellable, firstErr)
+		}
+		return firstErr
+	}
+
+	to := storage.OrderRefunded
+	for _, l := range o.Letters {
+		if l.Status == storage.LetterFailed {
+			to = storage.OrderFailed
+		}
+	}
+	o.LastError = reason
+	err := p.db.TransitionOrder(ctx, o, o.Status, to)
+	if errors.Is(err, storage.ErrOrderStateChanged) {
+		// The receipt went out while the letters were being cancelled.
+		err = p.db.TransitionOrder(ctx, o, storage.OrderReceiptSent, to)
+	}
+	if err != nil {
+		log.Printf("ERROR: cancelled order #%d but could not record it: %v", o.ID, err)
+		return fmt.Errorf("recording cancellation failed: %w", err)
+	}
+	return nil
+}
+
+// CancelLinks signs the links customers follow from their receipt to cancel
+// an order. A link is only good until the order's cancel deadline.
+type CancelLinks struct {
+	BaseURL string
+	Key     []byte
+}
+
+// URL is the cancel link for o.
+func (c *CancelLinks) URL(o *storage.Order) string {
+	expires := CancelDeadline(o).Unix()
+	return fmt.Sprintf("%s/orders/%d/cancel?expires=%d&sig=%s", c.BaseURL, o.ID, expires, c.sign(o.ID, expires))
+}
+
+// Verify checks the expires and sig parameters of a cancel link for order
+// orderID.
+func (c *CancelLinks) Verify(orderID int, expires, sig string, now time.Time) error {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidCancelLink
+	}
+	if !hmac.Equal([]byte(sig), []byte(c.sign(orderID, exp))) {
+		return ErrInvalidCancelLink
+	}
+	if !now.Before(time.Unix(exp, 0)) {
+		return ErrInvalidCancelLink
+	}
+	return nil
+}
+
+func (c *CancelLinks) sign(orderID int, expires int64) string {
+	mac := hmac.New(sha256.New, c.Key)
+	fmt.Fprintf(mac, "cancel:%d:%d", orderID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
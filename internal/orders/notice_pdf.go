@@ -0,0 +1,188 @@
+package orders
+
+import (
+	"strings"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/pdf"
+	"sendmynotice/internal/states"
+)
+
+// Page margins of notice.html's .document-container: 0.75in top and bottom,
+// 1in left and right.
+const (
+	marginX      = 72.0
+	marginTop    = 54.0
+	marginBottom = 54.0
+	contentWidth = pdf.PageWidth - 2*marginX
+)
+
+var (
+	noteBlue   = pdf.Hex("#2563eb")
+	noteFill   = pdf.Hex("#eff6ff")
+	boxFill    = pdf.Hex("#f0f0f0")
+	labelGray  = pdf.Hex("#444444")
+	ruleGray   = pdf.Hex("#cccccc")
+	footerGray = pdf.Hex("#888888")
+)
+
+// NoticePDF lays out the same preliminary notice as notice.html — a cover
+// letter and the statutory form — as a letter-size PDF, for customers who
+// print and mail it themselves.
+func NoticePDF(data mailer.NoticeData) ([]byte, error) {
+	rules, err := states.For(data.State)
+	if err != nil {
+		return nil, err
+	}
+	doc := pdf.New(rules.Title + " - " + rules.Statute)
+	coverLetter(doc.AddPage(), data, rules)
+	noticeForm(doc, data, rules)
+	return doc.Bytes(), nil
+}
+
+func coverLetter(p *pdf.Page, data mailer.NoticeData, rules *states.Rules) {
+	body := pdf.Style{Size: 11, Leading: 16.5, Color: pdf.Black}
+	right := marginX + contentWidth
+
+	y := marginTop + 14
+	p.Text(marginX, y, pdf.TimesBold, 14, pdf.Black, data.SenderName)
+	p.TextRight(right, y, pdf.Times, 10, pdf.Black, "Date: "+data.Date)
+	y += 8
+	p.Line(marginX, y, right, y, 1, pdf.Black, 0)
+	y += 30 + body.Size
+
+	y = p.Paragraph(marginX, y, contentWidth, body,
+		pdf.Span{Font: pdf.TimesBold, Text: "RE: NOTICE OF PRELIMINARY RIGHTS\n"},
+		pdf.Span{Font: pdf.Times, Text: "Job Site: "},
+		pdf.Span{Font: pdf.Courier, Text: data.JobSiteAddress},
+	)
+	y += body.Size
+	y = p.Paragraph(marginX, y, contentWidth, body, pdf.Span{Font: pdf.Times, Text: "Dear Property Owner,"})
+	y += body.Size
+	y = p.Paragraph(marginX, y, contentWidth, body,
+		pdf.Span{Font: pdf.Times, Text: "Enclosed is " + rules.Article() + " "},
+		pdf.Span{Font: pdf.TimesBold, Text: rules.Title},
+		pdf.Span{Font: pdf.Times, Text: ". " + rules.CoverLetter},
+	)
+
+	// The NOTE box: 4pt blue rule on the left, 10pt by 15pt padding.
+	note := []pdf.Span{
+		{Font: pdf.TimesBold, Text: "NOTE:"},
+		{Font: pdf.Times, Text: " This is "},
+		{Font: pdf.Times, Text: "NOT", Underline: true},
+		{Font: pdf.Times, Text: " a lien. It is not a bill. It is a statutory notice required by law to protect all parties."},
+	}
+	noteWidth := contentWidth - 4 - 30
+	top := y - body.Leading + body.Size + 20
+	h := 20 + float64(pdf.Lines(noteWidth, body.Size, note...))*body.Leading
+	p.Rect(marginX, top, contentWidth, h, &noteFill, 0, pdf.Black)
+	p.Rect(marginX, top, 4, h, &noteBlue, 0, pdf.Black)
+	p.Paragraph(marginX+4+15, top+10+body.Leading*0.75, noteWidth, body, note...)
+	y = top + h + 20
+
+	y += 2*body.Leading + body.Size
+	y = p.Paragraph(marginX, y, contentWidth, body, pdf.Span{Font: pdf.Times, Text: "Respectfully,"})
+	y += body.Size
+	p.Text(marginX, y, pdf.CourierBold, body.Size, pdf.Black, data.SenderName)
+	y += body.Leading
+	p.Text(marginX, y, pdf.Times, body.Size, pdf.Black, data.SenderRole)
+}
+
+func noticeForm(doc *pdf.Document, data mailer.NoticeData, rules *states.Rules) {
+	p := doc.AddPage()
+	right := marginX + contentWidth
+	footer := rules.FormName + " • Generated by SendMyNotice.com • Ref: Cert. Mail Tracking Included"
+	y := formHeader(p, strings.ToUpper(rules.Title), rules.Subtitle)
+
+	// The warning box, 9pt justified with 12pt padding inside a 2pt border.
+	warning := pdf.Style{Size: 9, Leading: 11.7, Color: pdf.Black, Justify: true}
+	textWidth := contentWidth - 4 - 24
+	paragraphs := [][]pdf.Span{{{Font: pdf.Times, Text: rules.WarningTitle}}}
+	for _, para := range rules.Warning {
+		var spans []pdf.Span
+		if para.Lead != "" {
+			spans = append(spans, pdf.Span{Font: pdf.TimesBold, Text: para.Lead})
+		}
+		paragraphs = append(paragraphs, append(spans, pdf.Span{Font: pdf.Times, Text: para.Text}))
+	}
+	h := 2*12.0 + 2 + float64(len(paragraphs)-1)*warning.Size
+	for _, spans := range paragraphs {
+		h += float64(pdf.Lines(textWidth, warning.Size, spans...)) * warning.Leading
+	}
+	p.Rect(marginX+1, y+1, contentWidth-2, h-2, &boxFill, 2, pdf.Black)
+	ty := y + 2 + 12 + warning.Size
+	for _, spans := range paragraphs {
+		ty = p.Paragraph(marginX+2+12, ty, textWidth, warning, spans...) + warning.Size
+	}
+	y += h + 20
+
+	rows := []struct{ label, value string }{
+		{"1. Claimant (Sender)", data.SenderName + "\n" + data.SenderAddress},
+		{"2. Owner (Recipient)", data.OwnerName + "\n" + data.OwnerAddress},
+		{"3. Direct Contractor", orNone(data.DirectContractorName, data.DirectContractorAddress)},
+		{"4. Job Site", data.JobSiteAddress},
+		{"5. Work Description", data.JobDescription},
+		{"6. Estimated Value", "$" + data.EstimatedPrice},
+		{"7. Lender", orNone(data.LenderName, data.LenderAddress)},
+		{"8. Relationship", data.SenderRole},
+	}
+	if data.FirstFurnishedDate != "" {
+		first := data.FirstFurnishedDate
+		if data.CoveredFrom != first {
+			first += "\nCovers labor and materials furnished on or after " + data.CoveredFrom
+		}
+		rows = append(rows, struct{ label, value string }{"9. First Furnished", first})
+	}
+	label := pdf.Style{Size: 9, Leading: 11.7, Color: labelGray}
+	value := pdf.Style{Size: 10, Leading: 13, Color: pdf.Black}
+	labelWidth := 120.0
+	valueWidth := contentWidth - labelWidth
+	for _, row := range rows {
+		labelSpan := pdf.Span{Font: pdf.TimesBold, Text: strings.ToUpper(row.label)}
+		valueSpan := pdf.Span{Font: pdf.CourierBold, Text: row.value}
+		rowHeight := max(
+			float64(pdf.Lines(labelWidth-4, label.Size, labelSpan))*label.Leading,
+			float64(pdf.Lines(valueWidth, value.Size, valueSpan))*value.Leading,
+		) + 4
+		if y+rowHeight > pdf.PageHeight-marginBottom-24 {
+			pageFooter(p, footer)
+			p = doc.AddPage()
+			y = marginTop
+		}
+		p.Paragraph(marginX, y+label.Size, labelWidth-4, label, labelSpan)
+		p.Paragraph(marginX+labelWidth, y+value.Size, valueWidth, value, valueSpan)
+		y += rowHeight
+		p.Line(marginX, y, right, y, 1, ruleGray, 1)
+		y += 12
+	}
+	pageFooter(p, footer)
+}
+
+// formHeader draws the centered title, subtitle and heavy rule that open
+// each statutory form, and returns where the body starts.
+func formHeader(p *pdf.Page, title, subtitle string) float64 {
+	center := pdf.PageWidth / 2
+	y := marginTop + 20 + 14
+	p.TextCenter(center, y, pdf.TimesBold, 14, pdf.Black, title)
+	y += 14
+	p.TextCenter(center, y, pdf.TimesBold, 10, pdf.Black, subtitle)
+	y += 10
+	p.Line(marginX, y, marginX+contentWidth, y, 2, pdf.Black, 0)
+	return y + 25
+}
+
+// pageFooter centers text in small gray type half an inch from the bottom.
+func pageFooter(p *pdf.Page, text string) {
+	p.TextCenter(pdf.PageWidth/2, pdf.PageHeight-36, pdf.Times, 8, footerGray, text)
+}
+
+// orNone is a party's name and address, or "NONE REP." if there is none.
+func orNone(name, address string) string {
+	if name == "" {
+		return "NONE REP."
+	}
+	if address == "" {
+		return name
+	}
+	return name + "\n" + address
+}
@@ -0,0 +1,569 @@
+package orders
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"time"
+
+	"sendmynotice/internal/email"
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/payment"
+	"sendmynotice/internal/states"
+	"sendmynotice/internal/storage"
+	"sendmynotice/internal/templates"
+	"sendmynotice/internal/waivers"
+)
+
+// An order moves through the states below. Every transition is persisted
+// before the next side effect starts, so a crash at any point leaves a row
+// the resumer can pick up and drive to a terminal state.
+//
+//	created ──charge──▶ charged ──letters──▶ letter_submitted ──receipt──▶ receipt_sent
+//	   │                   │
+//	   ▼                   ▼
+//	 failed        refunded / failed (refund did not go through)
+//
+// Until its letters go to print, a letter_submitted or receipt_sent order can
+// be cancelled, which also ends in refunded or failed.
+//
+// A scheduled order goes through the same states: its letters are handed to
+// Lob straight away with a send date, and Lob holds them until that day.
+//
+// An order holds one letter per recipient, each priced separately. The
+// order reaches letter_submitted once any of them is mailed; the share of a
+// letter Lob rejects is refunded on its own.
+
+const sourceTokenTTL = 24 * time.Hour
+
+// MaxScheduleAhead is the furthest ahead Lob will hold a letter.
+const MaxScheduleAhead = 180 * 24 * time.Hour
+
+// letterPrices is what one letter costs in each mail class.
+var letterPrices = map[mailer.MailClass]int64{
+	mailer.FirstClass:             1500,
+	mailer.Certified:              2900,
+	mailer.CertifiedReturnReceipt: 3500,
+	mailer.Registered:             4900,
+}
+
+// LetterPrice returns what one letter sent with class costs, in cents.
+func LetterPrice(class mailer.MailClass) (int64, error) {
+	cents, ok := letterPrices[class]
+	if !ok {
+		return 0, fmt.Errorf("unknown mail class %q", class)
+	}
+	return cents, nil
+}
+
+type ReceiptLetter struct {
+	Recipient      string
+	Name           string
+	MailClass      string
+	TrackingNumber string
+	TrackingLink   string
+	PDFURL         string
+}
+
+type ReceiptData struct {
+	PaymentID  string
+	Name       string
+	Date       string
+	JobAddress string
+	Total      string
+	// Tracked is set when every mailed letter went by a class with proof of
+	// delivery.
+	Tracked bool
+	// Letters are the letters that were mailed; Refunded are the ones Lob
+	// rejected, whose price went back to the card.
+	Letters       []ReceiptLetter
+	Refunded      []ReceiptLetter
+	RefundedTotal string
+	// CancelURL lets the customer cancel the order while it still can be.
+	CancelURL string
+	// MailDate is set while a scheduled order waits for its send date;
+	// MailedOn once it has entered the mail stream.
+	MailDate string
+	MailedOn string
+	// ProofOfService is set when the proof of service is attached.
+	ProofOfService bool
+	// NoticeTitle and Statute name the notice that was sent.
+	NoticeTitle string
+	Statute     string
+	// Document is what was sent in plain words, "Preliminary Notice" or
+	// "Lien Waiver". Waiver is set for a lien waiver, which has no
+	// delivery requirement to satisfy.
+	Document string
+	Waiver   bool
+}
+
+type Processor struct {
+	db      storage.Store
+	mailer  mailer.Mailer
+	payment *payment.Client
+	email   *email.Client
+	alert   func(subject, body string)
+	notice  *template.Template
+	receipt *template.Template
+	mailed  *template.Template
+	service *template.Template
+	waiver  *template.Template
+	links   *CancelLinks
+	proofs  *ProofLinks
+}
+
+func NewProcessor(db storage.Store, mailerClient mailer.Mailer, paymentClient *payment.Client, emailClient *email.Client, alert func(subject, body string)) (*Processor, error) {
+	noticeTmpl, err := template.ParseFS(templates.GetNoticeFS(), "notice.html")
+	if err != nil {
+		return nil, fmt.Errorf("parsing notice template: %w", err)
+	}
+	receiptTmpl, err := template.ParseFS(templates.GetReceiptFS(), "receipt.html")
+	if err != nil {
+		return nil, fmt.Errorf("parsing receipt template: %w", err)
+	}
+	mailedTmpl, err := template.ParseFS(templates.GetMailedFS(), "mailed.html")
+	if err != nil {
+		return nil, fmt.Errorf("parsing mailed template: %w", err)
+	}
+	serviceTmpl, err := template.New("proof_of_service.html").
+		Funcs(template.FuncMap{"inc": func(i int) int { return i + 1 }}).
+		ParseFS(templates.GetProofOfServiceFS(), "proof_of_service.html")
+	if err != nil {
+		return nil, fmt.Errorf("parsing proof of service template: %w", err)
+	}
+	waiverTmpl, err := template.ParseFS(templates.GetWaiverFS(), "waiver.html")
+	if err != nil {
+		return nil, fmt.Errorf("parsing waiver template: %w", err)
+	}
+
+	return &Processor{
+		db:      db,
+		mailer:  mailerClient,
+		payment: paymentClient,
+		email:   emailClient,
+		alert:   alert,
+		notice:  noticeTmpl,
+		receipt: receiptTmpl,
+		mailed:  mailedTmpl,
+		service: serviceTmpl,
+		waiver:  waiverTmpl,
+	}, nil
+}
+
+// SetCancelLinks makes receipts carry a signed link for cancelling the order.
+func (p *Processor) SetCancelLinks(links *CancelLinks) {
+	p.links = links
+}
+
+// SetProofLinks makes receipts link to our archived copy of each letter's
+// PDF instead of Lob's, which expires.
+func (p *Processor) SetProofLinks(links *ProofLinks) {
+	p.proofs = links
+}
+
+func TrackingLink(trackingNumber string) string {
+	return fmt.Sprintf("https://tools.usps.com/go/TrackConfirmAction?tLabels=%s", trackingNumber)
+}
+
+// noticeView is what notice.html is executed with: the notice and the
+// rules of the job site's state.
+type noticeView struct {
+	mailer.NoticeData
+	Rules *states.Rules
+}
+
+// RenderNotice produces the HTML that is printed and mailed for an order.
+func (p *Processor) RenderNotice(data mailer.NoticeData) (string, error) {
+	rules, err := states.For(data.State)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := p.notice.Execute(&buf, noticeView{NoticeData: data, Rules: rules}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// waiverView is what waiver.html is executed with: the waiver and the
+// statutory form it fills in.
+type waiverView struct {
+	mailer.WaiverData
+	Rules *waivers.Form
+}
+
+// RenderWaiver produces the HTML that is printed and mailed for a lien
+// waiver order.
+func (p *Processor) RenderWaiver(data mailer.WaiverData) (string, error) {
+	form, ok := waivers.Lookup(data.Form)
+	if !ok {
+		return "", fmt.Errorf("unknown waiver form %q", data.Form)
+	}
+	var buf bytes.Buffer
+	if err := p.waiver.Execute(&buf, waiverView{WaiverData: data, Rules: form}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// render produces the HTML mailed for o: its waiver if it has one,
+// otherwise its notice.
+func (p *Processor) render(o *storage.Order) (string, error) {
+	if o.Waiver != nil {
+		return p.RenderWaiver(*o.Waiver)
+	}
+	return p.RenderNotice(o.Notice)
+}
+
+// Charge takes payment for a freshly created order using the card token saved
+// on it. The order's idempotency key makes a repeated call return the
+// original payment rather than charging again.
+func (p *Processor) Charge(ctx context.Context, o *storage.Order) error {
+	paymentID, err := p.payment.ChargeCard(ctx, o.SourceToken, o.AmountCents, o.UserEmail, o.IdempotencyKey)
+	if err != nil {
+		o.LastError = err.Error()
+		if !errors.Is(err, payment.ErrDeclined) {
+			// Square may have taken the money before the error, so the row
+			// stays in created with its token and the resumer replays the
+			// charge under the same idempotency key.
+			return err
+		}
+		o.SourceToken = ""
+		p.transition(ctx, o, storage.OrderCreated, storage.OrderFailed)
+		return err
+	}
+
+	// If this write fails the row stays in created with its token, and the
+	// resumer's replay of the charge lands on this same payment.
+	o.SourceToken = ""
+	o.PaymentID = paymentID
+	if err := p.db.TransitionOrder(ctx, o, storage.OrderCreated, storage.OrderCharged); err != nil {
+		log.Printf("ERROR: charged %s but could not record order #%d: %v", paymentID, o.ID, err)
+		return fmt.Errorf("recording charge failed: %w", err)
+	}
+	return nil
+}
+
+// SubmitLetters hands each of a charged order's unsent letters to Lob. A
+// letter Lob rejects has its share of the payment refunded while the others
+// still go out. If no letter could be mailed the first error is returned,
+// and o.Status tells the caller whether every refund went through.
+func (p *Processor) SubmitLetters(ctx context.Context, o *storage.Order) error {
+	if o.Status != storage.OrderCharged {
+		return fmt.Errorf("order #%d is %s, not %s", o.ID, o.Status, storage.OrderCharged)
+	}
+
+	var firstErr error
+	html, renderErr := p.render(o)
+	if renderErr != nil {
+		firstErr = fmt.Errorf("rendering document: %w", renderErr)
+	}
+	description := "Notice"
+	if o.Waiver != nil {
+		description = "Waiver"
+	}
+
+	for i := range o.Letters {
+		l := &o.Letters[i]
+		if l.Status != storage.LetterPending {
+			continue
+		}
+		if renderErr != nil {
+			p.refundLetter(ctx, o, i, firstErr)
+			continue
+		}
+
+		resp, err := p.mailer.SendLetter(ctx, mailer.LetterRequest{
+			Description:    fmt.Sprintf("%s - Ref: %s", description, o.PaymentID),
+			To:             l.ToAddress,
+			From:           o.FromAddress,
+			Color:          false,
+			File:           html,
+			ExtraService:   l.MailClass.ExtraService(),
+			SendDate:       sendDate(o, time.Now()),
+			IdempotencyKey: letterKey(o, l),
+		})
+		if err != nil {
+			log.Printf("Mailer error for order #%d (%s): %v", o.ID, l.Role, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			p.refundLetter(ctx, o, i, err)
+			continue
+		}
+
+		l.Status = storage.LetterSubmitted
+		l.LetterID = resp.ID
+		l.TrackingNumber = resp.TrackingNumber
+		l.PDFURL = resp.URL
+		l.ExpectedDelivery = resp.ExpectedDel
+		if sendDate, err := time.Parse(time.RFC3339, resp.SendDate); err == nil {
+			l.SendDate = &sendDate
+		}
+		l.LastError = ""
+		if err := p.db.UpdateLetter(ctx, l); err != nil {
+			log.Printf("CRITICAL: letter %s sent for order #%d but not saved: %v", resp.ID, o.ID, err)
+			p.alert("🚨 Letter not saved", fmt.Sprintf("Order #%d\nPayment: %s\nRecipient: %s\nLetter: %s\nError: %v", o.ID, o.PaymentID, l.Role, resp.ID, err))
+		}
+	}
+
+	return p.finishLetters(ctx, o, firstErr)
+}
+
+// finishLetters moves a charged order on once none of its letters is
+// pending any more.
+func (p *Processor) finishLetters(ctx context.Context, o *storage.Order, firstErr error) error {
+	var mailed, refundOwed bool
+	var mailedCents int64
+	for _, l := range o.Letters {
+		switch l.Status {
+		case storage.LetterSubmitted:
+			mailed = true
+			mailedCents += l.AmountCents
+		case storage.LetterFailed:
+			refundOwed = true
+		}
+	}
+
+	if !mailed {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("none of the letters for order #%d could be mailed", o.ID)
+		}
+		o.LastError = firstErr.Error()
+		if refundOwed {
+			p.transition(ctx, o, storage.OrderCharged, storage.OrderFailed)
+		} else {
+			p.transition(ctx, o, storage.OrderCharged, storage.OrderRefunded)
+		}
+		return firstErr
+	}
+
+	o.LastError = ""
+	if err := p.db.TransitionOrder(ctx, o, storage.OrderCharged, storage.OrderLetterSubmitted); err != nil {
+		log.Printf("CRITICAL: letters sent for order #%d but state not saved: %v", o.ID, err)
+		p.alert("🚨 Order state not saved", fmt.Sprintf("Order #%d\nPayment: %s\nError: %v", o.ID, o.PaymentID, err))
+		o.Status = storage.OrderLetterSubmitted
+	}
+
+	p.alert(fmt.Sprintf("💰 SALE: $%.2f", float64(mailedCents)/100), fmt.Sprintf("Customer: %s\nEmail: %s", o.Notice.SenderName, o.UserEmail))
+	return nil
+}
+
+// SendReceipt emails the customer and closes out a submitted order.
+func (p *Processor) SendReceipt(ctx context.Context, o *storage.Order) error {
+	if o.Status != storage.OrderLetterSubmitted {
+		return fmt.Errorf("order #%d is %s, not %s", o.ID, o.Status, storage.OrderLetterSubmitted)
+	}
+
+	if err := p.db.MarkPaid(ctx, o.UserEmail); err != nil {
+		log.Printf("ERROR: Failed to mark user %s as paid: %v", o.UserEmail, err)
+	}
+
+	data := p.ReceiptData(o)
+	subject := "Receipt: " + data.Document + " Sent"
+	if data.MailDate != "" {
+		subject = "Receipt: " + data.Document + " Scheduled for " + data.MailDate
+	}
+
+	var buf bytes.Buffer
+	if err := p.receipt.Execute(&buf, data); err != nil {
+		return fmt.Errorf("rendering receipt: %w", err)
+	}
+	if err := p.email.Send(o.UserEmail, subject, buf.String(), serviceAttachment(o)...); err != nil {
+		return fmt.Errorf("sending receipt to %s: %w", o.UserEmail, err)
+	}
+
+	return p.db.TransitionOrder(ctx, o, storage.OrderLetterSubmitted, storage.OrderReceiptSent)
+}
+
+func (p *Processor) ReceiptData(o *storage.Order) ReceiptData {
+	data := ReceiptData{
+		PaymentID:  o.PaymentID,
+		Name:       o.Notice.SenderName,
+		Date:       o.CreatedAt.Format("Jan 02, 2006"),
+		JobAddress: o.Notice.JobSiteAddress,
+		Total:      FormatCents(o.AmountCents),
+	}
+	data.Document = "Preliminary Notice"
+	if rules, err := states.For(o.Notice.State); err == nil {
+		data.NoticeTitle = rules.Title
+		data.Statute = rules.Statute
+	}
+	if o.Waiver != nil {
+		data.Document = "Lien Waiver"
+		data.Waiver = true
+		if form, ok := waivers.Lookup(o.Waiver.Form); ok {
+			data.NoticeTitle = form.Title
+			data.Statute = form.Statute
+		}
+	}
+
+	var refunded int64
+	data.Tracked = true
+	for _, l := range o.Letters {
+		rl := ReceiptLetter{
+			Recipient:      l.Role.Label(),
+			Name:           l.ToAddress.Name,
+			MailClass:      l.MailClass.Label(),
+			TrackingNumber: l.TrackingNumber,
+			TrackingLink:   TrackingLink(l.TrackingNumber),
+			PDFURL:         l.PDFURL,
+		}
+		if p.proofs != nil {
+			rl.PDFURL = p.proofs.URL(&l)
+		}
+		switch l.Status {
+		case storage.LetterSubmitted:
+			data.Letters = append(data.Letters, rl)
+			data.Tracked = data.Tracked && l.MailClass.Tracked()
+		case storage.LetterFailed, storage.LetterRefunded:
+			data.Refunded = append(data.Refunded, rl)
+			refunded += l.AmountCents
+		}
+	}
+	if refunded > 0 {
+		data.RefundedTotal = FormatCents(refunded)
+	}
+	if p.links != nil && Cancellable(o, time.Now()) {
+		data.CancelURL = p.links.URL(o)
+	}
+	_, data.ProofOfService = ProofOfService(o)
+	if o.MailedAt != nil {
+		data.MailedOn = o.MailedAt.Format("Jan 02, 2006")
+	} else if Scheduled(o) {
+		data.MailDate = o.SendDate.Format("Jan 02, 2006")
+	}
+	return data
+}
+
+// SendMailedConfirmation tells the customer that a scheduled order has
+// entered the mail stream.
+func (p *Processor) SendMailedConfirmation(o *storage.Order) error {
+	data := p.ReceiptData(o)
+	var buf bytes.Buffer
+	if err := p.mailed.Execute(&buf, data); err != nil {
+		return fmt.Errorf("rendering mailed confirmation: %w", err)
+	}
+	if err := p.email.Send(o.UserEmail, "Your "+data.Document+" Has Been Mailed", buf.String(), serviceAttachment(o)...); err != nil {
+		return fmt.Errorf("sending mailed confirmation to %s: %w", o.UserEmail, err)
+	}
+	return nil
+}
+
+// Scheduled reports whether o was booked to be mailed on a later day.
+func Scheduled(o *storage.Order) bool {
+	return o.SendDate != nil
+}
+
+// sendDate is the send date to give Lob for o's letters. A scheduled order
+// resumed after its day has passed is mailed right away instead.
+func sendDate(o *storage.Order, now time.Time) string {
+	if o.SendDate == nil || !o.SendDate.After(now) {
+		return ""
+	}
+	return o.SendDate.Format("2006-01-02")
+}
+
+// FormatCents renders an amount for customers, e.g. "$29.00".
+func FormatCents(cents int64) string {
+	return fmt.Sprintf("$%.2f", float64(cents)/100)
+}
+
+// Resume drives an order that was left mid-flight to a terminal state.
+func (p *Processor) Resume(ctx context.Context, o *storage.Order) error {
+	switch o.Status {
+	case storage.OrderCreated:
+		// Replaying the charge with the saved token and idempotency key either
+		// returns the payment Square already took or completes the one we
+		// never got to. Card tokens expire after a day, so older orders can
+		// only be failed and checked by hand.
+		if o.SourceToken != "" && time.Since(o.CreatedAt) < sourceTokenTTL {
+			if err := p.Charge(ctx, o); err != nil {
+				return err
+			}
+			return p.Resume(ctx, o)
+		}
+		o.LastError = "abandoned before the charge was confirmed"
+		o.SourceToken = ""
+		if err := p.db.TransitionOrder(ctx, o, storage.OrderCreated, storage.OrderFailed); err != nil {
+			return err
+		}
+		p.alert("⚠️ Order abandoned before charge", fmt.Sprintf("Order #%d for %s never recorded a payment. Check Square for a matching charge.", o.ID, o.UserEmail))
+		return nil
+	case storage.OrderCharged:
+		if err := p.SubmitLetters(ctx, o); err != nil {
+			return err
+		}
+		return p.SendReceipt(ctx, o)
+	case storage.OrderLetterSubmitted:
+		return p.SendReceipt(ctx, o)
+	default:
+		return nil
+	}
+}
+
+// refundLetter returns the share of the payment for the letter at
+// o.Letters[i], which could not be mailed because of cause.
+func (p *Processor) refundLetter(ctx context.Context, o *storage.Order, i int, cause error) {
+	l := &o.Letters[i]
+	key := refundKey(o, i)
+	l.LastError = cause.Error()
+	if err := p.payment.RefundPayment(ctx, o.PaymentID, l.AmountCents, key); err != nil {
+		log.Printf("CRITICAL: FAILED TO REFUND %s letter of %s: %v", l.Role, o.PaymentID, err)
+		l.Status = storage.LetterFailed
+		l.LastError = fmt.Sprintf("refund failed, queued for retry: %v", err)
+		p.queueRefund(ctx, o, l, key, err)
+		return
+	}
+
+	l.Status = storage.LetterRefunded
+	if err := p.db.UpdateLetter(ctx, l); err != nil {
+		log.Printf("ERROR: refunded %s letter of order #%d but could not record it: %v", l.Role, o.ID, err)
+	}
+}
+
+// letterKey is the Lob idempotency key for one letter. The owner's letter
+// keeps the order's own key, which is what orders placed before there were
+// several recipients used.
+func letterKey(o *storage.Order, l *storage.OrderLetter) string {
+	if o.IdempotencyKey == "" || l.Role == storage.RecipientOwner {
+		return o.IdempotencyKey
+	}
+	return o.IdempotencyKey + "-" + string(l.Role)
+}
+
+// refundKey is the Square idempotency key for refunding the letter at
+// o.Letters[i]. Square caps keys at 45 characters, so letters are told apart
+// by position rather than role.
+func refundKey(o *storage.Order, i int) string {
+	if o.IdempotencyKey == "" {
+		return ""
+	}
+	if i == 0 {
+		return o.IdempotencyKey + "-refund"
+	}
+	return fmt.Sprintf("%s-refund%d", o.IdempotencyKey, i+1)
+}
+
+// transition moves o from one state to another. If the row has moved on
+// since o was read, o takes the state it is in now; if the write failed, o
+// keeps the state that is still stored.
+func (p *Processor) transition(ctx context.Context, o *storage.Order, from, to storage.OrderStatus) {
+	err := p.db.TransitionOrder(ctx, o, from, to)
+	switch {
+	case err == nil:
+	case errors.Is(err, storage.ErrOrderStateChanged):
+		log.Printf("Order #%d moved on before %s -> %s", o.ID, from, to)
+		current, err := p.db.GetOrder(ctx, o.ID)
+		if err != nil || current == nil {
+			log.Printf("ERROR: Failed to reload order #%d: %v", o.ID, err)
+			return
+		}
+		o.Status = current.Status
+	default:
+		log.Printf("ERROR: Failed to move order #%d %s -> %s: %v", o.ID, from, to, err)
+	}
+}
@@ -0,0 +1,66 @@
+package orders
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"sendmynotice/internal/storage"
+)
+
+// ErrInvalidProofLink is returned for a proof link that was not signed by us.
+var ErrInvalidProofLink = errors.New("invalid proof link")
+
+// ProofLinks signs the links customers follow to download the archived PDF
+// of a letter. Unlike Lob's own links they outlive the letter's rendering:
+// customers keep them as evidence of service for as long as a lien may be
+// disputed, so they never expire.
+type ProofLinks struct {
+	BaseURL string
+	Key     []byte
+}
+
+// URL is the proof link for l.
+func (p *ProofLinks) URL(l *storage.OrderLetter) string {
+	return fmt.Sprintf("%s/orders/%d/letters/%d/proof.pdf?sig=%s", p.BaseURL, l.OrderID, l.ID, p.sign(l.OrderID, l.ID))
+}
+
+// Verify checks the sig parameter of a proof link for letter letterID of
+// order orderID.
+func (p *ProofLinks) Verify(orderID, letterID int, sig string) error {
+	return checkSig(sig, p.sign(orderID, letterID))
+}
+
+// ServiceURL is the link to the proof of service PDF for o.
+func (p *ProofLinks) ServiceURL(o *storage.Order) string {
+	return fmt.Sprintf("%s/orders/%d/proof-of-service.pdf?sig=%s", p.BaseURL, o.ID, p.signService(o.ID))
+}
+
+// VerifyService checks the sig parameter of a proof of service link for
+// order orderID.
+func (p *ProofLinks) VerifyService(orderID int, sig string) error {
+	return checkSig(sig, p.signService(orderID))
+}
+
+func checkSig(sig, want string) error {
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return ErrInvalidProofLink
+	}
+	return nil
+}
+
+func (p *ProofLinks) sign(orderID, letterID int) string {
+	return p.mac(fmt.Sprintf("proof:%d:%d", orderID, letterID))
+}
+
+func (p *ProofLinks) signService(orderID int) string {
+	return p.mac(fmt.Sprintf("service:%d", orderID))
+}
+
+func (p *ProofLinks) mac(msg string) string {
+	mac := hmac.New(sha256.New, p.Key)
+	mac.Write([]byte(msg))
+	return hex.EncodeToString(mac.Sum(nil))
+}
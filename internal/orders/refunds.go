@@ -0,0 +1,179 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"sendmynotice/internal/storage"
+)
+
+// MaxRefundAttempts is how many times a refund is tried before admins are
+// asked to step in. Retries continue after that at the maximum backoff.
+const MaxRefundAttempts = 5
+
+const (
+	refundBaseBackoff = 1 * time.Minute
+	refundMaxBackoff  = 6 * time.Hour
+)
+
+// refundBackoff returns the wait before the next try after `attempts`
+// failures: exponential from one minute, capped, with up to 20% jitter.
+func refundBackoff(attempts int) time.Duration {
+	d := refundBaseBackoff
+	for i := 1; i < attempts && d < refundMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > refundMaxBackoff {
+		d = refundMaxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// queueRefund saves a failed letter and queues its refund for retry in one
+// transaction, so no letter is ever failed without its refund on record.
+func (p *Processor) queueRefund(ctx context.Context, o *storage.Order, l *storage.OrderLetter, key string, refundErr error) {
+	rf := &storage.Refund{
+		OrderID:        o.ID,
+		OrderLetterID:  l.ID,
+		PaymentID:      o.PaymentID,
+		AmountCents:    l.AmountCents,
+		IdempotencyKey: key,
+		Attempts:       1,
+		LastError:      refundErr.Error(),
+	}
+	err := p.db.WithTx(ctx, func(tx storage.Store) error {
+		if err := tx.UpdateLetter(ctx, l); err != nil {
+			return err
+		}
+		return tx.EnqueueRefund(ctx, rf, refundBackoff(rf.Attempts))
+	})
+	if err != nil {
+		log.Printf("CRITICAL: could not queue refund for %s: %v", o.PaymentID, err)
+		p.alert("🚨 REFUND FAILED - MANUAL REFUND NEEDED", fmt.Sprintf("Order #%d\nPayment: %s\nAmount: $%.2f (%s letter)\nCustomer: %s\nEmail: %s\nRefund error: %v\nQueue error: %v",
+			o.ID, o.PaymentID, float64(l.AmountCents)/100, l.Role, o.Notice.SenderName, o.UserEmail, refundErr, err))
+		return
+	}
+	log.Printf("💸 Refund of %s letter for %s queued for retry", l.Role, o.PaymentID)
+}
+
+// RetryRefund makes one more attempt at a queued refund.
+func (p *Processor) RetryRefund(ctx context.Context, rf *storage.Refund) error {
+	err := p.payment.RefundPayment(ctx, rf.PaymentID, rf.AmountCents, rf.IdempotencyKey)
+	if err == nil {
+		return p.settleRefund(ctx, rf, fmt.Sprintf("Refunded automatically after %d attempts", rf.Attempts+1))
+	}
+
+	rf.Attempts++
+	rf.LastError = err.Error()
+	escalate := rf.Attempts >= MaxRefundAttempts && rf.Status != storage.RefundEscalated
+	if escalate {
+		rf.Status = storage.RefundEscalated
+	}
+	if dbErr := p.db.RecordRefundAttempt(ctx, rf, refundBackoff(rf.Attempts)); dbErr != nil {
+		return fmt.Errorf("recording refund attempt: %w", dbErr)
+	}
+
+	if escalate {
+		p.alert(fmt.Sprintf("🚨 REFUND FAILED %d TIMES - MANUAL REFUND NEEDED", rf.Attempts),
+			fmt.Sprintf("Order #%d\nPayment: %s\nAmount: $%.2f\nLast error: %v\n\nRefund it in the Square dashboard, then mark it resolved in /admin.",
+				rf.OrderID, rf.PaymentID, float64(rf.AmountCents)/100, err))
+	}
+	return err
+}
+
+// ResolveRefund closes a queued refund that an admin settled by hand.
+func (p *Processor) ResolveRefund(ctx context.Context, id int, resolution string) error {
+	rf, err := p.db.GetRefund(ctx, id)
+	if err != nil {
+		return err
+	}
+	if rf == nil {
+		return fmt.Errorf("refund %d not found", id)
+	}
+	return p.settleRefund(ctx, rf, resolution)
+}
+
+// RefundCompleted is called when Square reports that money went back for a
+// payment by any route, including a refund issued from the Square dashboard.
+// Once the payment's refunded total covers every letter that was not mailed,
+// their queued refunds are closed. It runs in tx, so the caller can settle
+// the queue in the same transaction that recorded Square's event.
+func RefundCompleted(ctx context.Context, tx storage.Store, paymentID string) error {
+	o, err := tx.GetOrderByPaymentID(ctx, paymentID)
+	if err != nil || o == nil {
+		return err
+	}
+
+	var unmailed int64
+	for _, l := range o.Letters {
+		if l.Status == storage.LetterFailed || l.Status == storage.LetterRefunded {
+			unmailed += l.AmountCents
+		}
+	}
+	if unmailed == 0 || o.RefundedCents < unmailed {
+		return nil
+	}
+
+	return tx.WithTx(ctx, func(tx storage.Store) error {
+		open, err := tx.GetOpenRefundsByPaymentID(ctx, paymentID)
+		if err != nil {
+			return err
+		}
+		for _, rf := range open {
+			if err := tx.ResolveRefund(ctx, rf.ID, "Refund confirmed by Square"); err != nil {
+				return fmt.Errorf("resolving refund %d: %w", rf.ID, err)
+			}
+		}
+		return markRefunded(ctx, tx, o, 0)
+	})
+}
+
+func (p *Processor) settleRefund(ctx context.Context, rf *storage.Refund, resolution string) error {
+	return p.db.WithTx(ctx, func(tx storage.Store) error {
+		if err := tx.ResolveRefund(ctx, rf.ID, resolution); err != nil {
+			return fmt.Errorf("resolving refund %d: %w", rf.ID, err)
+		}
+
+		o, err := tx.GetOrder(ctx, rf.OrderID)
+		if err != nil || o == nil {
+			return err
+		}
+		return markRefunded(ctx, tx, o, rf.OrderLetterID)
+	})
+}
+
+// markRefunded records that the failed letter letterID was refunded, or all
+// of o's failed letters when letterID is 0, and closes a failed order once
+// nothing more is owed on it.
+func markRefunded(ctx context.Context, tx storage.Store, o *storage.Order, letterID int) error {
+	owed := false
+	for i := range o.Letters {
+		l := &o.Letters[i]
+		if l.Status != storage.LetterFailed {
+			continue
+		}
+		if letterID != 0 && l.ID != letterID {
+			owed = true
+			continue
+		}
+		l.Status = storage.LetterRefunded
+		if err := tx.UpdateLetter(ctx, l); err != nil {
+			return fmt.Errorf("saving letter %d: %w", l.ID, err)
+		}
+	}
+	if owed || o.Status != storage.OrderFailed {
+		return nil
+	}
+
+	o.LastError = ""
+	err := tx.TransitionOrder(ctx, o, storage.OrderFailed, storage.OrderRefunded)
+	if errors.Is(err, storage.ErrOrderStateChanged) {
+		log.Printf("Order #%d not moved to refunded: it is %s", o.ID, o.Status)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,187 @@
+package orders
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"sendmynotice/internal/email"
+	"sendmynotice/internal/pdf"
+	"sendmynotice/internal/states"
+	"sendmynotice/internal/storage"
+)
+
+// ProofOfServiceData fills in proof_of_service.html: the claimant's
+// declaration that the notice was mailed to each recipient.
+type ProofOfServiceData struct {
+	PaymentID        string
+	DeclarantName    string
+	DeclarantAddress string
+	DeclarantRole    string
+	JobSiteAddress   string
+	ServedOn         string
+	Recipients       []ServiceRecipient
+	// Rules are those of the job site's state, for the statutes cited.
+	Rules *states.Rules
+}
+
+// ServiceRecipient is one person the notice was mailed to.
+type ServiceRecipient struct {
+	Recipient      string
+	Name           string
+	Address        string
+	MailClass      string
+	TrackingNumber string
+}
+
+// ProofOfService pre-fills the proof of service for the letters of o that
+// were mailed. It returns false if none were, while a scheduled order waits
+// for its send date, or for a lien waiver, which needs no proof of service.
+func ProofOfService(o *storage.Order) (ProofOfServiceData, bool) {
+	rules, err := states.For(o.Notice.State)
+	if err != nil || o.Waiver != nil || Scheduled(o) && o.MailedAt == nil {
+		return ProofOfServiceData{}, false
+	}
+	data := ProofOfServiceData{
+		Rules:            rules,
+		PaymentID:        o.PaymentID,
+		DeclarantName:    o.Notice.SenderName,
+		DeclarantAddress: o.Notice.SenderAddress,
+		DeclarantRole:    o.Notice.SenderRole,
+		JobSiteAddress:   o.Notice.JobSiteAddress,
+	}
+
+	served := o.CreatedAt
+	if o.MailedAt != nil {
+		served = *o.MailedAt
+	}
+	for _, l := range o.Letters {
+		if l.Status != storage.LetterSubmitted || l.CancelledAt != nil {
+			continue
+		}
+		a := l.ToAddress
+		address := a.AddressLine1
+		if a.AddressLine2 != "" {
+			address += ", " + a.AddressLine2
+		}
+		data.Recipients = append(data.Recipients, ServiceRecipient{
+			Recipient:      l.Role.Label(),
+			Name:           a.Name,
+			Address:        fmt.Sprintf("%s, %s, %s %s", address, a.AddressCity, a.AddressState, a.AddressZip),
+			MailClass:      l.MailClass.Label(),
+			TrackingNumber: l.TrackingNumber,
+		})
+		if o.MailedAt == nil && l.SendDate != nil {
+			served = *l.SendDate
+		}
+	}
+	data.ServedOn = served.Format("January 2, 2006")
+	return data, len(data.Recipients) > 0
+}
+
+// ProofOfServiceFilename is what the proof of service PDF is called when
+// downloaded or attached.
+const ProofOfServiceFilename = "proof-of-service.pdf"
+
+// RenderProofOfService produces the HTML proof of service shown on the order
+// page.
+func (p *Processor) RenderProofOfService(data ProofOfServiceData) (string, error) {
+	var buf bytes.Buffer
+	if err := p.service.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// serviceAttachment is the proof of service for o, ready to attach to an
+// email, or nil if nothing was mailed.
+func serviceAttachment(o *storage.Order) []email.Attachment {
+	data, ok := ProofOfService(o)
+	if !ok {
+		return nil
+	}
+	return []email.Attachment{{Filename: ProofOfServiceFilename, Content: ProofOfServicePDF(data)}}
+}
+
+// ProofOfServicePDF lays out proof_of_service.html as a letter-size PDF for
+// the claimant to sign.
+func ProofOfServicePDF(data ProofOfServiceData) []byte {
+	rules := data.Rules
+	doc := pdf.New("Proof of Service - " + rules.ServiceStatute)
+	p := doc.AddPage()
+	footer := "Proof of Service • Generated by SendMyNotice.com • Ref: " + data.PaymentID
+	right := marginX + contentWidth
+
+	y := formHeader(p, "PROOF OF SERVICE BY MAIL", "DECLARATION OF SERVICE · "+strings.ToUpper(rules.ServiceStatute))
+	body := pdf.Style{Size: 11, Leading: 14.3, Color: pdf.Black, Justify: true}
+	y += body.Size
+	y = p.Paragraph(marginX, y, contentWidth, body,
+		pdf.Span{Font: pdf.Times, Text: "I, "},
+		pdf.Span{Font: pdf.CourierBold, Text: data.DeclarantName},
+		pdf.Span{Font: pdf.Times, Text: ", declare that I am the claimant named in the " + rules.Title + " (" + rules.Statute + ") for the job site at "},
+		pdf.Span{Font: pdf.CourierBold, Text: data.JobSiteAddress},
+		pdf.Span{Font: pdf.Times, Text: ", and that my business address is "},
+		pdf.Span{Font: pdf.CourierBold, Text: data.DeclarantAddress},
+		pdf.Span{Font: pdf.Times, Text: "."},
+	)
+	y += body.Size
+	y = p.Paragraph(marginX, y, contentWidth, body,
+		pdf.Span{Font: pdf.Times, Text: "On "},
+		pdf.Span{Font: pdf.CourierBold, Text: data.ServedOn},
+		pdf.Span{Font: pdf.Times, Text: " I served the preliminary notice by causing a copy to be deposited in the United States mail, postage prepaid, through SendMyNotice.com, addressed to each person below by the method shown:"},
+	)
+	y += 20 - body.Leading + body.Size
+
+	label := pdf.Style{Size: 9, Leading: 11.7, Color: labelGray}
+	value := pdf.Style{Size: 10, Leading: 13, Color: pdf.Black}
+	for i, r := range data.Recipients {
+		method := r.MailClass
+		if r.TrackingNumber != "" {
+			method += " · Tracking No. " + r.TrackingNumber
+		}
+		valueSpan := pdf.Span{Font: pdf.CourierBold, Text: r.Name + "\n" + r.Address + "\n" + method}
+		h := label.Leading + float64(pdf.Lines(contentWidth, value.Size, valueSpan))*value.Leading + 4
+		if y+h > pdf.PageHeight-marginBottom-24 {
+			pageFooter(p, footer)
+			p = doc.AddPage()
+			y = marginTop
+		}
+		p.Text(marginX, y+label.Size, pdf.TimesBold, label.Size, labelGray, fmt.Sprintf("%d. %s", i+1, strings.ToUpper(r.Recipient)))
+		p.Paragraph(marginX, y+label.Leading+value.Size, contentWidth, value, valueSpan)
+		y += h
+		p.Line(marginX, y, right, y, 1, ruleGray, 1)
+		y += 12
+	}
+
+	// The declaration and signature block stay together.
+	if y+150 > pdf.PageHeight-marginBottom-24 {
+		pageFooter(p, footer)
+		p = doc.AddPage()
+		y = marginTop
+	}
+	y += body.Size
+	y = p.Paragraph(marginX, y, contentWidth, body, pdf.Span{Font: pdf.Times,
+		Text: "I declare under penalty of perjury under the laws of the State of " + rules.Name + " that the foregoing is true and correct."})
+	y += body.Size
+	y = p.Paragraph(marginX, y, contentWidth, body,
+		pdf.Span{Font: pdf.Times, Text: "Executed on "},
+		pdf.Span{Font: pdf.CourierBold, Text: data.ServedOn},
+		pdf.Span{Font: pdf.Times, Text: ", at ______________________________, " + rules.Name + "."},
+	)
+
+	// Two signature lines side by side, 30pt apart.
+	y += 40
+	half := (contentWidth - 30) / 2
+	p.Line(marginX, y, marginX+half, y, 1, pdf.Black, 0)
+	p.Line(right-half, y, right, y, 1, pdf.Black, 0)
+	p.Text(marginX, y+12, pdf.Times, 9, pdf.Black, "Signature")
+	p.Text(right-half, y+12, pdf.CourierBold, 10, pdf.Black, data.DeclarantName)
+	printed := "Printed name"
+	if data.DeclarantRole != "" {
+		printed += " · " + data.DeclarantRole
+	}
+	p.Text(right-half, y+24, pdf.Times, 9, pdf.Black, printed)
+
+	pageFooter(p, footer)
+	return doc.Bytes()
+}
@@ -0,0 +1,159 @@
+package orders
+
+import (
+	"fmt"
+	"strings"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/pdf"
+	"sendmynotice/internal/waivers"
+)
+
+// WaiverPDF lays out the same lien waiver and release as waiver.html as a
+// letter-size PDF, for customers who print it and have it signed.
+func WaiverPDF(data mailer.WaiverData) ([]byte, error) {
+	form, ok := waivers.Lookup(data.Form)
+	if !ok {
+		return nil, fmt.Errorf("unknown waiver form %q", data.Form)
+	}
+	doc := pdf.New(form.Title + " - " + form.Statute)
+	waiverForm(doc, data, form)
+	return doc.Bytes(), nil
+}
+
+func waiverForm(doc *pdf.Document, data mailer.WaiverData, form *waivers.Form) {
+	w := &waiverWriter{doc: doc, p: doc.AddPage(), footer: form.Statute + " • Generated by SendMyNotice.com"}
+	w.y = formHeader(w.p, strings.ToUpper(form.Title), strings.ToUpper(form.Statute))
+
+	// The notice box, 9pt bold justified with 10pt padding inside a 2pt
+	// border.
+	notice := pdf.Style{Size: 9, Leading: 11.7, Color: pdf.Black, Justify: true}
+	span := pdf.Span{Font: pdf.TimesBold, Text: form.Notice}
+	textWidth := contentWidth - 4 - 20
+	h := 2*10.0 + 2 + float64(pdf.Lines(textWidth, notice.Size, span))*notice.Leading
+	w.p.Rect(marginX+1, w.y+1, contentWidth-2, h-2, nil, 2, pdf.Black)
+	w.p.Paragraph(marginX+2+10, w.y+2+10+notice.Size, textWidth, notice, span)
+	w.y += h + 6
+
+	w.section("Identifying Information")
+	w.row(0, "Name of Claimant", data.ClaimantName)
+	w.row(0, "Name of Customer", data.CustomerName)
+	w.row(0, "Job Location", data.JobLocation)
+	w.row(0, "Owner", data.OwnerName)
+	if form.Progress() {
+		w.row(0, "Through Date", data.ThroughDate)
+	}
+
+	w.section(form.Kind() + " Waiver and Release")
+	w.text(0, form.Waiver)
+	if form.Conditional {
+		w.row(0, "Maker of Check", data.CheckMaker)
+		w.row(0, "Amount of Check", "$"+data.CheckAmount)
+		w.row(0, "Check Payable to", data.CheckPayableTo)
+	} else if form.Progress() {
+		w.row(0, "Amount of Payment", "$"+data.PaymentAmount)
+	}
+
+	w.section("Exceptions")
+	w.text(0, form.Exceptions)
+	for i, item := range form.ExceptionItems {
+		w.text(20, item)
+		if i == form.PriorItem {
+			w.need(48)
+			w.row(20, "Date(s) of Waiver", orDefault(data.PriorWaiverDates, "NONE"))
+			w.row(20, "Amount(s) Unpaid", "$"+orDefault(data.PriorUnpaidAmount, "0.00"))
+		}
+	}
+	if form.Disputed() {
+		w.row(0, "Disputed Extras", "$"+orDefault(data.DisputedAmount, "0.00"))
+	}
+
+	w.section("Signature")
+	w.signature(data)
+	pageFooter(w.p, w.footer)
+}
+
+// waiverWriter flows the waiver down the page, starting a new one when the
+// next block would run into the footer.
+type waiverWriter struct {
+	doc    *pdf.Document
+	p      *pdf.Page
+	y      float64
+	footer string
+}
+
+func (w *waiverWriter) need(h float64) {
+	if w.y+h > pdf.PageHeight-marginBottom-24 {
+		pageFooter(w.p, w.footer)
+		w.p = w.doc.AddPage()
+		w.y = marginTop
+	}
+}
+
+func (w *waiverWriter) section(title string) {
+	w.need(40)
+	w.y += 16 + 10
+	w.p.Text(marginX, w.y, pdf.TimesBold, 10, pdf.Black, strings.ToUpper(title))
+	w.y += 10
+}
+
+// text is a justified paragraph of the form's wording, indented by indent.
+func (w *waiverWriter) text(indent float64, s string) {
+	style := pdf.Style{Size: 10, Leading: 13, Color: pdf.Black, Justify: true}
+	span := pdf.Span{Font: pdf.Times, Text: s}
+	width := contentWidth - indent
+	w.need(float64(pdf.Lines(width, style.Size, span)) * style.Leading)
+	w.y = w.p.Paragraph(marginX+indent, w.y+style.Size, width, style, span) - style.Size + 6
+}
+
+// row is a label and its value with a dotted rule below, as in noticeForm,
+// indented by indent.
+func (w *waiverWriter) row(indent float64, label, value string) {
+	labelStyle := pdf.Style{Size: 9, Leading: 11.7, Color: labelGray}
+	valueStyle := pdf.Style{Size: 10, Leading: 13, Color: pdf.Black}
+	labelWidth := 120.0
+	valueWidth := contentWidth - indent - labelWidth
+	labelSpan := pdf.Span{Font: pdf.TimesBold, Text: strings.ToUpper(label)}
+	valueSpan := pdf.Span{Font: pdf.CourierBold, Text: value}
+	h := max(
+		float64(pdf.Lines(labelWidth-4, labelStyle.Size, labelSpan))*labelStyle.Leading,
+		float64(pdf.Lines(valueWidth, valueStyle.Size, valueSpan))*valueStyle.Leading,
+	) + 4
+	w.need(h)
+	w.y += 4
+	w.p.Paragraph(marginX+indent, w.y+labelStyle.Size, labelWidth-4, labelStyle, labelSpan)
+	w.p.Paragraph(marginX+indent+labelWidth, w.y+valueStyle.Size, valueWidth, valueStyle, valueSpan)
+	w.y += h
+	w.p.Line(marginX+indent, w.y, marginX+contentWidth, w.y, 1, ruleGray, 1)
+	w.y += 4
+}
+
+// signature draws the three signature lines: blank for the claimant to
+// sign, then the title and date that were filled in.
+func (w *waiverWriter) signature(data mailer.WaiverData) {
+	w.need(70)
+	gap := 30.0
+	width := (contentWidth - 2*gap) / 3
+	top := w.y + 36
+	for i, field := range []struct{ value, label string }{
+		{"", "Claimant's Signature"},
+		{data.ClaimantTitle, "Claimant's Title"},
+		{data.Date, "Date of Signature"},
+	} {
+		x := marginX + float64(i)*(width+gap)
+		if field.value != "" {
+			w.p.Text(x, top-6, pdf.CourierBold, 10, pdf.Black, field.value)
+		}
+		w.p.Line(x, top, x+width, top, 1, pdf.Black, 0)
+		w.p.Text(x, top+4+9, pdf.Times, 9, pdf.Black, field.label)
+	}
+	w.y = top + 20
+}
+
+// orDefault is s, or def if s is empty.
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -1,16 +1,31 @@
 package payment
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/square/square-go-sdk"
 	"github.com/square/square-go-sdk/client"
+	"github.com/square/square-go-sdk/core"
 	"github.com/square/square-go-sdk/option"
+
+	"sendmynotice/internal/transport"
 )
 
+// ErrDeclined is wrapped by ChargeCard's error when Square refused the
+// charge outright: the card was declined or the request was invalid. No
+// payment was taken, and replaying the charge would get the same answer.
+// Any other error leaves it unknown whether the card was charged.
+var ErrDeclined = errors.New("payment declined")
+
 type Client struct {
 	square *client.Client
 }
@@ -25,12 +40,46 @@ func NewClient(accessToken, env string) *Client {
 		square: client.NewClient(
 			option.WithToken(accessToken),
 			option.WithBaseURL(sqEnv),
+			// The transport does the retrying. The SDK's own retrier would
+			// also repeat POSTs that carry no idempotency key.
+			option.WithHTTPClient(transport.NewClient(transport.Config{
+				Name:          "square",
+				RatePerSecond: 10,
+				Burst:         20,
+				Idempotent:    idempotent,
+			})),
+			option.WithMaxAttempts(1),
 		),
 	}
 }
 
-func (c *Client) ChargeCard(ctx context.Context, sourceID string, amountCents int64, userEmail string) (string, error) {
-	idempotencyKey := uuid.New().String()
+// idempotent lets the transport retry reads, and writes whose body carries
+// Square's idempotency_key so a repeat returns the original payment or refund.
+func idempotent(req *http.Request) bool {
+	if transport.DefaultIdempotent(req) {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	b, err := io.ReadAll(body)
+	return err == nil && bytes.Contains(b, []byte(`"idempotency_key"`))
+}
+
+// ChargeCard charges the tokenized card. Square treats repeated calls with the
+// same idempotencyKey as one payment, so retries never double charge. The key
+// is also stored as the payment's reference ID for reconciliation.
+func (c *Client) ChargeCard(ctx context.Context, sourceID string, amountCents int64, userEmail, idempotencyKey string) (string, error) {
+	if idempotencyKey == "" {
+		idempotencyKey = uuid.New().String()
+	}
 
 	amount := &square.Money{
 		Amount:   &amountCents,
@@ -45,10 +94,14 @@ func (c *Client) ChargeCard(ctx context.Context, sourceID string, amountCents in
         AmountMoney:    amount,
         Note:           &noteTemplate,
         BuyerEmailAddress: &userEmail, 
+        ReferenceID:    &idempotencyKey,
     }
 
 	resp, err := c.square.Payments.Create(ctx, req)
 	if err != nil {
+		if declined(err) {
+			return "", fmt.Errorf("square payment failed: %w: %w", ErrDeclined, err)
+		}
 		return "", fmt.Errorf("square payment failed: %w", err)
 	}
 
@@ -62,9 +115,46 @@ func (c *Client) ChargeCard(ctx context.Context, sourceID string, amountCents in
 	return paymentID, nil
 }
 
-func (c *Client) RefundPayment(ctx context.Context, paymentID string, amountCents int64) error {
-    idempotencyKey := uuid.New().String()
-    
+// declined reports whether err is Square answering a payment with a card or
+// validation error. Timeouts, rate limits, conflicts and server errors are
+// not: the payment may still have gone through.
+func declined(err error) bool {
+	var apiErr *core.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode < 400 || apiErr.StatusCode >= 500 {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return false
+	}
+	if apiErr.Unwrap() == nil {
+		return false
+	}
+	var body struct {
+		Errors []struct {
+			Category string `json:"category"`
+			Code     string `json:"code"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal([]byte(apiErr.Unwrap().Error()), &body) != nil || len(body.Errors) == 0 {
+		return false
+	}
+	for _, e := range body.Errors {
+		switch {
+		case e.Category == string(square.ErrorCategoryPaymentMethodError):
+		case e.Category == string(square.ErrorCategoryInvalidRequestError) && e.Code != string(square.ErrorCodeIdempotencyKeyReused):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) RefundPayment(ctx context.Context, paymentID string, amountCents int64, idempotencyKey string) error {
+    if idempotencyKey == "" {
+        idempotencyKey = uuid.New().String()
+    }
+
     amountMoney := &square.Money{
         Amount:   &amountCents, 
         Currency: square.CurrencyUsd.Ptr(),
@@ -84,3 +174,62 @@ func (c *Client) RefundPayment(ctx context.Context, paymentID string, amountCent
     log.Printf("💸 Refunded Payment %s successfully", paymentID)
     return nil
 }
+
+// Summary is the part of a Square payment needed to reconcile it against
+// orders and letters.
+type Summary struct {
+	ID            string
+	Status        string
+	AmountCents   int64
+	RefundedCents int64
+	ReferenceID   string
+	CreatedAt     time.Time
+}
+
+// ListPayments returns every payment created in [begin, end).
+func (c *Client) ListPayments(ctx context.Context, begin, end time.Time) ([]Summary, error) {
+	beginStr := begin.UTC().Format(time.RFC3339)
+	endStr := end.UTC().Format(time.RFC3339)
+	limit := 100
+
+	page, err := c.square.Payments.List(ctx, &square.ListPaymentsRequest{
+		BeginTime: &beginStr,
+		EndTime:   &endStr,
+		Limit:     &limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing square payments failed: %w", err)
+	}
+
+	var payments []Summary
+	iter := page.Iterator()
+	for iter.Next(ctx) {
+		p := iter.Current()
+		s := Summary{
+			ID:          deref(p.ID),
+			Status:      deref(p.Status),
+			ReferenceID: deref(p.ReferenceID),
+		}
+		if p.AmountMoney != nil && p.AmountMoney.Amount != nil {
+			s.AmountCents = *p.AmountMoney.Amount
+		}
+		if p.RefundedMoney != nil && p.RefundedMoney.Amount != nil {
+			s.RefundedCents = *p.RefundedMoney.Amount
+		}
+		if t, err := time.Parse(time.RFC3339, deref(p.CreatedAt)); err == nil {
+			s.CreatedAt = t
+		}
+		payments = append(payments, s)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("listing square payments failed: %w", err)
+	}
+	return payments, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,116 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Square signs each delivery with the subscription's signature key over the
+// notification URL followed by the raw body. See
+// https://developer.squareup.com/docs/webhooks/step3validate
+const SignatureHeader = "X-Square-Hmacsha256-Signature"
+
+var ErrInvalidSignature = errors.New("invalid square webhook signature")
+
+type money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type WebhookPayment struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	ReferenceID   string `json:"reference_id"`
+	AmountMoney   money  `json:"amount_money"`
+	RefundedMoney money  `json:"refunded_money"`
+}
+
+type WebhookRefund struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	PaymentID   string `json:"payment_id"`
+	AmountMoney money  `json:"amount_money"`
+}
+
+type WebhookDispute struct {
+	ID              string `json:"id"`
+	State           string `json:"state"`
+	Reason          string `json:"reason"`
+	DueAt           string `json:"due_at"`
+	AmountMoney     money  `json:"amount_money"`
+	DisputedPayment struct {
+		PaymentID string `json:"payment_id"`
+	} `json:"disputed_payment"`
+}
+
+type WebhookEvent struct {
+	EventID   string `json:"event_id"`
+	Type      string `json:"type"`
+	CreatedAt string `json:"created_at"`
+	Data      struct {
+		Type   string `json:"type"`
+		ID     string `json:"id"`
+		Object struct {
+			Payment *WebhookPayment `json:"payment"`
+			Refund  *WebhookRefund  `json:"refund"`
+			Dispute *WebhookDispute `json:"dispute"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// PaymentID is the payment the event concerns, whatever its type.
+func (e *WebhookEvent) PaymentID() string {
+	obj := e.Data.Object
+	switch {
+	case obj.Payment != nil:
+		return obj.Payment.ID
+	case obj.Refund != nil:
+		return obj.Refund.PaymentID
+	case obj.Dispute != nil:
+		return obj.Dispute.DisputedPayment.PaymentID
+	}
+	return ""
+}
+
+func (e *WebhookEvent) OccurredAt() time.Time {
+	if t, err := time.Parse(time.RFC3339, e.CreatedAt); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// SignWebhook computes the signature Square would send for body delivered
+// to notificationURL.
+func SignWebhook(signatureKey, notificationURL string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signatureKey))
+	mac.Write([]byte(notificationURL))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a Square delivery's signature and decodes it.
+// notificationURL must be exactly the URL registered with Square.
+func VerifyWebhook(signatureKey, notificationURL, signature string, body []byte) (*WebhookEvent, error) {
+	if signatureKey == "" || notificationURL == "" || signature == "" {
+		return nil, ErrInvalidSignature
+	}
+
+	expected := SignWebhook(signatureKey, notificationURL, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("decoding webhook event: %w", err)
+	}
+	if event.EventID == "" {
+		return nil, errors.New("webhook event has no event_id")
+	}
+	return &event, nil
+}
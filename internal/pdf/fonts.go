@@ -0,0 +1,172 @@
+package pdf
+
+// Font is one of the standard PDF fonts every reader has built in.
+type Font int
+
+const (
+	Times Font = iota
+	TimesBold
+	Helvetica
+	HelveticaBold
+	Courier
+	CourierBold
+)
+
+type fontMetrics struct {
+	name string
+	// ascii holds the widths of characters 32 to 126 in 1/1000 em, from
+	// Adobe's AFM files.
+	ascii [95]int
+	// latin1 holds the widths of characters 160 to 255, which WinAnsi
+	// places at the same code points as Unicode.
+	latin1 [96]int
+	// extra holds the widths of the WinAnsi characters from 128 to 159 we
+	// use.
+	extra map[byte]int
+}
+
+var fonts = []fontMetrics{
+	Times: {
+		name: "Times-Roman",
+		ascii: [95]int{
+			250, 333, 408, 500, 500, 833, 778, 180, 333, 333, 500, 564, 250, 333, 250, 278,
+			500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 278, 278, 564, 564, 564, 444,
+			921, 722, 667, 667, 722, 611, 556, 722, 722, 333, 389, 722, 611, 889, 722, 722,
+			556, 722, 667, 556, 611, 722, 722, 944, 722, 722, 611, 333, 278, 333, 469, 500,
+			333, 444, 500, 444, 500, 444, 333, 500, 500, 278, 278, 500, 278, 778, 500, 500,
+			500, 500, 333, 389, 278, 500, 500, 722, 500, 500, 444, 480, 200, 480, 541,
+		},
+		latin1: [96]int{
+			250, 333, 500, 500, 500, 500, 200, 500, 333, 760, 276, 500, 564, 333, 760, 333,
+			400, 564, 300, 300, 333, 500, 453, 250, 333, 300, 310, 500, 750, 750, 750, 444,
+			722, 722, 722, 722, 722, 722, 889, 667, 611, 611, 611, 611, 333, 333, 333, 333,
+			722, 722, 722, 722, 722, 722, 722, 564, 722, 722, 722, 722, 722, 722, 556, 500,
+			444, 444, 444, 444, 444, 444, 667, 444, 444, 444, 444, 444, 278, 278, 278, 278,
+			500, 500, 500, 500, 500, 500, 500, 564, 500, 500, 500, 500, 500, 500, 500, 500,
+		},
+		extra: map[byte]int{0x91: 333, 0x92: 333, 0x93: 444, 0x94: 444, 0x95: 350, 0x96: 500, 0x97: 1000, 0x99: 980},
+	},
+	TimesBold: {
+		name: "Times-Bold",
+		ascii: [95]int{
+			250, 333, 555, 500, 500, 1000, 833, 278, 333, 333, 500, 570, 250, 333, 250, 278,
+			500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 333, 333, 570, 570, 570, 500,
+			930, 722, 667, 722, 722, 667, 611, 778, 778, 389, 500, 778, 667, 944, 722, 778,
+			611, 778, 722, 556, 667, 722, 722, 1000, 722, 722, 667, 333, 278, 333, 581, 500,
+			333, 500, 556, 444, 556, 444, 333, 500, 556, 278, 333, 556, 278, 833, 556, 500,
+			556, 556, 444, 389, 333, 556, 500, 722, 500, 500, 444, 394, 220, 394, 520,
+		},
+		latin1: [96]int{
+			250, 333, 500, 500, 500, 500, 220, 500, 333, 747, 300, 500, 570, 333, 747, 333,
+			400, 570, 300, 300, 333, 556, 540, 250, 333, 300, 330, 500, 750, 750, 750, 500,
+			722, 722, 722, 722, 722, 722, 1000, 722, 667, 667, 667, 667, 389, 389, 389, 389,
+			722, 722, 778, 778, 778, 778, 778, 570, 778, 722, 722, 722, 722, 722, 611, 556,
+			500, 500, 500, 500, 500, 500, 722, 444, 444, 444, 444, 444, 278, 278, 278, 278,
+			500, 556, 500, 500, 500, 500, 500, 570, 500, 556, 556, 556, 556, 500, 556, 500,
+		},
+		extra: map[byte]int{0x91: 333, 0x92: 333, 0x93: 500, 0x94: 500, 0x95: 350, 0x96: 500, 0x97: 1000, 0x99: 1000},
+	},
+	Helvetica: {
+		name: "Helvetica",
+		ascii: [95]int{
+			278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+			556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+			1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+			667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+			333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+			556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+		},
+		latin1: [96]int{
+			278, 333, 556, 556, 556, 556, 260, 556, 333, 737, 370, 556, 584, 333, 737, 333,
+			400, 584, 333, 333, 333, 556, 537, 278, 333, 333, 365, 556, 834, 834, 834, 611,
+			667, 667, 667, 667, 667, 667, 1000, 722, 667, 667, 667, 667, 278, 278, 278, 278,
+			722, 722, 778, 778, 778, 778, 778, 584, 778, 722, 722, 722, 722, 667, 667, 611,
+			556, 556, 556, 556, 556, 556, 889, 500, 556, 556, 556, 556, 278, 278, 278, 278,
+			556, 556, 556, 556, 556, 556, 556, 584, 611, 556, 556, 556, 556, 500, 556, 500,
+		},
+		extra: map[byte]int{0x91: 222, 0x92: 222, 0x93: 333, 0x94: 333, 0x95: 350, 0x96: 556, 0x97: 1000, 0x99: 1000},
+	},
+	HelveticaBold: {
+		name: "Helvetica-Bold",
+		ascii: [95]int{
+			278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+			556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+			975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+			667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+			333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+			611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+		},
+		latin1: [96]int{
+			278, 333, 556, 556, 556, 556, 280, 556, 333, 737, 370, 556, 584, 333, 737, 333,
+			400, 584, 333, 333, 333, 611, 556, 278, 333, 333, 365, 556, 834, 834, 834, 611,
+			722, 722, 722, 722, 722, 722, 1000, 722, 667, 667, 667, 667, 278, 278, 278, 278,
+			722, 722, 778, 778, 778, 778, 778, 584, 778, 722, 722, 722, 722, 667, 667, 611,
+			556, 556, 556, 556, 556, 556, 889, 556, 556, 556, 556, 556, 278, 278, 278, 278,
+			611, 611, 611, 611, 611, 611, 611, 584, 611, 611, 611, 611, 611, 556, 611, 556,
+		},
+		extra: map[byte]int{0x91: 278, 0x92: 278, 0x93: 500, 0x94: 500, 0x95: 350, 0x96: 556, 0x97: 1000, 0x99: 1000},
+	},
+	Courier:     {name: "Courier"},
+	CourierBold: {name: "Courier-Bold"},
+}
+
+func (f Font) resource() string {
+	return "F" + string(rune('1'+int(f)))
+}
+
+func (f Font) monospaced() bool {
+	return f == Courier || f == CourierBold
+}
+
+// Width is how wide s is when set in f at size points.
+func Width(f Font, size float64, s string) float64 {
+	m := fonts[f]
+	total := 0
+	for _, c := range encode(s) {
+		switch {
+		case f.monospaced():
+			total += 600
+		case c >= 32 && c <= 126:
+			total += m.ascii[c-32]
+		case c >= 0xA0:
+			total += m.latin1[c-0xA0]
+		default:
+			w, ok := m.extra[c]
+			if !ok {
+				w = m.ascii['?'-32]
+			}
+			total += w
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// winAnsi maps the punctuation we expect in notices that lies outside
+// Latin-1 to its WinAnsiEncoding byte.
+var winAnsi = map[rune]byte{
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'™': 0x99,
+}
+
+// encode converts s to WinAnsiEncoding, replacing what it cannot represent
+// with '?'. Tabs and line breaks become spaces, since Text sets one line.
+func encode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= 32 && r <= 126:
+			out = append(out, byte(r))
+		case r == '\t', r == '\r', r == '\n':
+			out = append(out, ' ')
+		case r >= 0xA0 && r <= 0xFF:
+			out = append(out, byte(r))
+		default:
+			if b, ok := winAnsi[r]; ok {
+				out = append(out, b)
+			} else {
+				out = append(out, '?')
+			}
+		}
+	}
+	return out
+}
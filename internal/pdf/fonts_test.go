@@ -0,0 +1,60 @@
+package pdf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []byte
+	}{
+		{name: "ASCII", in: "Lien 123", want: []byte("Lien 123")},
+		{name: "Latin-1", in: "José Muñoz ©", want: []byte("Jos\xe9 Mu\xf1oz \xa9")},
+		{name: "no-break space and ÿ", in: "\u00a0ÿ", want: []byte{0xa0, 0xff}},
+		{name: "quotes and dashes", in: "“Owner’s” – — • ™", want: []byte("\x93Owner\x92s\x94 \x96 \x97 \x95 \x99")},
+		{name: "tabs and line breaks", in: "a\tb\r\nc", want: []byte("a b  c")},
+		{name: "control characters", in: "a\x00\x7f\u0085b", want: []byte("a???b")},
+		{name: "outside WinAnsi", in: "€ 中 😀", want: []byte("? ? ?")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encode(tt.in); !bytes.Equal(got, tt.want) {
+				t.Errorf("encode(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWidth(t *testing.T) {
+	tests := []struct {
+		name string
+		font Font
+		s    string
+		want float64
+	}{
+		{name: "Times ASCII", font: Times, s: "Ag", want: 722 + 500},
+		{name: "Helvetica Bold ASCII", font: HelveticaBold, s: "W.", want: 944 + 278},
+		{name: "Times Latin-1", font: Times, s: "é©", want: 444 + 760},
+		{name: "Helvetica Bold Latin-1", font: HelveticaBold, s: "Æÿ", want: 1000 + 556},
+		{name: "no-break space", font: Times, s: "\u00a0", want: 250},
+		{name: "WinAnsi punctuation", font: HelveticaBold, s: "“—", want: 500 + 1000},
+		{name: "tab is a space", font: Times, s: "\t", want: 250},
+		{name: "outside WinAnsi is a question mark", font: Times, s: "中€", want: 2 * 444},
+		{name: "Courier is monospaced", font: Courier, s: "iW é中", want: 5 * 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Width(tt.font, 1000, tt.s); got != tt.want {
+				t.Errorf("Width(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+			if got := Width(tt.font, 10, tt.s); got != tt.want/100 {
+				t.Errorf("Width(%q) at 10pt = %v, want %v", tt.s, got, tt.want/100)
+			}
+		})
+	}
+}
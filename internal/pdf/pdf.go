@@ -0,0 +1,163 @@
+// Package pdf writes simple letter-size PDF documents: text in the standard
+// Times, Helvetica and Courier fonts, lines and filled boxes. It needs no
+// font files or external tools, which is enough for notices and forms.
+//
+// Coordinates are in points (1/72 in) measured from the top-left corner of
+// the page, the way the HTML templates are laid out; the writer converts
+// them to PDF's bottom-left origin.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Letter size in points.
+const (
+	PageWidth  = 612.0
+	PageHeight = 792.0
+)
+
+// Color is an RGB color with components from 0 to 1.
+type Color struct{ R, G, B float64 }
+
+var (
+	Black = Color{0, 0, 0}
+	White = Color{1, 1, 1}
+)
+
+// Gray is a shade of gray from 0 (black) to 1 (white).
+func Gray(g float64) Color { return Color{g, g, g} }
+
+// Hex parses a "#rrggbb" color. Malformed input gives black.
+func Hex(s string) Color {
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(strings.TrimPrefix(s, "#"), "%02x%02x%02x", &r, &g, &b); err != nil {
+		return Black
+	}
+	return Color{float64(r) / 255, float64(g) / 255, float64(b) / 255}
+}
+
+type Document struct {
+	Title string
+	pages []*Page
+}
+
+func New(title string) *Document {
+	return &Document{Title: title}
+}
+
+// Page is one letter-size page. Its drawing methods append to the page's
+// content stream.
+type Page struct {
+	content bytes.Buffer
+}
+
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws s with its baseline starting at (x, y).
+func (p *Page) Text(x, y float64, f Font, size float64, c Color, s string) {
+	fmt.Fprintf(&p.content, "BT %s rg /%s %s Tf %s %s Td (%s) Tj ET\n",
+		c.ops(), f.resource(), num(size), num(x), num(PageHeight-y), escape(encode(s)))
+}
+
+// Line strokes a straight line. A non-zero dash draws it dashed with dashes
+// and gaps of that length.
+func (p *Page) Line(x1, y1, x2, y2, width float64, c Color, dash float64) {
+	dashOp := "[] 0 d"
+	if dash > 0 {
+		dashOp = fmt.Sprintf("[%s] 0 d", num(dash))
+	}
+	fmt.Fprintf(&p.content, "q %s %s RG %s w %s m %s %s l S Q\n",
+		dashOp, c.ops(), num(width), num(x1)+" "+num(PageHeight-y1), num(x2), num(PageHeight-y2))
+}
+
+// Rect fills the box with top-left corner (x, y), if fill is non-nil, and
+// then strokes its outline if border is greater than zero.
+func (p *Page) Rect(x, y, w, h float64, fill *Color, border float64, stroke Color) {
+	rect := fmt.Sprintf("%s %s %s %s re", num(x), num(PageHeight-y-h), num(w), num(h))
+	if fill != nil {
+		fmt.Fprintf(&p.content, "q %s rg %s f Q\n", fill.ops(), rect)
+	}
+	if border > 0 {
+		fmt.Fprintf(&p.content, "q %s RG %s w %s S Q\n", stroke.ops(), num(border), rect)
+	}
+}
+
+// Bytes serialises the document.
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1 catalog, 2 page tree, 3 info, then one object per font, then a page
+	// and its content stream for every page.
+	fontBase := 4
+	pageBase := fontBase + len(fonts)
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageBase+2*i))
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (SendMyNotice) >>", escape(encode(d.Title))))
+
+	var fontRefs []string
+	for i, f := range fonts {
+		obj(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f.name))
+		fontRefs = append(fontRefs, fmt.Sprintf("/%s %d 0 R", Font(i).resource(), fontBase+i))
+	}
+	resources := fmt.Sprintf("<< /Font << %s >> >>", strings.Join(fontRefs, " "))
+
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources %s /Contents %d 0 R >>",
+			num(PageWidth), num(PageHeight), resources, pageBase+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+func (c Color) ops() string {
+	return num(c.R) + " " + num(c.G) + " " + num(c.B)
+}
+
+func num(f float64) string {
+	s := fmt.Sprintf("%.3f", f)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+// escape makes s safe inside a PDF literal string.
+func escape(s []byte) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
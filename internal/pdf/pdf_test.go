@@ -0,0 +1,69 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestXrefOffsets(t *testing.T) {
+	d := New("Notice (draft) — Café")
+	p := d.AddPage()
+	p.Text(72, 72, Times, 12, Black, "Preliminary Notice (20-day) for José Muñoz\\Sons")
+	p.Rect(72, 100, 200, 50, &White, 1, Black)
+	p = d.AddPage()
+	p.Line(72, 72, 540, 72, 0.5, Gray(0.5), 2)
+	out := d.Bytes()
+
+	i := bytes.LastIndex(out, []byte("startxref\n"))
+	if i < 0 {
+		t.Fatal("no startxref")
+	}
+	var xref int
+	if _, err := fmt.Sscanf(string(out[i+len("startxref\n"):]), "%d", &xref); err != nil {
+		t.Fatalf("reading startxref: %v", err)
+	}
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+
+	lines := strings.Split(string(out[xref:]), "\n")
+	var first, count int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &count); err != nil {
+		t.Fatalf("reading xref header %q: %v", lines[1], err)
+	}
+	// Catalog, pages, info, six fonts, and a page and its contents twice.
+	if want := 1 + 3 + len(fonts) + 2*2; count != want {
+		t.Errorf("xref has %d entries, want %d", count, want)
+	}
+	for n := 1; n < count; n++ {
+		entry := lines[2+n]
+		off, err := strconv.Atoi(entry[:10])
+		if err != nil {
+			t.Fatalf("entry %d %q: %v", n, entry, err)
+		}
+		if want := fmt.Sprintf("%d 0 obj\n", n); !bytes.HasPrefix(out[off:], []byte(want)) {
+			t.Errorf("entry %d points at %q, want %q", n, out[off:min(off+12, len(out))], want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "plain text", want: "plain text"},
+		{in: "(20-day)", want: `\(20-day\)`},
+		{in: `C:\notices`, want: `C:\\notices`},
+		{in: `)(\`, want: `\)\(\\`},
+		{in: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := escape([]byte(tt.in)); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package pdf
+
+import "strings"
+
+// Span is a run of text in one font within a paragraph.
+type Span struct {
+	Font      Font
+	Text      string
+	Underline bool
+}
+
+// Style describes how a paragraph is set.
+type Style struct {
+	Size    float64
+	Leading float64 // baseline to baseline; 0 means 1.2 × Size
+	Color   Color
+	Justify bool
+}
+
+type word struct {
+	span  *Span
+	text  string
+	width float64
+	// space is true if the word is preceded by a space.
+	space bool
+}
+
+// Paragraph wraps spans to width, drawing the first baseline at y, and
+// returns the y at which the next line would start.
+func (p *Page) Paragraph(x, y, width float64, st Style, spans ...Span) float64 {
+	leading := st.Leading
+	if leading == 0 {
+		leading = st.Size * 1.2
+	}
+	lines := wrap(width, st.Size, spans)
+	for _, tl := range lines {
+		line := tl.words
+		extra := 0.0
+		if st.Justify && !tl.last {
+			gaps, used := 0, 0.0
+			for j, w := range line {
+				used += w.width
+				if j > 0 && w.space {
+					gaps++
+					used += Width(w.span.Font, st.Size, " ")
+				}
+			}
+			if gaps > 0 {
+				extra = (width - used) / float64(gaps)
+			}
+		}
+		cx := x
+		for j, w := range line {
+			if j > 0 && w.space {
+				gapStart := cx
+				cx += Width(w.span.Font, st.Size, " ") + extra
+				if w.span.Underline && line[j-1].span.Underline {
+					p.Line(gapStart, y+st.Size*0.12, cx, y+st.Size*0.12, st.Size*0.05, st.Color, 0)
+				}
+			}
+			p.Text(cx, y, w.span.Font, st.Size, st.Color, w.text)
+			if w.span.Underline {
+				p.Line(cx, y+st.Size*0.12, cx+w.width, y+st.Size*0.12, st.Size*0.05, st.Color, 0)
+			}
+			cx += w.width
+		}
+		y += leading
+	}
+	return y
+}
+
+// Lines is how many lines Paragraph would take to set the same spans.
+func Lines(width, size float64, spans ...Span) int {
+	return len(wrap(width, size, spans))
+}
+
+type textLine struct {
+	words []word
+	// last is true for the final line of the text and for lines ended by a
+	// newline; they are never justified.
+	last bool
+}
+
+// wrap breaks spans into lines no wider than width. Words are split only on
+// spaces; a word wider than the line is given a line of its own. A newline in
+// a span forces a break.
+func wrap(width, size float64, spans []Span) []textLine {
+	var lines []textLine
+	var line []word
+	used := 0.0
+	pendingSpace := false
+	for i := range spans {
+		sp := &spans[i]
+		for k, segment := range strings.Split(sp.Text, "\n") {
+			if k > 0 {
+				lines = append(lines, textLine{words: line, last: true})
+				line, used, pendingSpace = nil, 0, false
+			}
+			if strings.HasPrefix(segment, " ") {
+				pendingSpace = true
+			}
+			fields := strings.Fields(segment)
+			for j, f := range fields {
+				w := word{span: sp, text: f, width: Width(sp.Font, size, f), space: pendingSpace || j > 0}
+				need := w.width
+				if len(line) > 0 && w.space {
+					need += Width(sp.Font, size, " ")
+				}
+				if len(line) > 0 && used+need > width {
+					lines = append(lines, textLine{words: line})
+					line, used = nil, 0
+					need = w.width
+				}
+				line = append(line, w)
+				used += need
+				pendingSpace = false
+			}
+			if len(fields) > 0 && strings.HasSuffix(segment, " ") {
+				pendingSpace = true
+			}
+		}
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		lines = append(lines, textLine{words: line, last: true})
+	}
+	return lines
+}
+
+// TextCenter draws s centered on x.
+func (p *Page) TextCenter(x, y float64, f Font, size float64, c Color, s string) {
+	p.Text(x-Width(f, size, s)/2, y, f, size, c, s)
+}
+
+// TextRight draws s so that it ends at x.
+func (p *Page) TextRight(x, y float64, f Font, size float64, c Color, s string) {
+	p.Text(x-Width(f, size, s), y, f, size, c, s)
+}
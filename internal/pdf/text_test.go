@@ -0,0 +1,62 @@
+package pdf
+
+import (
+	"reflect"
+	"testing"
+)
+
+// render gives each wrapped line as its text, with "|" marking a line that
+// is not justified.
+func render(lines []textLine) []string {
+	var out []string
+	for _, l := range lines {
+		s := ""
+		for j, w := range l.words {
+			if j > 0 && w.space {
+				s += " "
+			}
+			s += w.text
+		}
+		if l.last {
+			s += "|"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestWrap(t *testing.T) {
+	// Courier at 10pt is 6pt a character, so a 30pt line holds five.
+	const width, size = 30, 10
+	span := func(s string) Span { return Span{Font: Courier, Text: s} }
+
+	tests := []struct {
+		name  string
+		spans []Span
+		want  []string
+	}{
+		{name: "fits", spans: []Span{span("aa bb")}, want: []string{"aa bb|"}},
+		{name: "wraps on spaces", spans: []Span{span("aa bb cc dd e")}, want: []string{"aa bb", "cc dd", "e|"}},
+		{name: "newline forces a break", spans: []Span{span("a\nb")}, want: []string{"a|", "b|"}},
+		{name: "blank line", spans: []Span{span("a\n\nb")}, want: []string{"a|", "|", "b|"}},
+		{name: "trailing newline adds no line", spans: []Span{span("a\n")}, want: []string{"a|"}},
+		{name: "over-long word gets its own line", spans: []Span{span("a toolongword b")}, want: []string{"a", "toolongword", "b|"}},
+		{name: "over-long first word", spans: []Span{span("toolongword")}, want: []string{"toolongword|"}},
+		{name: "spans join without a space", spans: []Span{span("ab"), span("cd")}, want: []string{"abcd|"}},
+		{name: "space between spans", spans: []Span{span("ab "), span("cd")}, want: []string{"ab cd|"}},
+		{name: "newline across spans", spans: []Span{span("ab\n"), span("cd")}, want: []string{"ab|", "cd|"}},
+		{name: "empty", spans: []Span{span("")}, want: []string{"|"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render(wrap(width, size, tt.spans))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrap = %q, want %q", got, tt.want)
+			}
+			if n := Lines(width, size, tt.spans...); n != len(tt.want) {
+				t.Errorf("Lines = %d, want %d", n, len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Package states is the registry of what each state we serve requires of a
+// preliminary notice: its statutory wording, who must be served, how it is
+// mailed and by when.
+package states
+
+import (
+	"fmt"
+	"strings"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/storage"
+)
+
+// Paragraph is one paragraph of statutory text. Lead, if set, is printed in
+// bold at its start.
+type Paragraph struct {
+	Lead string
+	Text string
+}
+
+// Deadline is when the notice must be served, counted from when labor or
+// materials were first furnished. States count either a number of days or
+// up to a day of a later month.
+type Deadline struct {
+	Days int
+	// Months and DayOfMonth give a deadline of the DayOfMonth-th day of the
+	// Months-th month after the month the work was done.
+	Months     int
+	DayOfMonth int
+}
+
+// Rules are one state's requirements.
+type Rules struct {
+	Code string
+	Name string
+
+	// Title names the notice on the cover letter and the form; Subtitle is
+	// printed under it and WarningTitle heads the statutory text.
+	Title        string
+	Subtitle     string
+	Statute      string
+	FormName     string
+	CoverLetter  string
+	WarningTitle string
+	Warning      []Paragraph
+
+	// ServiceStatute is cited on the proof of service.
+	ServiceStatute string
+
+	Deadline Deadline
+
+	// Recipients are who the notice is served on. The owner always is;
+	// the others are served when the customer knows their address.
+	Recipients []storage.Recipient
+
+	// MailClass is how the statute expects the notice to be mailed, used
+	// when the customer does not choose.
+	MailClass mailer.MailClass
+}
+
+// Serves reports whether the notice goes to recipient role in this state.
+func (r *Rules) Serves(role storage.Recipient) bool {
+	for _, served := range r.Recipients {
+		if served == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Article is "a" or "an", whichever goes before Title.
+func (r *Rules) Article() string {
+	if strings.ContainsRune("AEIOU", rune(r.Title[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+var California = &Rules{
+	Code:         "CA",
+	Name:         "California",
+	Title:        "California Preliminary Notice",
+	Subtitle:     "NOTICE TO PROPERTY OWNER",
+	Statute:      "Civil Code § 8200",
+	FormName:     "Statutory Form 8200",
+	CoverLetter:  "This is a standard document sent to all property owners for projects where our contribution exceeds $500, pursuant to Civil Code § 8200.",
+	WarningTitle: "NOTICE TO PROPERTY OWNER",
+	Warning: []Paragraph{
+		{
+			Lead: "EVEN THOUGH YOU HAVE PAID YOUR CONTRACTOR IN FULL",
+			Text: ", if the person or firm that has given you this notice is not paid in full for labor, service, equipment, or material provided or to be provided to your construction project, a lien may be placed on your property. Foreclosure of the lien may lead to loss of all or part of your property. You may wish to protect yourself against this consequence by (1) requiring your contractor to furnish a signed release by the person or firm that has given you this notice before making payment to your contractor or (2) any other method for protection that is appropriate under the circumstances.",
+		},
+		{Text: "This notice is required by law to be served by the undersigned as a statement of your legal rights. This notice is not intended to reflect upon the financial condition of the contractor or the person employed by you on the construction project."},
+	},
+	ServiceStatute: "Civil Code § 8118",
+	Deadline:       Deadline{Days: 20},
+	Recipients:     []storage.Recipient{storage.RecipientOwner, storage.RecipientDirectContractor, storage.RecipientLender},
+	MailClass:      mailer.Certified,
+}
+
+var Arizona = &Rules{
+	Code:         "AZ",
+	Name:         "Arizona",
+	Title:        "Arizona Twenty-Day Preliminary Notice",
+	Subtitle:     "NOTICE TO PROPERTY OWNER",
+	Statute:      "A.R.S. § 33-992.01",
+	FormName:     "A.R.S. 33-992.01",
+	CoverLetter:  "This is a standard document sent to all property owners for projects where we furnish labor or materials, pursuant to A.R.S. § 33-992.01.",
+	WarningTitle: "IN ACCORDANCE WITH ARIZONA REVISED STATUTES SECTION 33-992.01, THIS IS NOT A LIEN. THIS IS NOT A REFLECTION ON THE INTEGRITY OF ANY CONTRACTOR OR SUBCONTRACTOR.",
+	Warning: []Paragraph{
+		{
+			Lead: "NOTICE TO PROPERTY OWNER",
+			Text: ": If bills are not paid in full for the labor, materials, machinery, fixtures or tools furnished or to be furnished, a mechanic's lien leading to the loss, through court foreclosure proceedings, of all or part of your property being so improved may be placed against the property. You may wish to protect yourself against this consequence by either: (1) requiring your contractor to furnish a conditional waiver and release signed by the person or firm giving you this notice before you make payment to your contractor, or (2) using any other method or device that is appropriate under the circumstances.",
+		},
+		{Text: "Within ten days of receipt of this notice, the owner or other interested party is required to furnish all information necessary to correct any inaccuracies in the notice pursuant to section 33-992.01, subsection I, or lose as a defense any inaccuracy of that information."},
+	},
+	ServiceStatute: "A.R.S. § 33-992.02",
+	Deadline:       Deadline{Days: 20},
+	Recipients:     []storage.Recipient{storage.RecipientOwner, storage.RecipientDirectContractor, storage.RecipientLender},
+	MailClass:      mailer.Certified,
+}
+
+var Nevada = &Rules{
+	Code:         "NV",
+	Name:         "Nevada",
+	Title:        "Nevada Notice of Right to Lien",
+	Subtitle:     "NOTICE TO OWNER",
+	Statute:      "NRS 108.245",
+	FormName:     "NRS 108.245",
+	CoverLetter:  "This is a standard document sent to all property owners for projects where we furnish labor or materials, pursuant to NRS 108.245.",
+	WarningTitle: "NOTICE OF RIGHT TO LIEN",
+	Warning: []Paragraph{
+		{Text: "The undersigned notifies you that it has supplied materials or equipment or performed work or services for the improvement of the property identified below, and that the undersigned claims a right to lien for the value of the materials or equipment supplied or the work or services performed, or to be supplied or performed, pursuant to NRS 108.221 to 108.246, inclusive."},
+		{
+			Lead: "This is not a notice that the undersigned has not been or does not expect to be paid",
+			Text: ", but a notice required by law that the undersigned may, at a future date, record a notice of lien as provided by law against the property if the undersigned is not paid.",
+		},
+	},
+	ServiceStatute: "NRS 108.245",
+	Deadline:       Deadline{Days: 31},
+	Recipients:     []storage.Recipient{storage.RecipientOwner, storage.RecipientDirectContractor},
+	MailClass:      mailer.Certified,
+}
+
+var Texas = &Rules{
+	Code:         "TX",
+	Name:         "Texas",
+	Title:        "Texas Notice of Claim for Unpaid Labor or Materials",
+	Subtitle:     "NOTICE TO OWNER AND ORIGINAL CONTRACTOR",
+	Statute:      "Texas Property Code § 53.056",
+	FormName:     "Tex. Prop. Code 53.056",
+	CoverLetter:  "This is a standard document sent to property owners and original contractors for projects where we furnish labor or materials, pursuant to Texas Property Code § 53.056.",
+	WarningTitle: "NOTICE OF CLAIM FOR NONPAYMENT",
+	Warning: []Paragraph{
+		{
+			Lead: "If a subcontractor or supplier who furnishes materials or performs labor for construction of improvements on your property is not paid, your property may be subject to a lien",
+			Text: " for the unpaid amount if: (1) after receiving notice of the unpaid claim from the claimant, the property owner fails to withhold payment to the contractor that is sufficient to cover the unpaid claim until the dispute is resolved; or (2) during construction and for 30 days after completion of construction, the property owner fails to retain 10 percent of the contract price or 10 percent of the value of the work performed by the contractor.",
+		},
+		{Text: "If you have complied with the law regarding the 10 percent retainage and you have withheld payment to the contractor sufficient to cover any written notice of claim and have paid that amount, if any, to the claimant, any lien claim filed on your property by a subcontractor or supplier, other than a person who contracted directly with you, will not be a valid lien on your property. In addition, except for the required 10 percent retainage, you are not liable to a subcontractor or supplier for any amount paid to your contractor before you received written notice of the claim."},
+	},
+	ServiceStatute: "Texas Property Code § 53.003",
+	Deadline:       Deadline{Months: 3, DayOfMonth: 15}, // not § 53.252's second month, which is for residential work
+	Recipients:     []storage.Recipient{storage.RecipientOwner, storage.RecipientDirectContractor},
+	MailClass:      mailer.Certified,
+}
+
+// All lists every state we prepare notices for, in the order customers are
+// offered them.
+var All = []*Rules{California, Arizona, Nevada, Texas}
+
+// Lookup finds the rules for a two-letter state code.
+func Lookup(code string) (*Rules, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, r := range All {
+		if r.Code == code {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// For is Lookup for a notice's State. Notices from before we served other
+// states have none and are Californian.
+func For(code string) (*Rules, error) {
+	if code == "" {
+		return California, nil
+	}
+	r, ok := Lookup(code)
+	if !ok {
+		return nil, fmt.Errorf("we do not prepare notices for job sites in %q", code)
+	}
+	return r, nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+const (
+	EventSourceLob    = "lob"
+	EventSourceSquare = "square"
+)
+
+// OrderEvent is a provider notification about something that happened to an
+// order after checkout, kept verbatim as evidence.
+type OrderEvent struct {
+	ID         int
+	ReceivedAt time.Time
+	Source     string
+	EventID    string
+	EventType  string
+	OrderID    int
+	ResourceID string
+	OccurredAt time.Time
+	Payload    []byte
+}
+
+// RecordOrderEvent stores e unless an event with the same source and ID was
+// already stored, in which case it reports false.
+func (d *DB) RecordOrderEvent(ctx context.Context, e *OrderEvent) (bool, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var orderID sql.NullInt64
+	if e.OrderID != 0 {
+		orderID = sql.NullInt64{Int64: int64(e.OrderID), Valid: true}
+	}
+
+	err := d.sql.QueryRowContext(ctx, `
+		INSERT INTO order_events (source, event_id, event_type, order_id, resource_id, occurred_at, payload)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (source, event_id) DO NOTHING
+		RETURNING id, received_at`,
+		e.Source, e.EventID, e.EventType, orderID, e.ResourceID, e.OccurredAt.UTC(), e.Payload,
+	).Scan(&e.ID, &e.ReceivedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *DB) GetOrderEvents(ctx context.Context, orderID int) ([]OrderEvent, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.sql.QueryContext(ctx, `
+		SELECT id, received_at, source, event_id, event_type, COALESCE(order_id, 0), resource_id, occurred_at, payload
+		FROM order_events
+		WHERE order_id = $1
+		ORDER BY occurred_at ASC`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var events []OrderEvent
+	for rows.Next() {
+		var e OrderEvent
+		if err := rows.Scan(&e.ID, &e.ReceivedAt, &e.Source, &e.EventID, &e.EventType, &e.OrderID,
+			&e.ResourceID, &e.OccurredAt, &e.Payload); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
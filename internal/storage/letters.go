@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"sendmynotice/internal/mailer"
+)
+
+// Recipient is who a letter in an order is addressed to. A preliminary
+// notice has to reach the owner, the direct contractor and the construction
+// lender, so an order can hold one letter for each. A lien waiver goes to
+// the customer who paid for the work.
+type Recipient string
+
+const (
+	RecipientOwner            Recipient = "owner"
+	RecipientDirectContractor Recipient = "direct_contractor"
+	RecipientLender           Recipient = "construction_lender"
+	RecipientCustomer         Recipient = "customer"
+)
+
+// Label is how the recipient is described to customers.
+func (r Recipient) Label() string {
+	switch r {
+	case RecipientOwner:
+		return "Property Owner"
+	case RecipientDirectContractor:
+		return "Direct Contractor"
+	case RecipientLender:
+		return "Construction Lender"
+	case RecipientCustomer:
+		return "Customer"
+	}
+	return string(r)
+}
+
+type LetterStatus string
+
+const (
+	LetterPending   LetterStatus = "pending"
+	LetterSubmitted LetterStatus = "submitted"
+	// LetterFailed letters were rejected by Lob and their share of the
+	// payment has not been returned yet.
+	LetterFailed   LetterStatus = "failed"
+	LetterRefunded LetterStatus = "refunded"
+)
+
+// OrderLetter is one letter in an order, with its own tracking number and
+// its own share of the price.
+type OrderLetter struct {
+	ID               int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	OrderID          int
+	Role             Recipient
+	ToAddress        mailer.Address
+	MailClass        mailer.MailClass
+	AmountCents      int64
+	Status           LetterStatus
+	LastError        string
+	LetterID         string
+	TrackingNumber   string
+	PDFURL           string
+	ExpectedDelivery string
+	// SendDate is when Lob sends the letter to print; until then it can be
+	// cancelled. CancelledAt is set once it has been.
+	SendDate    *time.Time
+	CancelledAt *time.Time
+
+	// TrackingStatus is the latest letter event reported by Lob, e.g.
+	// "in_transit" or "delivered".
+	TrackingStatus    string
+	TrackingUpdatedAt *time.Time
+
+	// ArchiveKey is where the letter's PDF is kept in blob storage, once
+	// ArchivedAt is set. ArchiveAttemptedAt is the last time fetching it
+	// from Lob failed.
+	ArchiveKey         string
+	ArchivedAt         *time.Time
+	ArchiveAttemptedAt *time.Time
+}
+
+const letterColumns = `id, created_at, updated_at, order_id, role, to_address, mail_class, amount_cents, status, last_error,
+	letter_id, tracking_number, pdf_url, expected_delivery, send_date, cancelled_at, tracking_status, tracking_updated_at,
+	archive_key, archived_at, archive_attempted_at`
+
+func (d *DB) createLetter(ctx context.Context, l *OrderLetter) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	to, err := json.Marshal(l.ToAddress)
+	if err != nil {
+		return fmt.Errorf("marshalling to address failed: %w", err)
+	}
+	if l.Status == "" {
+		l.Status = LetterPending
+	}
+	if l.MailClass == "" {
+		l.MailClass = mailer.Certified
+	}
+
+	return d.sql.QueryRowContext(ctx, `
+		INSERT INTO order_letters (order_id, role, to_address, mail_class, amount_cents, status, last_error,
+			letter_id, tracking_number, pdf_url, expected_delivery)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, updated_at`,
+		l.OrderID, l.Role, to, l.MailClass, l.AmountCents, l.Status, l.LastError,
+		l.LetterID, l.TrackingNumber, l.PDFURL, l.ExpectedDelivery,
+	).Scan(&l.ID, &l.CreatedAt, &l.UpdatedAt)
+}
+
+// UpdateLetter saves what happened when a letter was submitted, cancelled or
+// refunded.
+func (d *DB) UpdateLetter(ctx context.Context, l *OrderLetter) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.sql.ExecContext(ctx, `
+		UPDATE order_letters
+		SET status = $1, last_error = $2, letter_id = $3, tracking_number = $4, pdf_url = $5,
+			expected_delivery = $6, send_date = $7, cancelled_at = $8, updated_at = NOW()
+		WHERE id = $9`,
+		l.Status, l.LastError, l.LetterID, l.TrackingNumber, l.PDFURL, l.ExpectedDelivery,
+		utcOrNil(l.SendDate), utcOrNil(l.CancelledAt), l.ID)
+	return err
+}
+
+// UpdateTrackingStatus records the latest carrier status for a letter.
+// Events can arrive out of order, so an older event never overwrites a newer one.
+func (d *DB) UpdateTrackingStatus(ctx context.Context, letterID int, status string, at time.Time) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.sql.ExecContext(ctx, `
+		UPDATE order_letters SET tracking_status = $1, tracking_updated_at = $2
+		WHERE id = $3 AND (tracking_updated_at IS NULL OR tracking_updated_at <= $2)`,
+		status, at.UTC(), letterID)
+	return err
+}
+
+// GetLettersToArchive returns up to limit mailed letters whose PDF has not
+// been archived yet, skipping any whose last attempt was after retryBefore.
+// Cancelled letters are never printed, so there is nothing to keep.
+func (d *DB) GetLettersToArchive(ctx context.Context, retryBefore time.Time, limit int) ([]OrderLetter, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.sql.QueryContext(ctx, `SELECT `+letterColumns+` FROM order_letters
+		WHERE archived_at IS NULL AND letter_id <> '' AND cancelled_at IS NULL
+			AND (archive_attempted_at IS NULL OR archive_attempted_at < $1)
+		ORDER BY id ASC LIMIT $2`, retryBefore.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var letters []OrderLetter
+	for rows.Next() {
+		l, err := scanLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, *l)
+	}
+	return letters, rows.Err()
+}
+
+// MarkLetterArchived records that a letter's PDF is stored under key.
+func (d *DB) MarkLetterArchived(ctx context.Context, letterID int, key string, at time.Time) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.sql.ExecContext(ctx, `
+		UPDATE order_letters SET archive_key = $1, archived_at = $2 WHERE id = $3`,
+		key, at.UTC(), letterID)
+	return err
+}
+
+// RecordArchiveAttempt notes a failed attempt to archive a letter's PDF so
+// it is not retried straight away.
+func (d *DB) RecordArchiveAttempt(ctx context.Context, letterID int, at time.Time) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.sql.ExecContext(ctx, `
+		UPDATE order_letters SET archive_attempted_at = $1 WHERE id = $2`,
+		at.UTC(), letterID)
+	return err
+}
+
+// loadLetters fills in Letters on each of orders.
+func (d *DB) loadLetters(ctx context.Context, orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	byID := make(map[int]*Order, len(orders))
+	ids := make([]int64, 0, len(orders))
+	for i := range orders {
+		byID[orders[i].ID] = &orders[i]
+		ids = append(ids, int64(orders[i].ID))
+	}
+
+	rows, err := d.sql.QueryContext(ctx, `SELECT `+letterColumns+` FROM order_letters WHERE order_id = ANY($1) ORDER BY id ASC`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		l, err := scanLetter(rows)
+		if err != nil {
+			return err
+		}
+		if o := byID[l.OrderID]; o != nil {
+			o.Letters = append(o.Letters, *l)
+		}
+	}
+	return rows.Err()
+}
+
+func scanLetter(rows *sql.Rows) (*OrderLetter, error) {
+	var l OrderLetter
+	var to []byte
+	var sendDate, cancelledAt, trackingUpdatedAt, archivedAt, archiveAttemptedAt sql.NullTime
+	if err := rows.Scan(&l.ID, &l.CreatedAt, &l.UpdatedAt, &l.OrderID, &l.Role, &to, &l.MailClass, &l.AmountCents, &l.Status, &l.LastError,
+		&l.LetterID, &l.TrackingNumber, &l.PDFURL, &l.ExpectedDelivery, &sendDate, &cancelledAt, &l.TrackingStatus, &trackingUpdatedAt,
+		&l.ArchiveKey, &archivedAt, &archiveAttemptedAt); err != nil {
+		return nil, err
+	}
+	l.SendDate = timeOrNil(sendDate)
+	l.CancelledAt = timeOrNil(cancelledAt)
+	l.TrackingUpdatedAt = timeOrNil(trackingUpdatedAt)
+	l.ArchivedAt = timeOrNil(archivedAt)
+	l.ArchiveAttemptedAt = timeOrNil(archiveAttemptedAt)
+	if err := json.Unmarshal(to, &l.ToAddress); err != nil {
+		return nil, fmt.Errorf("decoding to address for letter %d: %w", l.ID, err)
+	}
+	return &l, nil
+}
+
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func utcOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
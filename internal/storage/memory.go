@@ -0,0 +1,679 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"sendmynotice/internal/mailer"
+)
+
+// Memory is an in-process Store with the same semantics as the Postgres one:
+// the same uniqueness rules, ordering, limits and guards. Everything is lost
+// when the process exits.
+type Memory struct {
+	mu sync.Mutex
+	// txMu serialises transactions so one can be rolled back without
+	// discarding another's writes.
+	txMu sync.Mutex
+
+	// now is the clock used for created_at, updated_at and every "older
+	// than" comparison. Tests can replace it to move time forward.
+	now func() time.Time
+
+	leads   []*Lead
+	orders  []*Order
+	refunds []*Refund
+	events  []*OrderEvent
+	reports []*ReconciliationReport
+
+	nextID int
+}
+
+func NewMemory() *Memory {
+	return &Memory{now: func() time.Time { return time.Now().UTC() }}
+}
+
+// SetClock replaces the clock used for timestamps and staleness checks.
+func (m *Memory) SetClock(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+func (m *Memory) id() int {
+	m.nextID++
+	return m.nextID
+}
+
+// WithTx snapshots the store, runs fn and restores the snapshot if fn fails.
+// Writes made outside any transaction while fn runs are lost on rollback,
+// which is good enough for tests and local development.
+func (m *Memory) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	m.mu.Lock()
+	snap := m.snapshot()
+	m.mu.Unlock()
+
+	if err := fn(memoryTx{m}); err != nil {
+		m.mu.Lock()
+		m.restore(snap)
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// memoryTx is the Store handed to a WithTx callback; nested WithTx calls
+// join the running transaction.
+type memoryTx struct {
+	*Memory
+}
+
+func (t memoryTx) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return fn(t)
+}
+
+type memorySnapshot struct {
+	leads   []*Lead
+	orders  []*Order
+	refunds []*Refund
+	events  []*OrderEvent
+	reports []*ReconciliationReport
+}
+
+// snapshot deep-copies the stored rows; callers must hold m.mu.
+func (m *Memory) snapshot() memorySnapshot {
+	var snap memorySnapshot
+	for _, l := range m.leads {
+		c := *l
+		snap.leads = append(snap.leads, &c)
+	}
+	for _, o := range m.orders {
+		snap.orders = append(snap.orders, cloneOrder(o))
+	}
+	for _, rf := range m.refunds {
+		c := *rf
+		snap.refunds = append(snap.refunds, &c)
+	}
+	for _, e := range m.events {
+		c := *e
+		snap.events = append(snap.events, &c)
+	}
+	for _, rep := range m.reports {
+		c := *rep
+		snap.reports = append(snap.reports, &c)
+	}
+	return snap
+}
+
+func (m *Memory) restore(snap memorySnapshot) {
+	m.leads = snap.leads
+	m.orders = snap.orders
+	m.refunds = snap.refunds
+	m.events = snap.events
+	m.reports = snap.reports
+}
+
+// Leads
+
+func (m *Memory) UpsertLead(ctx context.Context, email, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range m.leads {
+		if l.Email == email {
+			l.Name = name
+			return nil
+		}
+	}
+	now := m.now()
+	m.leads = append(m.leads, &Lead{ID: m.id(), Email: email, Name: name, CreatedAt: now, LastEmailAt: now})
+	return nil
+}
+
+func (m *Memory) CreateLead(ctx context.Context, email, name string) error {
+	return m.UpsertLead(ctx, email, name)
+}
+
+func (m *Memory) MarkPaid(ctx context.Context, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range m.leads {
+		if l.Email == email {
+			l.Paid = true
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetStaleLeads(ctx context.Context, delay time.Duration, currentStep int) ([]Lead, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := m.now().Add(-delay)
+	var leads []Lead
+	for _, l := range m.leads {
+		if len(leads) == 50 {
+			break
+		}
+		if !l.Paid && l.EmailStep == currentStep && l.LastEmailAt.Before(cutoff) {
+			leads = append(leads, *l)
+		}
+	}
+	return leads, nil
+}
+
+func (m *Memory) IncrementEmailStep(ctx context.Context, id int, newStep int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range m.leads {
+		if l.ID == id {
+			l.EmailStep = newStep
+			l.LastEmailAt = m.now()
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetAllLeads(ctx context.Context) ([]Lead, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	leads := make([]Lead, 0, len(m.leads))
+	for _, l := range m.leads {
+		leads = append(leads, *l)
+	}
+	sort.SliceStable(leads, func(i, j int) bool { return leads[i].CreatedAt.After(leads[j].CreatedAt) })
+	return limit(leads, 100), nil
+}
+
+// Orders
+
+func (m *Memory) CreateOrder(ctx context.Context, o *Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o.IdempotencyKey != "" {
+		for _, existing := range m.orders {
+			if existing.IdempotencyKey == o.IdempotencyKey {
+				return ErrDuplicateOrder
+			}
+		}
+	}
+
+	if o.Status == "" {
+		o.Status = OrderCreated
+	}
+	o.ID = m.id()
+	o.CreatedAt = m.now()
+	o.UpdatedAt = o.CreatedAt
+	for i := range o.Letters {
+		l := &o.Letters[i]
+		if l.Status == "" {
+			l.Status = LetterPending
+		}
+		if l.MailClass == "" {
+			l.MailClass = mailer.Certified
+		}
+		l.ID = m.id()
+		l.OrderID = o.ID
+		l.CreatedAt = o.CreatedAt
+		l.UpdatedAt = o.CreatedAt
+	}
+
+	stored := cloneOrder(o)
+	for i := range stored.Letters {
+		stored.Letters[i].TrackingStatus, stored.Letters[i].TrackingUpdatedAt = "", nil
+	}
+	stored.PaymentStatus, stored.RefundedCents, stored.PaymentUpdatedAt = "", 0, nil
+	stored.MailedAt = nil
+	m.orders = append(m.orders, stored)
+	return nil
+}
+
+func (m *Memory) TransitionOrder(ctx context.Context, o *Order, from, to OrderStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.findOrder(func(s *Order) bool { return s.ID == o.ID })
+	if stored == nil || stored.Status != from {
+		return ErrOrderStateChanged
+	}
+	stored.Status = to
+	stored.LastError = o.LastError
+	stored.PaymentID = o.PaymentID
+	stored.SourceToken = o.SourceToken
+	stored.UpdatedAt = m.now()
+	o.Status = to
+	return nil
+}
+
+func (m *Memory) GetOrder(ctx context.Context, id int) (*Order, error) {
+	return m.getOrder(func(o *Order) bool { return o.ID == id }), nil
+}
+
+func (m *Memory) GetStuckOrders(ctx context.Context, idle time.Duration) ([]Order, error) {
+	m.mu.Lock()
+	cutoff := m.now().Add(-idle)
+	m.mu.Unlock()
+
+	orders := m.filterOrders(func(o *Order) bool {
+		return (o.Status == OrderCreated || o.Status == OrderCharged || o.Status == OrderLetterSubmitted) &&
+			o.UpdatedAt.Before(cutoff)
+	})
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].UpdatedAt.Before(orders[j].UpdatedAt) })
+	return limit(orders, 50), nil
+}
+
+func (m *Memory) GetOrderByIdempotencyKey(ctx context.Context, key string) (*Order, error) {
+	return m.getOrder(func(o *Order) bool { return o.IdempotencyKey == key }), nil
+}
+
+func (m *Memory) GetOrderByLetterID(ctx context.Context, letterID string) (*Order, error) {
+	if letterID == "" {
+		return nil, nil
+	}
+	return m.getOrder(func(o *Order) bool { return o.Letter(letterID) != nil }), nil
+}
+
+func (m *Memory) GetOrderByPaymentID(ctx context.Context, paymentID string) (*Order, error) {
+	return m.getOrder(func(o *Order) bool { return o.PaymentID == paymentID }), nil
+}
+
+func (m *Memory) GetOrdersByEmail(ctx context.Context, email string) ([]Order, error) {
+	orders := m.filterOrders(func(o *Order) bool { return o.UserEmail == email })
+	sortNewestFirst(orders)
+	return orders, nil
+}
+
+func (m *Memory) GetOrdersCreatedBetween(ctx context.Context, begin, end time.Time) ([]Order, error) {
+	orders := m.filterOrders(func(o *Order) bool {
+		return !o.CreatedAt.Before(begin) && o.CreatedAt.Before(end)
+	})
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	return orders, nil
+}
+
+func (m *Memory) GetRecentOrders(ctx context.Context, n int) ([]Order, error) {
+	orders := m.filterOrders(func(*Order) bool { return true })
+	sortNewestFirst(orders)
+	return limit(orders, n), nil
+}
+
+func (m *Memory) UpdateLetter(ctx context.Context, l *OrderLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.findLetter(l.ID)
+	if stored == nil {
+		return nil
+	}
+	stored.Status = l.Status
+	stored.LastError = l.LastError
+	stored.LetterID = l.LetterID
+	stored.TrackingNumber = l.TrackingNumber
+	stored.PDFURL = l.PDFURL
+	stored.ExpectedDelivery = l.ExpectedDelivery
+	stored.SendDate = cloneTime(l.SendDate)
+	stored.CancelledAt = cloneTime(l.CancelledAt)
+	stored.UpdatedAt = m.now()
+	return nil
+}
+
+func (m *Memory) UpdateTrackingStatus(ctx context.Context, letterID int, status string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.findLetter(letterID)
+	if l == nil || (l.TrackingUpdatedAt != nil && l.TrackingUpdatedAt.After(at)) {
+		return nil
+	}
+	at = at.UTC()
+	l.TrackingStatus = status
+	l.TrackingUpdatedAt = &at
+	return nil
+}
+
+func (m *Memory) UpdatePaymentStatus(ctx context.Context, orderID int, status string, refundedCents int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := m.findOrder(func(o *Order) bool { return o.ID == orderID })
+	if o == nil || (o.PaymentUpdatedAt != nil && o.PaymentUpdatedAt.After(at)) {
+		return nil
+	}
+	at = at.UTC()
+	o.PaymentStatus = status
+	o.RefundedCents = max(o.RefundedCents, refundedCents)
+	o.PaymentUpdatedAt = &at
+	return nil
+}
+
+func (m *Memory) MarkOrderMailed(ctx context.Context, orderID int, at time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := m.findOrder(func(o *Order) bool { return o.ID == orderID })
+	if o == nil || o.MailedAt != nil {
+		return false, nil
+	}
+	at = at.UTC()
+	o.MailedAt = &at
+	return true, nil
+}
+
+func (m *Memory) GetLettersToArchive(ctx context.Context, retryBefore time.Time, limit int) ([]OrderLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var letters []OrderLetter
+	for _, o := range m.orders {
+		for _, l := range o.Letters {
+			if l.ArchivedAt != nil || l.LetterID == "" || l.CancelledAt != nil {
+				continue
+			}
+			if l.ArchiveAttemptedAt != nil && !l.ArchiveAttemptedAt.Before(retryBefore) {
+				continue
+			}
+			letters = append(letters, cloneOrder(&Order{Letters: []OrderLetter{l}}).Letters[0])
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].ID < letters[j].ID })
+	if len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return letters, nil
+}
+
+func (m *Memory) MarkLetterArchived(ctx context.Context, letterID int, key string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l := m.findLetter(letterID); l != nil {
+		at = at.UTC()
+		l.ArchiveKey = key
+		l.ArchivedAt = &at
+	}
+	return nil
+}
+
+func (m *Memory) RecordArchiveAttempt(ctx context.Context, letterID int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l := m.findLetter(letterID); l != nil {
+		at = at.UTC()
+		l.ArchiveAttemptedAt = &at
+	}
+	return nil
+}
+
+// findOrder returns the stored order itself; callers must hold m.mu.
+func (m *Memory) findOrder(match func(*Order) bool) *Order {
+	for _, o := range m.orders {
+		if match(o) {
+			return o
+		}
+	}
+	return nil
+}
+
+// findLetter returns the stored letter itself; callers must hold m.mu.
+func (m *Memory) findLetter(id int) *OrderLetter {
+	for _, o := range m.orders {
+		for i := range o.Letters {
+			if o.Letters[i].ID == id {
+				return &o.Letters[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) getOrder(match func(*Order) bool) *Order {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o := m.findOrder(match); o != nil {
+		return cloneOrder(o)
+	}
+	return nil
+}
+
+func (m *Memory) filterOrders(match func(*Order) bool) []Order {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var orders []Order
+	for _, o := range m.orders {
+		if match(o) {
+			orders = append(orders, *cloneOrder(o))
+		}
+	}
+	return orders
+}
+
+func cloneOrder(o *Order) *Order {
+	c := *o
+	c.Letters = nil
+	for _, l := range o.Letters {
+		l.SendDate = cloneTime(l.SendDate)
+		l.CancelledAt = cloneTime(l.CancelledAt)
+		l.TrackingUpdatedAt = cloneTime(l.TrackingUpdatedAt)
+		l.ArchivedAt = cloneTime(l.ArchivedAt)
+		l.ArchiveAttemptedAt = cloneTime(l.ArchiveAttemptedAt)
+		c.Letters = append(c.Letters, l)
+	}
+	c.PaymentUpdatedAt = cloneTime(o.PaymentUpdatedAt)
+	c.SendDate = cloneTime(o.SendDate)
+	c.MailedAt = cloneTime(o.MailedAt)
+	if o.Waiver != nil {
+		w := *o.Waiver
+		c.Waiver = &w
+	}
+	return &c
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+func sortNewestFirst(orders []Order) {
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].CreatedAt.After(orders[j].CreatedAt) })
+}
+
+// Refunds
+
+func (m *Memory) EnqueueRefund(ctx context.Context, rf *Refund, retryIn time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.refunds {
+		if existing.PaymentID == rf.PaymentID && existing.IdempotencyKey == rf.IdempotencyKey {
+			return nil
+		}
+	}
+
+	if rf.Status == "" {
+		rf.Status = RefundPending
+	}
+	now := m.now()
+	rf.ID = m.id()
+	rf.CreatedAt = now
+	rf.UpdatedAt = now
+	rf.NextAttemptAt = now.Add(retryIn)
+
+	stored := *rf
+	stored.ResolvedAt, stored.Resolution = nil, ""
+	m.refunds = append(m.refunds, &stored)
+	return nil
+}
+
+func (m *Memory) GetDueRefunds(ctx context.Context, n int) ([]Refund, error) {
+	m.mu.Lock()
+	now := m.now()
+	m.mu.Unlock()
+
+	refunds := m.filterRefunds(func(rf *Refund) bool {
+		return (rf.Status == RefundPending || rf.Status == RefundEscalated) && !rf.NextAttemptAt.After(now)
+	})
+	sort.SliceStable(refunds, func(i, j int) bool { return refunds[i].NextAttemptAt.Before(refunds[j].NextAttemptAt) })
+	return limit(refunds, n), nil
+}
+
+func (m *Memory) GetOpenRefunds(ctx context.Context) ([]Refund, error) {
+	refunds := m.filterRefunds(func(rf *Refund) bool { return rf.Status != RefundResolved })
+	sort.SliceStable(refunds, func(i, j int) bool { return refunds[i].CreatedAt.Before(refunds[j].CreatedAt) })
+	return refunds, nil
+}
+
+func (m *Memory) GetRefund(ctx context.Context, id int) (*Refund, error) {
+	refunds := m.filterRefunds(func(rf *Refund) bool { return rf.ID == id })
+	if len(refunds) == 0 {
+		return nil, nil
+	}
+	return &refunds[0], nil
+}
+
+func (m *Memory) GetOpenRefundsByPaymentID(ctx context.Context, paymentID string) ([]Refund, error) {
+	refunds := m.filterRefunds(func(rf *Refund) bool { return rf.PaymentID == paymentID && rf.Status != RefundResolved })
+	sort.SliceStable(refunds, func(i, j int) bool { return refunds[i].CreatedAt.Before(refunds[j].CreatedAt) })
+	return refunds, nil
+}
+
+func (m *Memory) RecordRefundAttempt(ctx context.Context, rf *Refund, retryIn time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.refunds {
+		if stored.ID == rf.ID {
+			now := m.now()
+			stored.Status = rf.Status
+			stored.Attempts = rf.Attempts
+			stored.NextAttemptAt = now.Add(retryIn)
+			stored.LastError = rf.LastError
+			stored.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ResolveRefund(ctx context.Context, id int, resolution string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.refunds {
+		if stored.ID == id && stored.Status != RefundResolved {
+			now := m.now()
+			stored.Status = RefundResolved
+			stored.Resolution = resolution
+			stored.ResolvedAt = &now
+			stored.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+func (m *Memory) filterRefunds(match func(*Refund) bool) []Refund {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var refunds []Refund
+	for _, rf := range m.refunds {
+		if match(rf) {
+			c := *rf
+			if rf.ResolvedAt != nil {
+				t := *rf.ResolvedAt
+				c.ResolvedAt = &t
+			}
+			refunds = append(refunds, c)
+		}
+	}
+	return refunds
+}
+
+// Events
+
+func (m *Memory) RecordOrderEvent(ctx context.Context, e *OrderEvent) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.events {
+		if existing.Source == e.Source && existing.EventID == e.EventID {
+			return false, nil
+		}
+	}
+
+	e.ID = m.id()
+	e.ReceivedAt = m.now()
+	stored := *e
+	stored.OccurredAt = e.OccurredAt.UTC()
+	stored.Payload = append([]byte(nil), e.Payload...)
+	m.events = append(m.events, &stored)
+	return true, nil
+}
+
+func (m *Memory) GetOrderEvents(ctx context.Context, orderID int) ([]OrderEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []OrderEvent
+	for _, e := range m.events {
+		if e.OrderID == orderID {
+			c := *e
+			c.Payload = append([]byte(nil), e.Payload...)
+			events = append(events, c)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].OccurredAt.Before(events[j].OccurredAt) })
+	return events, nil
+}
+
+// Reconciliation reports
+
+func (m *Memory) SaveReconciliationReport(ctx context.Context, rep *ReconciliationReport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rep.ID = m.id()
+	rep.CreatedAt = m.now()
+	stored := *rep
+	stored.Findings = append([]Finding(nil), rep.Findings...)
+	m.reports = append(m.reports, &stored)
+	return nil
+}
+
+func (m *Memory) GetLatestReconciliationReport(ctx context.Context) (*ReconciliationReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest *ReconciliationReport
+	for _, rep := range m.reports {
+		if latest == nil || !rep.CreatedAt.Before(latest.CreatedAt) {
+			latest = rep
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	c := *latest
+	c.Findings = append([]Finding(nil), latest.Findings...)
+	return &c, nil
+}
+
+func limit[T any](items []T, n int) []T {
+	if n >= 0 && len(items) > n {
+		return items[:n]
+	}
+	return items
+}
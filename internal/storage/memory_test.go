@@ -0,0 +1,351 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestMemory returns a Memory whose clock only moves when told to.
+func newTestMemory() (*Memory, *time.Time) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.SetClock(func() time.Time { return now })
+	return m, &now
+}
+
+func countOrders(t *testing.T, m *Memory) int {
+	t.Helper()
+	orders, err := m.GetRecentOrders(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(orders)
+}
+
+func TestMemoryWithTx(t *testing.T) {
+	errBoom := errors.New("boom")
+	create := func(tx Store, key string) error {
+		return tx.CreateOrder(context.Background(), &Order{IdempotencyKey: key})
+	}
+
+	tests := []struct {
+		name       string
+		fn         func(tx Store) error
+		wantErr    error
+		wantOrders int
+		wantName   string
+	}{
+		{
+			name: "commit",
+			fn: func(tx Store) error {
+				if err := tx.UpsertLead(context.Background(), "a@example.com", "Renamed"); err != nil {
+					return err
+				}
+				return create(tx, "k1")
+			},
+			wantOrders: 2,
+			wantName:   "Renamed",
+		},
+		{
+			name: "rollback",
+			fn: func(tx Store) error {
+				if err := tx.UpsertLead(context.Background(), "a@example.com", "Renamed"); err != nil {
+					return err
+				}
+				if err := create(tx, "k1"); err != nil {
+					return err
+				}
+				return errBoom
+			},
+			wantErr:    errBoom,
+			wantOrders: 1,
+			wantName:   "Original",
+		},
+		{
+			name: "nested failure rolls back the outer transaction",
+			fn: func(tx Store) error {
+				if err := create(tx, "k1"); err != nil {
+					return err
+				}
+				return tx.WithTx(context.Background(), func(inner Store) error {
+					if err := create(inner, "k2"); err != nil {
+						return err
+					}
+					return errBoom
+				})
+			},
+			wantErr:    errBoom,
+			wantOrders: 1,
+			wantName:   "Original",
+		},
+		{
+			name: "nested writes join the outer transaction",
+			fn: func(tx Store) error {
+				err := tx.WithTx(context.Background(), func(inner Store) error {
+					if err := create(inner, "k1"); err != nil {
+						return err
+					}
+					return errBoom
+				})
+				if !errors.Is(err, errBoom) {
+					return fmt.Errorf("nested WithTx = %v", err)
+				}
+				return nil
+			},
+			wantOrders: 2,
+			wantName:   "Original",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMemory()
+			ctx := context.Background()
+			if err := m.UpsertLead(ctx, "a@example.com", "Original"); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.CreateOrder(ctx, &Order{IdempotencyKey: "existing"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := m.WithTx(ctx, tt.fn); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("WithTx() = %v, want %v", err, tt.wantErr)
+			}
+			if n := countOrders(t, m); n != tt.wantOrders {
+				t.Errorf("%d orders, want %d", n, tt.wantOrders)
+			}
+			leads, _ := m.GetAllLeads(ctx)
+			if len(leads) != 1 || leads[0].Name != tt.wantName {
+				t.Errorf("leads = %+v, want one named %q", leads, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestMemoryCreateOrderDuplicate(t *testing.T) {
+	tests := []struct {
+		name        string
+		first, then string
+		wantErr     error
+		wantOrders  int
+	}{
+		{name: "repeated key", first: "k1", then: "k1", wantErr: ErrDuplicateOrder, wantOrders: 1},
+		{name: "different keys", first: "k1", then: "k2", wantOrders: 2},
+		{name: "no keys", wantOrders: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMemory()
+			ctx := context.Background()
+			if err := m.CreateOrder(ctx, &Order{IdempotencyKey: tt.first, UserEmail: "first@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			second := &Order{IdempotencyKey: tt.then, UserEmail: "second@example.com"}
+			if err := m.CreateOrder(ctx, second); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateOrder() = %v, want %v", err, tt.wantErr)
+			}
+			if n := countOrders(t, m); n != tt.wantOrders {
+				t.Errorf("%d orders, want %d", n, tt.wantOrders)
+			}
+			if tt.wantErr != nil {
+				if second.ID != 0 {
+					t.Errorf("rejected order was given ID %d", second.ID)
+				}
+				o, _ := m.GetOrderByIdempotencyKey(ctx, tt.first)
+				if o == nil || o.UserEmail != "first@example.com" {
+					t.Errorf("order for %q = %+v, want the first one", tt.first, o)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryTransitionOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         func(stored int) int
+		from, to   OrderStatus
+		wantErr    error
+		wantStatus OrderStatus
+	}{
+		{name: "expected state", from: OrderCreated, to: OrderCharged, wantStatus: OrderCharged},
+		{name: "moved on by someone else", from: OrderCharged, to: OrderLetterSubmitted, wantErr: ErrOrderStateChanged, wantStatus: OrderCreated},
+		{name: "unknown order", id: func(stored int) int { return stored + 100 }, from: OrderCreated, to: OrderCharged, wantErr: ErrOrderStateChanged, wantStatus: OrderCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, now := newTestMemory()
+			ctx := context.Background()
+			o := &Order{IdempotencyKey: "k1", SourceToken: "cnon_1"}
+			if err := m.CreateOrder(ctx, o); err != nil {
+				t.Fatal(err)
+			}
+			*now = now.Add(time.Minute)
+
+			update := &Order{ID: o.ID, Status: tt.from, PaymentID: "pay_1"}
+			if tt.id != nil {
+				update.ID = tt.id(o.ID)
+			}
+			if err := m.TransitionOrder(ctx, update, tt.from, tt.to); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TransitionOrder() = %v, want %v", err, tt.wantErr)
+			}
+
+			stored, _ := m.GetOrder(ctx, o.ID)
+			if stored.Status != tt.wantStatus {
+				t.Errorf("stored status = %s, want %s", stored.Status, tt.wantStatus)
+			}
+			if tt.wantErr != nil {
+				if update.Status != tt.from {
+					t.Errorf("caller's status = %s after a failed transition, want %s", update.Status, tt.from)
+				}
+				if stored.PaymentID != "" || stored.SourceToken != "cnon_1" || !stored.UpdatedAt.Equal(stored.CreatedAt) {
+					t.Errorf("failed transition wrote to the order: %+v", stored)
+				}
+				return
+			}
+			if update.Status != tt.to || stored.PaymentID != "pay_1" || stored.SourceToken != "" || !stored.UpdatedAt.Equal(*now) {
+				t.Errorf("after transition: caller %s, stored %+v", update.Status, stored)
+			}
+			// The same move a second time finds the order already moved.
+			if err := m.TransitionOrder(ctx, &Order{ID: o.ID}, tt.from, tt.to); !errors.Is(err, ErrOrderStateChanged) {
+				t.Errorf("repeated TransitionOrder() = %v, want ErrOrderStateChanged", err)
+			}
+		})
+	}
+}
+
+func TestMemoryGetStaleLeads(t *testing.T) {
+	const delay = 24 * time.Hour
+
+	tests := []struct {
+		name  string
+		setup func(m *Memory, now *time.Time)
+		step  int
+		want  int
+	}{
+		{
+			name: "waits out the delay",
+			setup: func(m *Memory, now *time.Time) {
+				_ = m.UpsertLead(context.Background(), "a@example.com", "A")
+				*now = now.Add(delay)
+			},
+			want: 0,
+		},
+		{
+			name: "stale after the delay",
+			setup: func(m *Memory, now *time.Time) {
+				_ = m.UpsertLead(context.Background(), "a@example.com", "A")
+				*now = now.Add(delay + time.Second)
+			},
+			want: 1,
+		},
+		{
+			name: "paid leads are skipped",
+			setup: func(m *Memory, now *time.Time) {
+				_ = m.UpsertLead(context.Background(), "a@example.com", "A")
+				_ = m.UpsertLead(context.Background(), "b@example.com", "B")
+				_ = m.MarkPaid(context.Background(), "a@example.com")
+				*now = now.Add(2 * delay)
+			},
+			want: 1,
+		},
+		{
+			name: "only the asked step",
+			setup: func(m *Memory, now *time.Time) {
+				_ = m.UpsertLead(context.Background(), "a@example.com", "A")
+				_ = m.UpsertLead(context.Background(), "b@example.com", "B")
+				leads, _ := m.GetAllLeads(context.Background())
+				_ = m.IncrementEmailStep(context.Background(), leads[0].ID, 1)
+				*now = now.Add(2 * delay)
+			},
+			step: 1,
+			want: 1,
+		},
+		{
+			name: "next step restarts the delay",
+			setup: func(m *Memory, now *time.Time) {
+				_ = m.UpsertLead(context.Background(), "a@example.com", "A")
+				*now = now.Add(2 * delay)
+				leads, _ := m.GetAllLeads(context.Background())
+				_ = m.IncrementEmailStep(context.Background(), leads[0].ID, 1)
+				*now = now.Add(time.Hour)
+			},
+			step: 1,
+			want: 0,
+		},
+		{
+			name: "at most 50",
+			setup: func(m *Memory, now *time.Time) {
+				for i := range 60 {
+					_ = m.UpsertLead(context.Background(), fmt.Sprintf("%d@example.com", i), "")
+				}
+				*now = now.Add(2 * delay)
+			},
+			want: 50,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, now := newTestMemory()
+			tt.setup(m, now)
+			leads, err := m.GetStaleLeads(context.Background(), delay, tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(leads) != tt.want {
+				t.Errorf("got %d leads, want %d", len(leads), tt.want)
+			}
+			for _, l := range leads {
+				if l.EmailStep != tt.step {
+					t.Errorf("lead %s is at step %d, want %d", l.Email, l.EmailStep, tt.step)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryEnqueueRefund(t *testing.T) {
+	tests := []struct {
+		name        string
+		paymentID   string
+		key         string
+		wantRefunds int
+	}{
+		{name: "same payment and key is a no-op", paymentID: "pay_1", key: "refund-1", wantRefunds: 1},
+		{name: "another key on the payment", paymentID: "pay_1", key: "refund-2", wantRefunds: 2},
+		{name: "same key on another payment", paymentID: "pay_2", key: "refund-1", wantRefunds: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMemory()
+			ctx := context.Background()
+			first := &Refund{OrderID: 1, PaymentID: "pay_1", AmountCents: 2900, IdempotencyKey: "refund-1"}
+			if err := m.EnqueueRefund(ctx, first, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+
+			again := &Refund{OrderID: 1, PaymentID: tt.paymentID, AmountCents: 9900, IdempotencyKey: tt.key}
+			if err := m.EnqueueRefund(ctx, again, time.Hour); err != nil {
+				t.Fatalf("EnqueueRefund() = %v", err)
+			}
+
+			open, _ := m.GetOpenRefunds(ctx)
+			if len(open) != tt.wantRefunds {
+				t.Fatalf("%d open refunds, want %d", len(open), tt.wantRefunds)
+			}
+			if open[0].ID != first.ID || open[0].AmountCents != 2900 || !open[0].NextAttemptAt.Equal(first.NextAttemptAt) {
+				t.Errorf("first refund changed: %+v", open[0])
+			}
+			if conflict := tt.wantRefunds == 1; conflict != (again.ID == 0) {
+				t.Errorf("second refund ID = %d, conflict %v", again.ID, conflict)
+			}
+		})
+	}
+}
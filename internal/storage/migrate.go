@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID keys the Postgres advisory lock held while migrating, so
+// that two instances booting at once never apply the same migration twice.
+const migrationLockID = 8200_0001
+
+const migrationsSchema = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one numbered schema change from internal/storage/migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState reports whether a migration has been applied, and when.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads the embedded migrations in version order. Every
+// version needs both an up and a down file.
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %q", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(migrationsFS, "migrations/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp applies every migration that has not been applied yet and returns
+// the ones it ran. Each migration commits on its own, so a failure leaves the
+// schema at the last good version.
+func (d *DB) MigrateUp(ctx context.Context) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	err = d.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, m.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+				return err
+			}
+			log.Printf("🗄️  Applied migration %04d_%s", m.Version, m.Name)
+			ran = append(ran, m)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// MigrateDown rolls back the most recent `steps` applied migrations, newest
+// first, and returns the ones it reverted.
+func (d *DB) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("steps must be at least 1, got %d", steps)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	known := map[int]Migration{}
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+
+	var reverted []Migration
+	err = d.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for _, v := range versions {
+			if len(reverted) == steps {
+				break
+			}
+			m, ok := known[v]
+			if !ok {
+				return fmt.Errorf("migration %d is applied but this build has no file for it", v)
+			}
+			if err := runMigration(ctx, conn, m, m.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+				return err
+			}
+			log.Printf("🗄️  Reverted migration %04d_%s", m.Version, m.Name)
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus lists every known migration alongside when it was applied.
+func (d *DB) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.pool.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, migrationsSchema); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations table failed: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, len(migrations))
+	for i, m := range migrations {
+		states[i].Migration = m
+		if at, ok := applied[m.Version]; ok {
+			states[i].AppliedAt = &at
+		}
+	}
+	return states, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock. Advisory locks belong to the session, so everything has to
+// happen on that one connection.
+func (d *DB) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := d.pool.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("acquiring migration lock failed: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			log.Printf("Error releasing migration lock: %v", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, migrationsSchema); err != nil {
+		return fmt.Errorf("creating schema_migrations table failed: %w", err)
+	}
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes a migration script and its bookkeeping statement in
+// one transaction.
+func runMigration(ctx context.Context, conn *sql.Conn, m Migration, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return fmt.Errorf("recording migration %04d_%s failed: %w", m.Version, m.Name, err)
+	}
+	return tx.Commit()
+}
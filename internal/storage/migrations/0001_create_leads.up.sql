@@ -0,0 +1,14 @@
+-- Databases created before versioned migrations already have this table,
+-- possibly without the drip columns, so every statement is idempotent.
+CREATE TABLE IF NOT EXISTS leads (
+	id SERIAL PRIMARY KEY,
+	email TEXT NOT NULL UNIQUE,
+	name TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	paid BOOLEAN DEFAULT FALSE,
+	email_step INTEGER DEFAULT 0,
+	last_email_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE leads ADD COLUMN IF NOT EXISTS email_step INTEGER DEFAULT 0;
+ALTER TABLE leads ADD COLUMN IF NOT EXISTS last_email_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
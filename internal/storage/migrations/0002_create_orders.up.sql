@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS orders (
+	id SERIAL PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_email TEXT NOT NULL,
+	notice JSONB NOT NULL,
+	to_address JSONB NOT NULL,
+	from_address JSONB NOT NULL,
+	payment_id TEXT NOT NULL DEFAULT '',
+	letter_id TEXT NOT NULL DEFAULT '',
+	tracking_number TEXT NOT NULL DEFAULT '',
+	pdf_url TEXT NOT NULL DEFAULT '',
+	expected_delivery TEXT NOT NULL DEFAULT '',
+	amount_cents BIGINT NOT NULL DEFAULT 0
+);
+
+-- Rows written before the state machine existed had always been mailed and
+-- receipted, hence the receipt_sent default.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'receipt_sent';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS idempotency_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS source_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_updated_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded_cents BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_updated_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS orders_user_email_idx ON orders (user_email);
+CREATE INDEX IF NOT EXISTS orders_payment_id_idx ON orders (payment_id);
+CREATE INDEX IF NOT EXISTS orders_letter_id_idx ON orders (letter_id);
+CREATE INDEX IF NOT EXISTS orders_status_idx ON orders (status, updated_at);
+CREATE UNIQUE INDEX IF NOT EXISTS orders_idempotency_key_idx ON orders (idempotency_key) WHERE idempotency_key <> '';
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS refund_queue (
+	id SERIAL PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	order_id INTEGER NOT NULL REFERENCES orders (id),
+	payment_id TEXT NOT NULL UNIQUE,
+	amount_cents BIGINT NOT NULL,
+	idempotency_key TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_error TEXT NOT NULL DEFAULT '',
+	resolved_at TIMESTAMP,
+	resolution TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS refund_queue_due_idx ON refund_queue (status, next_attempt_at);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS order_events (
+	id SERIAL PRIMARY KEY,
+	received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	source TEXT NOT NULL,
+	event_id TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	order_id INTEGER REFERENCES orders (id),
+	resource_id TEXT NOT NULL DEFAULT '',
+	occurred_at TIMESTAMP NOT NULL,
+	payload JSONB NOT NULL,
+	UNIQUE (source, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS order_events_order_idx ON order_events (order_id, occurred_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS reconciliation_reports (
+	id SERIAL PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	window_start TIMESTAMP NOT NULL,
+	window_end TIMESTAMP NOT NULL,
+	payments_checked INTEGER NOT NULL,
+	letters_checked INTEGER NOT NULL,
+	orders_checked INTEGER NOT NULL,
+	findings JSONB NOT NULL
+);
@@ -0,0 +1,17 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS to_address JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS letter_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_number TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS pdf_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS expected_delivery TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_updated_at TIMESTAMP;
+
+-- Only the owner's letter fits back into the single-recipient columns.
+UPDATE orders o
+SET to_address = l.to_address, letter_id = l.letter_id, tracking_number = l.tracking_number, pdf_url = l.pdf_url,
+	expected_delivery = l.expected_delivery, tracking_status = l.tracking_status, tracking_updated_at = l.tracking_updated_at
+FROM order_letters l
+WHERE l.order_id = o.id AND l.role = 'owner';
+
+CREATE INDEX IF NOT EXISTS orders_letter_id_idx ON orders (letter_id);
+DROP TABLE IF EXISTS order_letters;
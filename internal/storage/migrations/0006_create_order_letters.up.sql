@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS order_letters (
+	id SERIAL PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	order_id INTEGER NOT NULL REFERENCES orders (id),
+	role TEXT NOT NULL,
+	to_address JSONB NOT NULL,
+	amount_cents BIGINT NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'pending',
+	last_error TEXT NOT NULL DEFAULT '',
+	letter_id TEXT NOT NULL DEFAULT '',
+	tracking_number TEXT NOT NULL DEFAULT '',
+	pdf_url TEXT NOT NULL DEFAULT '',
+	expected_delivery TEXT NOT NULL DEFAULT '',
+	tracking_status TEXT NOT NULL DEFAULT '',
+	tracking_updated_at TIMESTAMP,
+	UNIQUE (order_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS order_letters_letter_id_idx ON order_letters (letter_id);
+
+-- Every order placed so far was a single letter to the property owner.
+INSERT INTO order_letters (created_at, updated_at, order_id, role, to_address, amount_cents, status, last_error,
+	letter_id, tracking_number, pdf_url, expected_delivery, tracking_status, tracking_updated_at)
+SELECT created_at, updated_at, id, 'owner', to_address, amount_cents,
+	CASE
+		WHEN letter_id <> '' THEN 'submitted'
+		WHEN status = 'refunded' THEN 'refunded'
+		WHEN status = 'failed' AND payment_id <> '' THEN 'failed'
+		ELSE 'pending'
+	END,
+	last_error, letter_id, tracking_number, pdf_url, expected_delivery, tracking_status, tracking_updated_at
+FROM orders;
+
+DROP INDEX IF EXISTS orders_letter_id_idx;
+ALTER TABLE orders
+	DROP COLUMN to_address,
+	DROP COLUMN letter_id,
+	DROP COLUMN tracking_number,
+	DROP COLUMN pdf_url,
+	DROP COLUMN expected_delivery,
+	DROP COLUMN tracking_status,
+	DROP COLUMN tracking_updated_at;
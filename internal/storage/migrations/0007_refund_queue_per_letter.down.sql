@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS refund_queue_payment_key_idx;
+ALTER TABLE refund_queue DROP COLUMN IF EXISTS order_letter_id;
+ALTER TABLE refund_queue ADD CONSTRAINT refund_queue_payment_id_key UNIQUE (payment_id);
@@ -0,0 +1,6 @@
+-- A payment can now be refunded in parts, one per letter that could not be
+-- mailed, so refunds are told apart by their idempotency key.
+ALTER TABLE refund_queue DROP CONSTRAINT IF EXISTS refund_queue_payment_id_key;
+ALTER TABLE refund_queue ADD COLUMN IF NOT EXISTS order_letter_id INTEGER REFERENCES order_letters (id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS refund_queue_payment_key_idx ON refund_queue (payment_id, idempotency_key);
@@ -0,0 +1 @@
+ALTER TABLE order_letters DROP COLUMN IF EXISTS mail_class;
@@ -0,0 +1,2 @@
+-- Every letter sent so far went certified.
+ALTER TABLE order_letters ADD COLUMN IF NOT EXISTS mail_class TEXT NOT NULL DEFAULT 'certified';
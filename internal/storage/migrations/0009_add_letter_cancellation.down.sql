@@ -0,0 +1,2 @@
+ALTER TABLE order_letters DROP COLUMN IF EXISTS cancelled_at;
+ALTER TABLE order_letters DROP COLUMN IF EXISTS send_date;
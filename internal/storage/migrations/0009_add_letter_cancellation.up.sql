@@ -0,0 +1,3 @@
+-- send_date is when Lob sends a letter to print; it can be cancelled until then.
+ALTER TABLE order_letters ADD COLUMN IF NOT EXISTS send_date TIMESTAMP;
+ALTER TABLE order_letters ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP;
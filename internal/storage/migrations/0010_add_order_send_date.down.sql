@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS mailed_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS send_date;
@@ -0,0 +1,4 @@
+-- send_date is the day a scheduled order should be mailed; NULL mails it right away.
+-- mailed_at is when a scheduled order's letters entered the mail stream.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS send_date DATE;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS mailed_at TIMESTAMP;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS order_letters_unarchived_idx;
+ALTER TABLE order_letters DROP COLUMN IF EXISTS archive_attempted_at;
+ALTER TABLE order_letters DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE order_letters DROP COLUMN IF EXISTS archive_key;
@@ -0,0 +1,7 @@
+-- archive_key is where the letter's PDF is kept in blob storage once
+-- archived_at is set. archive_attempted_at spaces out retries while Lob is
+-- still rendering it.
+ALTER TABLE order_letters ADD COLUMN IF NOT EXISTS archive_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE order_letters ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+ALTER TABLE order_letters ADD COLUMN IF NOT EXISTS archive_attempted_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS order_letters_unarchived_idx ON order_letters (id) WHERE archived_at IS NULL AND letter_id <> '';
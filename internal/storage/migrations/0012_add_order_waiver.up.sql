@@ -0,0 +1,3 @@
+-- waiver holds the lien waiver and release an order mails instead of a
+-- preliminary notice; NULL for notice orders.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS waiver JSONB;
@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"sendmynotice/internal/mailer"
+)
+
+type OrderStatus string
+
+const (
+	OrderCreated         OrderStatus = "created"
+	OrderCharged         OrderStatus = "charged"
+	OrderLetterSubmitted OrderStatus = "letter_submitted"
+	OrderReceiptSent     OrderStatus = "receipt_sent"
+	OrderRefunded        OrderStatus = "refunded"
+	OrderFailed          OrderStatus = "failed"
+)
+
+// Terminal reports whether no further work will ever be done on the order.
+func (s OrderStatus) Terminal() bool {
+	return s == OrderReceiptSent || s == OrderRefunded || s == OrderFailed
+}
+
+// ErrDuplicateOrder is returned by CreateOrder when an order with the same
+// idempotency key already exists.
+var ErrDuplicateOrder = errors.New("order with this idempotency key already exists")
+
+// ErrOrderStateChanged is returned when an order was moved on by someone else
+// (another request or the resumer) between being read and being saved.
+var ErrOrderStateChanged = errors.New("order state changed concurrently")
+
+// Order is the permanent record of a paid notice: what was printed, who it
+// went to, and the provider references needed to trace it later.
+type Order struct {
+	ID             int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Status         OrderStatus
+	LastError      string
+	IdempotencyKey string
+	SourceToken    string
+	UserEmail      string
+	Notice         mailer.NoticeData
+	FromAddress    mailer.Address
+	PaymentID      string
+	// AmountCents is what the customer was charged: the sum of the prices
+	// of the letters.
+	AmountCents int64
+
+	// Letters holds one letter per recipient, in the order they were added.
+	Letters []OrderLetter
+
+	// Waiver is set when the order mails a lien waiver and release rather
+	// than a preliminary notice.
+	Waiver *mailer.WaiverData
+
+	// SendDate is the day a scheduled order is to be mailed, or nil to mail
+	// it right away. MailedAt is when its letters entered the mail stream.
+	SendDate *time.Time
+	MailedAt *time.Time
+
+	// PaymentStatus mirrors Square: COMPLETED, REFUNDED, DISPUTED, etc.
+	PaymentStatus    string
+	RefundedCents    int64
+	PaymentUpdatedAt *time.Time
+}
+
+// Letter returns the order's letter that Lob knows as letterID, or nil.
+func (o *Order) Letter(letterID string) *OrderLetter {
+	for i := range o.Letters {
+		if o.Letters[i].LetterID == letterID {
+			return &o.Letters[i]
+		}
+	}
+	return nil
+}
+
+// Cancelled reports whether any of the order's letters was called back
+// before it went to print.
+func (o *Order) Cancelled() bool {
+	for _, l := range o.Letters {
+		if l.CancelledAt != nil {
+			return true
+		}
+	}
+	return false
+}
+
+const orderColumns = `id, created_at, updated_at, status, last_error, idempotency_key, source_token, user_email, notice, from_address,
+	payment_id, amount_cents, payment_status, refunded_cents, payment_updated_at, send_date, mailed_at, waiver`
+
+// CreateOrder stores o together with its letters.
+func (d *DB) CreateOrder(ctx context.Context, o *Order) error {
+	notice, err := json.Marshal(o.Notice)
+	if err != nil {
+		return fmt.Errorf("marshalling notice failed: %w", err)
+	}
+	from, err := json.Marshal(o.FromAddress)
+	if err != nil {
+		return fmt.Errorf("marshalling from address failed: %w", err)
+	}
+	var waiver []byte
+	if o.Waiver != nil {
+		if waiver, err = json.Marshal(o.Waiver); err != nil {
+			return fmt.Errorf("marshalling waiver failed: %w", err)
+		}
+	}
+
+	if o.Status == "" {
+		o.Status = OrderCreated
+	}
+
+	return d.withTx(ctx, func(tx *DB) error {
+		if err := tx.insertOrder(ctx, o, notice, from, waiver); err != nil {
+			return err
+		}
+		for i := range o.Letters {
+			o.Letters[i].OrderID = o.ID
+			if err := tx.createLetter(ctx, &o.Letters[i]); err != nil {
+				return fmt.Errorf("storing %s letter failed: %w", o.Letters[i].Role, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (d *DB) insertOrder(ctx context.Context, o *Order, notice, from, waiver []byte) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	err := d.sql.QueryRowContext(ctx, `
+		INSERT INTO orders (status, idempotency_key, source_token, user_email, notice, from_address, payment_id, amount_cents, send_date, waiver)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (idempotency_key) WHERE idempotency_key <> '' DO NOTHING
+		RETURNING id, created_at, updated_at`,
+		o.Status, o.IdempotencyKey, o.SourceToken, o.UserEmail, notice, from, o.PaymentID, o.AmountCents, utcOrNil(o.SendDate), waiver,
+	).Scan(&o.ID, &o.CreatedAt, &o.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrDuplicateOrder
+	}
+	return err
+}
+
+// TransitionOrder persists o in state `to`, but only if the stored row is
+// still in state `from`. On success o.Status is updated to `to`. Letters are
+// saved separately with UpdateLetter.
+func (d *DB) TransitionOrder(ctx context.Context, o *Order, from, to OrderStatus) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	res, err := d.sql.ExecContext(ctx, `
+		UPDATE orders
+		SET status = $1, last_error = $2, payment_id = $3, source_token = $4, updated_at = NOW()
+		WHERE id = $5 AND status = $6`,
+		to, o.LastError, o.PaymentID, o.SourceToken, o.ID, from,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrOrderStateChanged
+	}
+	o.Status = to
+	return nil
+}
+
+func (d *DB) GetOrder(ctx context.Context, id int) (*Order, error) {
+	return d.getOrder(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = $1`, id)
+}
+
+// GetStuckOrders returns non-terminal orders that have not moved for at least
+// idle, oldest first.
+func (d *DB) GetStuckOrders(ctx context.Context, idle time.Duration) ([]Order, error) {
+	return d.queryOrders(ctx, `
+		SELECT `+orderColumns+` FROM orders
+		WHERE status IN ($1, $2, $3)
+		AND updated_at < NOW() - $4::INTERVAL
+		ORDER BY updated_at ASC
+		LIMIT 50`,
+		OrderCreated, OrderCharged, OrderLetterSubmitted,
+		fmt.Sprintf("%d seconds", int(idle.Seconds())),
+	)
+}
+
+func (d *DB) GetOrderByIdempotencyKey(ctx context.Context, key string) (*Order, error) {
+	return d.getOrder(ctx, `SELECT `+orderColumns+` FROM orders WHERE idempotency_key = $1`, key)
+}
+
+// GetOrderByLetterID returns the order containing the letter Lob knows as
+// letterID.
+func (d *DB) GetOrderByLetterID(ctx context.Context, letterID string) (*Order, error) {
+	if letterID == "" {
+		return nil, nil
+	}
+	return d.getOrder(ctx, `SELECT `+orderColumns+` FROM orders
+		WHERE id IN (SELECT order_id FROM order_letters WHERE letter_id = $1)`, letterID)
+}
+
+// MarkOrderMailed records that a scheduled order entered the mail stream at
+// at. It reports false if that was already recorded, so the customer is told
+// only once.
+func (d *DB) MarkOrderMailed(ctx context.Context, orderID int, at time.Time) (bool, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	res, err := d.sql.ExecContext(ctx, `UPDATE orders SET mailed_at = $1 WHERE id = $2 AND mailed_at IS NULL`, at.UTC(), orderID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UpdatePaymentStatus records what Square last told us about an order's
+// payment. As with tracking, older events never overwrite newer ones, and
+// the refunded amount only ever grows.
+func (d *DB) UpdatePaymentStatus(ctx context.Context, orderID int, status string, refundedCents int64, at time.Time) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.sql.ExecContext(ctx, `
+		UPDATE orders SET payment_status = $1, refunded_cents = GREATEST(refunded_cents, $2), payment_updated_at = $3
+		WHERE id = $4 AND (payment_updated_at IS NULL OR payment_updated_at <= $3)`,
+		status, refundedCents, at.UTC(), orderID)
+	return err
+}
+
+func (d *DB) GetOrderByPaymentID(ctx context.Context, paymentID string) (*Order, error) {
+	return d.getOrder(ctx, `SELECT `+orderColumns+` FROM orders WHERE payment_id = $1`, paymentID)
+}
+
+func (d *DB) GetOrdersByEmail(ctx context.Context, email string) ([]Order, error) {
+	return d.queryOrders(ctx, `SELECT `+orderColumns+` FROM orders WHERE user_email = $1 ORDER BY created_at DESC`, email)
+}
+
+// GetOrdersCreatedBetween returns every order created in [begin, end).
+// created_at is a TIMESTAMP holding UTC wall time, the way it is read back,
+// so it is placed in UTC before comparing; left to itself Postgres would read
+// it in the session's time zone.
+func (d *DB) GetOrdersCreatedBetween(ctx context.Context, begin, end time.Time) ([]Order, error) {
+	return d.queryOrders(ctx, `SELECT `+orderColumns+` FROM orders
+		WHERE created_at AT TIME ZONE 'UTC' >= $1 AND created_at AT TIME ZONE 'UTC' < $2
+		ORDER BY created_at ASC`,
+		begin.UTC(), end.UTC())
+}
+
+func (d *DB) GetRecentOrders(ctx context.Context, limit int) ([]Order, error) {
+	return d.queryOrders(ctx, `SELECT `+orderColumns+` FROM orders ORDER BY created_at DESC LIMIT $1`, limit)
+}
+
+func (d *DB) getOrder(ctx context.Context, query string, args ...any) (*Order, error) {
+	orders, err := d.queryOrders(ctx, query, args...)
+	if err != nil || len(orders) == 0 {
+		return nil, err
+	}
+	return &orders[0], nil
+}
+
+// queryOrders runs query and loads the letters of every order it returns.
+func (d *DB) queryOrders(ctx context.Context, query string, args ...any) ([]Order, error) {
+	orders, err := d.scanOrders(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.loadLetters(ctx, orders); err != nil {
+		return nil, fmt.Errorf("loading letters: %w", err)
+	}
+	return orders, nil
+}
+
+func (d *DB) scanOrders(ctx context.Context, query string, args ...any) ([]Order, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.sql.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var orders []Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *o)
+	}
+	return orders, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanOrder(row rowScanner) (*Order, error) {
+	var o Order
+	var notice, from, waiver []byte
+	var paymentUpdatedAt, sendDate, mailedAt sql.NullTime
+	err := row.Scan(&o.ID, &o.CreatedAt, &o.UpdatedAt, &o.Status, &o.LastError, &o.IdempotencyKey, &o.SourceToken, &o.UserEmail, &notice, &from,
+		&o.PaymentID, &o.AmountCents, &o.PaymentStatus, &o.RefundedCents, &paymentUpdatedAt, &sendDate, &mailedAt, &waiver)
+	if err != nil {
+		return nil, err
+	}
+	if paymentUpdatedAt.Valid {
+		o.PaymentUpdatedAt = &paymentUpdatedAt.Time
+	}
+	if sendDate.Valid {
+		o.SendDate = &sendDate.Time
+	}
+	if mailedAt.Valid {
+		o.MailedAt = &mailedAt.Time
+	}
+	if err := json.Unmarshal(notice, &o.Notice); err != nil {
+		return nil, fmt.Errorf("decoding notice for order %d: %w", o.ID, err)
+	}
+	if err := json.Unmarshal(from, &o.FromAddress); err != nil {
+		return nil, fmt.Errorf("decoding from address for order %d: %w", o.ID, err)
+	}
+	if waiver != nil {
+		o.Waiver = new(mailer.WaiverData)
+		if err := json.Unmarshal(waiver, o.Waiver); err != nil {
+			return nil, fmt.Errorf("decoding waiver for order %d: %w", o.ID, err)
+		}
+	}
+	return &o, nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type FindingKind string
+
+const (
+	FindingChargedWithoutLetter FindingKind = "charged_without_letter"
+	FindingLetterWithoutPayment FindingKind = "letter_without_payment"
+	FindingRefundedButMailed    FindingKind = "refunded_but_mailed"
+)
+
+// Finding is one mismatch between Square, Lob and our orders table.
+type Finding struct {
+	Kind        FindingKind `json:"kind"`
+	OrderID     int         `json:"order_id,omitempty"`
+	PaymentID   string      `json:"payment_id,omitempty"`
+	LetterID    string      `json:"letter_id,omitempty"`
+	AmountCents int64       `json:"amount_cents,omitempty"`
+	Detail      string      `json:"detail"`
+}
+
+type ReconciliationReport struct {
+	ID              int
+	CreatedAt       time.Time
+	WindowStart     time.Time
+	WindowEnd       time.Time
+	PaymentsChecked int
+	LettersChecked  int
+	OrdersChecked   int
+	Findings        []Finding
+}
+
+func (d *DB) SaveReconciliationReport(ctx context.Context, rep *ReconciliationReport) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	findings, err := json.Marshal(rep.Findings)
+	if err != nil {
+		return fmt.Errorf("marshalling findings failed: %w", err)
+	}
+	return d.sql.QueryRowContext(ctx, `
+		INSERT INTO reconciliation_reports (window_start, window_end, payments_checked, letters_checked, orders_checked, findings)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`,
+		rep.WindowStart.UTC(), rep.WindowEnd.UTC(), rep.PaymentsChecked, rep.LettersChecked, rep.OrdersChecked, findings,
+	).Scan(&rep.ID, &rep.CreatedAt)
+}
+
+// GetLatestReconciliationReport returns the most recent report, or nil if
+// reconciliation has never run.
+func (d *DB) GetLatestReconciliationReport(ctx context.Context) (*ReconciliationReport, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var rep ReconciliationReport
+	var findings []byte
+	err := d.sql.QueryRowContext(ctx, `
+		SELECT id, created_at, window_start, window_end, payments_checked, letters_checked, orders_checked, findings
+		FROM reconciliation_reports
+		ORDER BY created_at DESC
+		LIMIT 1`,
+	).Scan(&rep.ID, &rep.CreatedAt, &rep.WindowStart, &rep.WindowEnd, &rep.PaymentsChecked, &rep.LettersChecked, &rep.OrdersChecked, &findings)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(findings, &rep.Findings); err != nil {
+		return nil, fmt.Errorf("decoding findings for report %d: %w", rep.ID, err)
+	}
+	return &rep, nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+type RefundStatus string
+
+const (
+	// RefundPending is retried automatically.
+	RefundPending RefundStatus = "pending"
+	// RefundEscalated is still retried, but admins have been told to step in.
+	RefundEscalated RefundStatus = "escalated"
+	RefundResolved  RefundStatus = "resolved"
+)
+
+// Refund is a refund that Square rejected and that is waiting to be retried.
+type Refund struct {
+	ID        int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	OrderID   int
+	// OrderLetterID is the letter whose share of the payment is being
+	// returned, or 0 for refunds queued before orders had several letters.
+	OrderLetterID  int
+	PaymentID      string
+	AmountCents    int64
+	IdempotencyKey string
+	Status         RefundStatus
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastError      string
+	ResolvedAt     *time.Time
+	Resolution     string
+}
+
+const refundColumns = `id, created_at, updated_at, order_id, COALESCE(order_letter_id, 0), payment_id, amount_cents, idempotency_key,
+	status, attempts, next_attempt_at, last_error, resolved_at, resolution`
+
+// EnqueueRefund adds a failed refund to the retry queue, due again after
+// retryIn. A refund is identified by its payment and idempotency key;
+// queueing the same one again is a no-op.
+func (d *DB) EnqueueRefund(ctx context.Context, rf *Refund, retryIn time.Duration) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	if rf.Status == "" {
+		rf.Status = RefundPending
+	}
+	err := d.sql.QueryRowContext(ctx, `
+		INSERT INTO refund_queue (order_id, order_letter_id, payment_id, amount_cents, idempotency_key, status, attempts, next_attempt_at, last_error)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, NOW() + $8::INTERVAL, $9)
+		ON CONFLICT (payment_id, idempotency_key) DO NOTHING
+		RETURNING id, created_at, updated_at, next_attempt_at`,
+		rf.OrderID, rf.OrderLetterID, rf.PaymentID, rf.AmountCents, rf.IdempotencyKey, rf.Status, rf.Attempts,
+		fmt.Sprintf("%d seconds", int(retryIn.Seconds())), rf.LastError,
+	).Scan(&rf.ID, &rf.CreatedAt, &rf.UpdatedAt, &rf.NextAttemptAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
+
+func (d *DB) GetDueRefunds(ctx context.Context, limit int) ([]Refund, error) {
+	return d.queryRefunds(ctx, `
+		SELECT `+refundColumns+` FROM refund_queue
+		WHERE status IN ($1, $2) AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at ASC
+		LIMIT $3`,
+		RefundPending, RefundEscalated, limit)
+}
+
+// GetOpenRefunds lists every refund that still needs money to go back.
+func (d *DB) GetOpenRefunds(ctx context.Context) ([]Refund, error) {
+	return d.queryRefunds(ctx, `
+		SELECT `+refundColumns+` FROM refund_queue
+		WHERE status <> $1
+		ORDER BY created_at ASC`,
+		RefundResolved)
+}
+
+func (d *DB) GetRefund(ctx context.Context, id int) (*Refund, error) {
+	refunds, err := d.queryRefunds(ctx, `SELECT `+refundColumns+` FROM refund_queue WHERE id = $1`, id)
+	if err != nil || len(refunds) == 0 {
+		return nil, err
+	}
+	return &refunds[0], nil
+}
+
+// GetOpenRefundsByPaymentID returns the unresolved queued refunds for a
+// payment, oldest first.
+func (d *DB) GetOpenRefundsByPaymentID(ctx context.Context, paymentID string) ([]Refund, error) {
+	return d.queryRefunds(ctx, `SELECT `+refundColumns+` FROM refund_queue WHERE payment_id = $1 AND status <> $2 ORDER BY created_at ASC`,
+		paymentID, RefundResolved)
+}
+
+// RecordRefundAttempt stores the outcome of a failed retry and schedules the
+// next one after retryIn.
+func (d *DB) RecordRefundAttempt(ctx context.Context, rf *Refund, retryIn time.Duration) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.sql.ExecContext(ctx, `
+		UPDATE refund_queue
+		SET status = $1, attempts = $2, next_attempt_at = NOW() + $3::INTERVAL, last_error = $4, updated_at = NOW()
+		WHERE id = $5`,
+		rf.Status, rf.Attempts, fmt.Sprintf("%d seconds", int(retryIn.Seconds())), rf.LastError, rf.ID)
+	return err
+}
+
+func (d *DB) ResolveRefund(ctx context.Context, id int, resolution string) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.sql.ExecContext(ctx, `
+		UPDATE refund_queue
+		SET status = $1, resolution = $2, resolved_at = NOW(), updated_at = NOW()
+		WHERE id = $3 AND status <> $1`,
+		RefundResolved, resolution, id)
+	return err
+}
+
+func (d *DB) queryRefunds(ctx context.Context, query string, args ...any) ([]Refund, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.sql.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var refunds []Refund
+	for rows.Next() {
+		var rf Refund
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&rf.ID, &rf.CreatedAt, &rf.UpdatedAt, &rf.OrderID, &rf.OrderLetterID, &rf.PaymentID, &rf.AmountCents,
+			&rf.IdempotencyKey, &rf.Status, &rf.Attempts, &rf.NextAttemptAt, &rf.LastError, &resolvedAt, &rf.Resolution); err != nil {
+			return nil, err
+		}
+		if resolvedAt.Valid {
+			rf.ResolvedAt = &resolvedAt.Time
+		}
+		refunds = append(refunds, rf)
+	}
+	return refunds, rows.Err()
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
@@ -19,66 +20,120 @@ type Lead struct {
 	LastEmailAt time.Time 
 }
 
+// Config tunes the connection pool and how long any single query may run.
+type Config struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// QueryTimeout bounds each statement on top of the caller's context.
+	// Zero means no limit beyond the caller's.
+	QueryTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxOpenConns:    20,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 30 * time.Minute,
+		ConnMaxIdleTime: 5 * time.Minute,
+		QueryTimeout:    5 * time.Second,
+	}
+}
+
+// querier is what *sql.DB and *sql.Tx have in common, so every method runs
+// unchanged inside or outside a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type DB struct {
-	sql *sql.DB
+	pool         *sql.DB
+	sql          querier
+	queryTimeout time.Duration
+	inTx         bool
 }
 
-func NewPostgres(dsn string) (*DB, error) {
+// NewPostgres connects to the database. It does not touch the schema; call
+// MigrateUp (or run `server migrate up`) for that.
+func NewPostgres(dsn string, cfg Config) (*DB, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
-
-	if err := db.Ping(); err != nil {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
 		return nil, fmt.Errorf("database connection failed: %w", err)
 	}
-	
-	query := `
-	CREATE TABLE IF NOT EXISTS leads (
-		id SERIAL PRIMARY KEY,
-		email TEXT NOT NULL UNIQUE,
-		name TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		paid BOOLEAN DEFAULT FALSE,
-		email_step INTEGER DEFAULT 0,
-		last_email_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-	
-	if _, err := db.Exec(query); err != nil {
-		return nil, err
+
+	return &DB{pool: db, sql: db, queryTimeout: cfg.QueryTimeout}, nil
+}
+
+// WithTx runs fn in a transaction, committing if it returns nil and rolling
+// back otherwise. The Store passed to fn is bound to the transaction; calling
+// WithTx on it again joins the same transaction.
+func (d *DB) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return d.withTx(ctx, func(tx *DB) error { return fn(tx) })
+}
+
+func (d *DB) withTx(ctx context.Context, fn func(tx *DB) error) error {
+	if d.inTx {
+		return fn(d)
 	}
 
-	migrateQueries := []string{
-		`ALTER TABLE leads ADD COLUMN IF NOT EXISTS email_step INTEGER DEFAULT 0;`,
-		`ALTER TABLE leads ADD COLUMN IF NOT EXISTS last_email_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;`,
+	tx, err := d.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction failed: %w", err)
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	for _, q := range migrateQueries {
-		if _, err := db.Exec(q); err != nil {
-			log.Printf("Migration notice (safe to ignore if column exists): %v", err)
-		}
+	if err := fn(&DB{pool: d.pool, sql: tx, queryTimeout: d.queryTimeout, inTx: true}); err != nil {
+		return err
 	}
+	return tx.Commit()
+}
 
-	return &DB{sql: db}, nil
+func (d *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d.queryTimeout)
 }
 
-func (d *DB) UpsertLead(email, name string) error {
+func (d *DB) UpsertLead(ctx context.Context, email, name string) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		INSERT INTO leads (email, name, last_email_at) 
 		VALUES ($1, $2, NOW())
 		ON CONFLICT (email) DO UPDATE 
 		SET name = EXCLUDED.name;`
-	_, err := d.sql.Exec(query, email, name)
+	_, err := d.sql.ExecContext(ctx, query, email, name)
 	return err
 }
 
-func (d *DB) MarkPaid(email string) error {
-	_, err := d.sql.Exec("UPDATE leads SET paid = TRUE WHERE email = $1", email)
+func (d *DB) MarkPaid(ctx context.Context, email string) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.sql.ExecContext(ctx, "UPDATE leads SET paid = TRUE WHERE email = $1", email)
 	return err
 }
 
-func (d *DB) GetStaleLeads(delay time.Duration, currentStep int) ([]Lead, error) {
-	rows, err := d.sql.Query(`
+func (d *DB) GetStaleLeads(ctx context.Context, delay time.Duration, currentStep int) ([]Lead, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.sql.QueryContext(ctx, `
 		SELECT id, email, COALESCE(name, ''), created_at, email_step, last_email_at
 		FROM leads 
 		WHERE paid = FALSE 
@@ -106,17 +161,23 @@ func (d *DB) GetStaleLeads(delay time.Duration, currentStep int) ([]Lead, error)
 	return leads, nil
 }
 
-func (d *DB) IncrementEmailStep(id int, newStep int) error {
-	_, err := d.sql.Exec("UPDATE leads SET email_step = $1, last_email_at = NOW() WHERE id = $2", newStep, id)
+func (d *DB) IncrementEmailStep(ctx context.Context, id int, newStep int) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.sql.ExecContext(ctx, "UPDATE leads SET email_step = $1, last_email_at = NOW() WHERE id = $2", newStep, id)
 	return err
 }
 
-func (d *DB) CreateLead(email, name string) error {
-	return d.UpsertLead(email, name)
+func (d *DB) CreateLead(ctx context.Context, email, name string) error {
+	return d.UpsertLead(ctx, email, name)
 }
 
-func (d *DB) GetAllLeads() ([]Lead, error) {
-	rows, err := d.sql.Query(`
+func (d *DB) GetAllLeads(ctx context.Context) ([]Lead, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.sql.QueryContext(ctx, `
 		SELECT id, email, COALESCE(name, ''), created_at, paid, email_step, last_email_at
 		FROM leads 
 		ORDER BY created_at DESC
@@ -125,10 +186,11 @@ func (d *DB) GetAllLeads() ([]Lead, error) {
 	if err != nil {
 		return nil, err
 	}
-	
-	if err := rows.Close(); err != nil {
-		log.Printf("Error closing rows: %v", err)
-	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
 
 	var leads []Lead
 	for rows.Next() {
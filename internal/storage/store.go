@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Store is everything the app persists. *DB keeps it in Postgres; *Memory
+// keeps it in process for tests and for running locally without a database.
+type Store interface {
+	LeadStore
+	OrderStore
+	RefundStore
+	EventStore
+	ReportStore
+
+	// WithTx runs fn atomically: everything fn writes through tx is kept if
+	// it returns nil and discarded otherwise.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+}
+
+type LeadStore interface {
+	UpsertLead(ctx context.Context, email, name string) error
+	CreateLead(ctx context.Context, email, name string) error
+	MarkPaid(ctx context.Context, email string) error
+	// GetStaleLeads returns up to 50 unpaid leads sitting on drip step
+	// currentStep whose last email went out more than delay ago.
+	GetStaleLeads(ctx context.Context, delay time.Duration, currentStep int) ([]Lead, error)
+	IncrementEmailStep(ctx context.Context, id int, newStep int) error
+	GetAllLeads(ctx context.Context) ([]Lead, error)
+}
+
+type OrderStore interface {
+	CreateOrder(ctx context.Context, o *Order) error
+	TransitionOrder(ctx context.Context, o *Order, from, to OrderStatus) error
+	GetOrder(ctx context.Context, id int) (*Order, error)
+	GetStuckOrders(ctx context.Context, idle time.Duration) ([]Order, error)
+	GetOrderByIdempotencyKey(ctx context.Context, key string) (*Order, error)
+	GetOrderByLetterID(ctx context.Context, letterID string) (*Order, error)
+	GetOrderByPaymentID(ctx context.Context, paymentID string) (*Order, error)
+	GetOrdersByEmail(ctx context.Context, email string) ([]Order, error)
+	GetOrdersCreatedBetween(ctx context.Context, begin, end time.Time) ([]Order, error)
+	GetRecentOrders(ctx context.Context, limit int) ([]Order, error)
+	UpdatePaymentStatus(ctx context.Context, orderID int, status string, refundedCents int64, at time.Time) error
+	UpdateLetter(ctx context.Context, l *OrderLetter) error
+	UpdateTrackingStatus(ctx context.Context, letterID int, status string, at time.Time) error
+	MarkOrderMailed(ctx context.Context, orderID int, at time.Time) (bool, error)
+	GetLettersToArchive(ctx context.Context, retryBefore time.Time, limit int) ([]OrderLetter, error)
+	MarkLetterArchived(ctx context.Context, letterID int, key string, at time.Time) error
+	RecordArchiveAttempt(ctx context.Context, letterID int, at time.Time) error
+}
+
+type RefundStore interface {
+	EnqueueRefund(ctx context.Context, rf *Refund, retryIn time.Duration) error
+	GetDueRefunds(ctx context.Context, limit int) ([]Refund, error)
+	GetOpenRefunds(ctx context.Context) ([]Refund, error)
+	GetRefund(ctx context.Context, id int) (*Refund, error)
+	GetOpenRefundsByPaymentID(ctx context.Context, paymentID string) ([]Refund, error)
+	RecordRefundAttempt(ctx context.Context, rf *Refund, retryIn time.Duration) error
+	ResolveRefund(ctx context.Context, id int, resolution string) error
+}
+
+type EventStore interface {
+	RecordOrderEvent(ctx context.Context, e *OrderEvent) (bool, error)
+	GetOrderEvents(ctx context.Context, orderID int) ([]OrderEvent, error)
+}
+
+type ReportStore interface {
+	SaveReconciliationReport(ctx context.Context, rep *ReconciliationReport) error
+	GetLatestReconciliationReport(ctx context.Context) (*ReconciliationReport, error)
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*Memory)(nil)
+)
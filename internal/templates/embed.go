@@ -5,7 +5,39 @@ import "embed"
 //go:embed notice.html
 var NoticeFS embed.FS
 
+//go:embed receipt.html
+var ReceiptFS embed.FS
+
+//go:embed mailed.html
+var MailedFS embed.FS
+
+//go:embed proof_of_service.html
+var ProofOfServiceFS embed.FS
+
+//go:embed waiver.html
+var WaiverFS embed.FS
+
 // GetNoticeFS exports the embedded filesystem so other packages can use it
 func GetNoticeFS() embed.FS {
 	return NoticeFS
-}
\ No newline at end of file
+}
+
+// GetReceiptFS exports the embedded receipt email template
+func GetReceiptFS() embed.FS {
+	return ReceiptFS
+}
+
+// GetMailedFS exports the embedded "your notice was mailed" email template
+func GetMailedFS() embed.FS {
+	return MailedFS
+}
+
+// GetProofOfServiceFS exports the embedded proof of service template
+func GetProofOfServiceFS() embed.FS {
+	return ProofOfServiceFS
+}
+
+// GetWaiverFS exports the embedded lien waiver and release template
+func GetWaiverFS() embed.FS {
+	return WaiverFS
+}
@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker stops us calling a provider that keeps failing. It opens after
+// threshold failures in a row and refuses every request for cooldown. After
+// that it lets a single trial request through: success closes it again, a
+// failure reopens it for another cooldown.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a request may go out now. Every allowed request must
+// be followed by Record or Release.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Tripped reports whether the breaker is open, so a retry would be refused.
+func (b *Breaker) Tripped() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold && time.Now().Before(b.openUntil)
+}
+
+// Record counts the outcome of an allowed request.
+func (b *Breaker) Record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// Release gives back an allowed request whose outcome says nothing about the
+// provider, such as one the caller cancelled.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+
+	tests := []struct {
+		name string
+		// steps is run in order: "ok" and "fail" are an allowed request and
+		// its outcome, "wait" sleeps out the cooldown, and "allow" and "deny"
+		// check Allow without recording anything.
+		steps       []string
+		wantTripped bool
+	}{
+		{name: "stays closed below threshold", steps: []string{"fail", "fail", "allow"}},
+		{name: "success resets the count", steps: []string{"fail", "fail", "ok", "fail", "fail", "allow"}},
+		{name: "opens at threshold", steps: []string{"fail", "fail", "fail", "deny"}, wantTripped: true},
+		{name: "one trial after cooldown", steps: []string{"fail", "fail", "fail", "wait", "allow", "deny"}},
+		{name: "failed trial reopens", steps: []string{"fail", "fail", "fail", "wait", "fail", "deny"}, wantTripped: true},
+		{name: "successful trial closes", steps: []string{"fail", "fail", "fail", "wait", "ok", "allow", "allow"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBreaker(3, cooldown)
+			for i, step := range tt.steps {
+				switch step {
+				case "ok", "fail":
+					if !b.Allow() {
+						t.Fatalf("step %d (%s): Allow() = false", i, step)
+					}
+					b.Record(step == "ok")
+				case "wait":
+					time.Sleep(cooldown)
+				case "allow", "deny":
+					if got := b.Allow(); got != (step == "allow") {
+						t.Fatalf("step %d: Allow() = %v", i, got)
+					}
+				}
+			}
+			if got := b.Tripped(); got != tt.wantTripped {
+				t.Errorf("Tripped() = %v, want %v", got, tt.wantTripped)
+			}
+		})
+	}
+}
+
+func TestBreakerRelease(t *testing.T) {
+	b := NewBreaker(1, time.Millisecond)
+	b.Allow()
+	b.Record(false)
+	time.Sleep(2 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatal("trial request refused")
+	}
+	b.Release()
+	if !b.Allow() {
+		t.Error("released trial was not given back")
+	}
+}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	b := NewTokenBucket(20, 2)
+	ctx := context.Background()
+
+	start := time.Now()
+	for range 2 {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("burst took %s, want no wait", elapsed)
+	}
+
+	start = time.Now()
+	if err := b.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// At 20 a second the next token is 50ms away.
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("third request waited %s, want about 50ms", elapsed)
+	}
+}
+
+func TestTokenBucketCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			want: context.DeadlineExceeded,
+		},
+		{
+			name: "already cancelled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			want: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One token a minute, and it has been spent.
+			b := NewTokenBucket(1.0/60, 1)
+			if err := b.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			start := time.Now()
+			if err := b.Wait(ctx); !errors.Is(err, tt.want) {
+				t.Errorf("Wait() = %v, want %v", err, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Wait took %s to notice the cancel", elapsed)
+			}
+		})
+	}
+}
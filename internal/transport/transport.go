@@ -0,0 +1,252 @@
+// Package transport is the http.RoundTripper shared by the Lob, Resend and
+// Square clients. Every request waits its turn in a token bucket, is refused
+// outright while the provider's circuit breaker is open, and is retried with
+// jittered backoff on network errors, 429s and transient 5xx responses.
+//
+// Only requests that are safe to repeat are retried: idempotent methods, and
+// requests that carry an idempotency key.
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the provider while its breaker
+// is open.
+var ErrCircuitOpen = errors.New("circuit open, provider is failing")
+
+type Config struct {
+	// Name identifies the provider in logs and errors, e.g. "lob".
+	Name string
+
+	// MaxAttempts counts the first try. Defaults to 4.
+	MaxAttempts int
+	// BaseBackoff doubles after every failed attempt up to MaxBackoff.
+	// Default 500ms and 10s.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// MaxRetryAfter is the longest Retry-After we wait out; a provider asking
+	// for longer gets its response passed back instead. Default 30s.
+	MaxRetryAfter time.Duration
+	// AttemptTimeout bounds each try, including reading its body. Default 10s.
+	AttemptTimeout time.Duration
+
+	// RatePerSecond and Burst size the client-side token bucket. Zero
+	// RatePerSecond turns rate limiting off.
+	RatePerSecond float64
+	Burst         int
+
+	// After FailureThreshold failed attempts in a row the breaker opens for
+	// Cooldown. Default 5 and 30s.
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	// Idempotent reports whether req may be sent more than once. Defaults to
+	// DefaultIdempotent.
+	Idempotent func(req *http.Request) bool
+}
+
+type Transport struct {
+	base    http.RoundTripper
+	cfg     Config
+	limiter *TokenBucket
+	breaker *Breaker
+}
+
+// New wraps base, or http.DefaultTransport if it is nil.
+func New(base http.RoundTripper, cfg Config) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 4
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 10 * time.Second
+	}
+	if cfg.MaxRetryAfter <= 0 {
+		cfg.MaxRetryAfter = 30 * time.Second
+	}
+	if cfg.AttemptTimeout <= 0 {
+		cfg.AttemptTimeout = 10 * time.Second
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	if cfg.Idempotent == nil {
+		cfg.Idempotent = DefaultIdempotent
+	}
+
+	t := &Transport{
+		base:    base,
+		cfg:     cfg,
+		breaker: NewBreaker(cfg.FailureThreshold, cfg.Cooldown),
+	}
+	if cfg.RatePerSecond > 0 {
+		t.limiter = NewTokenBucket(cfg.RatePerSecond, cfg.Burst)
+	}
+	return t
+}
+
+// NewClient is an http.Client sending through New(nil, cfg). It has no
+// overall timeout of its own; each attempt is bounded by AttemptTimeout and
+// the whole call by the request's context.
+func NewClient(cfg Config) *http.Client {
+	return &http.Client{Transport: New(nil, cfg)}
+}
+
+// DefaultIdempotent allows retrying the methods HTTP defines as idempotent,
+// and any request with an Idempotency-Key header.
+func DefaultIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	attempts := 1
+	if t.cfg.Idempotent(req) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil) {
+		attempts = t.cfg.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if t.limiter != nil {
+			if err := t.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if !t.breaker.Allow() {
+			return nil, fmt.Errorf("%s: %w", t.cfg.Name, ErrCircuitOpen)
+		}
+
+		resp, err := t.attempt(req, attempt)
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about the provider.
+			t.breaker.Release()
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		t.breaker.Record(!failed)
+		if !retryable(resp, err) || attempt >= attempts || t.breaker.Tripped() {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		reason := "network error"
+		if resp != nil {
+			reason = resp.Status
+			if ra, ok := retryAfter(resp, time.Now()); ok {
+				if ra > t.cfg.MaxRetryAfter {
+					return resp, nil
+				}
+				wait = ra
+			}
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+		}
+		log.Printf("🔁 %s %s %s failed (%s), retrying in %s (attempt %d of %d)",
+			t.cfg.Name, req.Method, req.URL.Path, reason, wait.Round(time.Millisecond), attempt+1, attempts)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends one try of req under its own timeout. The timeout stays
+// armed until the response body is closed.
+func (t *Transport) attempt(req *http.Request, n int) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.cfg.AttemptTimeout)
+	r := req.Clone(ctx)
+	if n > 1 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("rewinding request body: %w", err)
+		}
+		r.Body = body
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (t *Transport) backoff(attempt int) time.Duration {
+	d := t.cfg.BaseBackoff
+	for i := 1; i < attempt && d < t.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > t.cfg.MaxBackoff {
+		d = t.cfg.MaxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
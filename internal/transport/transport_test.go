@@ -0,0 +1,289 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flaky answers the first fails requests with status, and 200 after that.
+// It counts every request it sees.
+type flaky struct {
+	fails  int32
+	status int
+	header http.Header
+	calls  atomic.Int32
+	bodies []string
+}
+
+func (f *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := f.calls.Add(1)
+	body, _ := io.ReadAll(r.Body)
+	f.bodies = append(f.bodies, string(body))
+	if n <= f.fails {
+		for k, v := range f.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(f.status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func testConfig() Config {
+	return Config{
+		Name:        "test",
+		BaseBackoff: time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	}
+}
+
+// bodyKey is how the Square client decides: a JSON body with an
+// idempotency_key may be sent again.
+func bodyKey(req *http.Request) bool {
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	defer body.Close()
+	b, _ := io.ReadAll(body)
+	return bytes.Contains(b, []byte(`"idempotency_key"`))
+}
+
+func TestRetryOnlyIdempotent(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		header     string
+		idempotent func(*http.Request) bool
+		wantCalls  int32
+		wantStatus int
+	}{
+		{name: "GET is retried", method: http.MethodGet, wantCalls: 3, wantStatus: http.StatusOK},
+		{name: "POST is not retried", method: http.MethodPost, body: `{"a":1}`, wantCalls: 1, wantStatus: http.StatusServiceUnavailable},
+		{name: "POST with Idempotency-Key is retried", method: http.MethodPost, body: `{"a":1}`, header: "key-1", wantCalls: 3, wantStatus: http.StatusOK},
+		{name: "body with idempotency_key is retried", method: http.MethodPost, body: `{"idempotency_key":"k"}`, idempotent: bodyKey, wantCalls: 3, wantStatus: http.StatusOK},
+		{name: "body without idempotency_key is not retried", method: http.MethodPost, body: `{"amount":1}`, idempotent: bodyKey, wantCalls: 1, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flaky{fails: 2, status: http.StatusServiceUnavailable}
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+
+			cfg := testConfig()
+			cfg.Idempotent = tt.idempotent
+			client := NewClient(cfg)
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req, err := http.NewRequest(tt.method, srv.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Idempotency-Key", tt.header)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := f.calls.Load(); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			for i, b := range f.bodies {
+				if b != tt.body {
+					t.Errorf("attempt %d sent body %q, want %q", i+1, b, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		wantCalls  int32
+		wantStatus int
+	}{
+		// BaseBackoff is an hour, so these only finish quickly if the
+		// Retry-After wait is used in its place.
+		{name: "seconds", retryAfter: "0", wantCalls: 2, wantStatus: http.StatusOK},
+		{name: "HTTP date in the past", retryAfter: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), wantCalls: 2, wantStatus: http.StatusOK},
+		{name: "longer than MaxRetryAfter is passed back", retryAfter: "3600", wantCalls: 1, wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flaky{fails: 1, status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {tt.retryAfter}}}
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+
+			cfg := testConfig()
+			cfg.BaseBackoff = time.Hour
+			cfg.MaxBackoff = time.Hour
+			cfg.MaxRetryAfter = time.Minute
+			client := NewClient(cfg)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := f.calls.Load(); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "7", want: 7 * time.Second, ok: true},
+		{value: "-1", ok: false},
+		{value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second, ok: true},
+		{value: now.Add(-time.Hour).Format(http.TimeFormat), want: 0, ok: true},
+		{value: "soon", ok: false},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.value != "" {
+			resp.Header.Set("Retry-After", tt.value)
+		}
+		got, ok := retryAfter(resp, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTransportBreaker(t *testing.T) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.MaxAttempts = 1
+	cfg.FailureThreshold = 2
+	cfg.Cooldown = 50 * time.Millisecond
+	client := NewClient(cfg)
+
+	get := func() (int, error) {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	for i := range 2 {
+		if status, err := get(); err != nil || status != http.StatusInternalServerError {
+			t.Fatalf("call %d = %d, %v, want 500", i+1, status, err)
+		}
+	}
+	if _, err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open breaker: err = %v, want ErrCircuitOpen", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("provider saw %d calls while open, want 2", got)
+	}
+
+	time.Sleep(cfg.Cooldown)
+	healthy.Store(true)
+	if status, err := get(); err != nil || status != http.StatusOK {
+		t.Fatalf("trial call = %d, %v, want 200", status, err)
+	}
+	if status, err := get(); err != nil || status != http.StatusOK {
+		t.Fatalf("call after close = %d, %v, want 200", status, err)
+	}
+}
+
+func TestRetryStopsWhenBreakerOpens(t *testing.T) {
+	f := &flaky{fails: 10, status: http.StatusBadGateway}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.MaxAttempts = 5
+	cfg.FailureThreshold = 2
+	cfg.Cooldown = time.Minute
+	resp, err := NewClient(cfg).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", resp.StatusCode)
+	}
+	if got := f.calls.Load(); got != 2 {
+		t.Errorf("calls = %d, want 2", got)
+	}
+}
+
+func TestTransportCancelledWhileWaiting(t *testing.T) {
+	f := &flaky{fails: 10, status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.BaseBackoff = time.Hour
+	cfg.MaxBackoff = time.Hour
+	tr := New(nil, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	_, err := tr.RoundTrip(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s to notice the cancel", elapsed)
+	}
+	if got := f.calls.Load(); got != 1 {
+		t.Errorf("calls = %d, want 1", got)
+	}
+}
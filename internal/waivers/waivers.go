@@ -0,0 +1,119 @@
+// Package waivers is the registry of California's statutory lien waiver and
+// release forms (Civil Code §§ 8132–8138): their wording, and which of the
+// payment details each one asks for.
+package waivers
+
+import "strings"
+
+// Form is one statutory waiver and release form.
+type Form struct {
+	// Code is the form's Civil Code section, e.g. "8132".
+	Code    string
+	Title   string
+	Statute string
+
+	// Conditional forms only take effect once the check they identify
+	// clears; unconditional ones take effect when signed. Final forms
+	// release the whole job, progress forms only the work through a date.
+	Conditional bool
+	Final       bool
+
+	// Notice is the statutory warning printed above the waiver.
+	Notice string
+	// Waiver is the text of the waiver and release itself.
+	Waiver string
+	// Exceptions introduces what the waiver does not affect. Form 8132
+	// lists them in ExceptionItems, with the claimant's unpaid earlier
+	// waivers filled in under the item at PriorItem; the others end with
+	// the amount of any disputed claims for extras.
+	Exceptions     string
+	ExceptionItems []string
+	PriorItem      int
+}
+
+// Disputed reports whether the form asks for the amount of disputed claims
+// for extras.
+func (f *Form) Disputed() bool {
+	return len(f.ExceptionItems) == 0
+}
+
+// Progress reports whether the form releases work only through a date.
+func (f *Form) Progress() bool {
+	return !f.Final
+}
+
+// Kind is "Conditional" or "Unconditional".
+func (f *Form) Kind() string {
+	if f.Conditional {
+		return "Conditional"
+	}
+	return "Unconditional"
+}
+
+const (
+	conditionalNotice   = "NOTICE: THIS DOCUMENT WAIVES THE CLAIMANT'S LIEN, STOP PAYMENT NOTICE, AND PAYMENT BOND RIGHTS EFFECTIVE ON RECEIPT OF PAYMENT. A PERSON SHOULD NOT RELY ON THIS DOCUMENT UNLESS SATISFIED THAT THE CLAIMANT HAS RECEIVED PAYMENT."
+	unconditionalNotice = "NOTICE TO CLAIMANT: THIS DOCUMENT WAIVES AND RELEASES LIEN, STOP PAYMENT NOTICE, AND PAYMENT BOND RIGHTS UNCONDITIONALLY AND STATES THAT YOU HAVE BEEN PAID FOR GIVING UP THOSE RIGHTS. THIS DOCUMENT IS ENFORCEABLE AGAINST YOU IF YOU SIGN IT, EVEN IF YOU HAVE NOT BEEN PAID. IF YOU HAVE NOT BEEN PAID, USE A CONDITIONAL WAIVER AND RELEASE FORM."
+
+	changeOrders = "Rights based upon labor or service provided, or equipment or material delivered, pursuant to a written change order that has been fully executed by the parties prior to the date that this document is signed by the claimant, are waived and released by this document, unless listed as an Exception below."
+)
+
+var ConditionalProgress = &Form{
+	Code:        "8132",
+	Title:       "Conditional Waiver and Release on Progress Payment",
+	Statute:     "Civil Code § 8132",
+	Conditional: true,
+	Notice:      conditionalNotice,
+	Waiver:      "This document waives and releases lien, stop payment notice, and payment bond rights the claimant has for labor and service provided, and equipment and material delivered, to the customer on this job through the Through Date of this document. " + changeOrders + " This document is effective only on the claimant's receipt of payment from the financial institution on which the following check is drawn:",
+	Exceptions:  "This document does not affect any of the following:",
+	ExceptionItems: []string{
+		"(1) Retentions.",
+		"(2) Extras for which the claimant has not received payment.",
+		"(3) The following progress payments for which the claimant has previously given a conditional waiver and release but has not received payment:",
+		"(4) Contract rights, including (A) a right based on rescission, abandonment, or breach of contract, and (B) the right to recover compensation for work not compensated by the payment.",
+	},
+	PriorItem: 2,
+}
+
+var UnconditionalProgress = &Form{
+	Code:       "8134",
+	Title:      "Unconditional Waiver and Release on Progress Payment",
+	Statute:    "Civil Code § 8134",
+	Notice:     unconditionalNotice,
+	Waiver:     "This document waives and releases lien, stop payment notice, and payment bond rights the claimant has for labor and service provided, and equipment and material delivered, to the customer on this job through the Through Date of this document. " + changeOrders + " The claimant has received the following progress payment:",
+	Exceptions: "This document does not affect the following: Disputed claims for extras in the amount of:",
+}
+
+var ConditionalFinal = &Form{
+	Code:        "8136",
+	Title:       "Conditional Waiver and Release on Final Payment",
+	Statute:     "Civil Code § 8136",
+	Conditional: true,
+	Final:       true,
+	Notice:      conditionalNotice,
+	Waiver:      "This document waives and releases lien, stop payment notice, and payment bond rights the claimant has for labor and service provided, and equipment and material delivered, to the customer on this job. " + changeOrders + " This document is effective only on the claimant's receipt of payment from the financial institution on which the following check is drawn:",
+	Exceptions:  "This document does not affect the following: Disputed claims for extras in the amount of:",
+}
+
+var UnconditionalFinal = &Form{
+	Code:       "8138",
+	Title:      "Unconditional Waiver and Release on Final Payment",
+	Statute:    "Civil Code § 8138",
+	Final:      true,
+	Notice:     unconditionalNotice,
+	Waiver:     "This document waives and releases lien, stop payment notice, and payment bond rights the claimant has for all labor and service provided, and equipment and material delivered, to the customer on this job. " + changeOrders + " The claimant has been paid in full.",
+	Exceptions: "This document does not affect the following: Disputed claims for extras in the amount of:",
+}
+
+// All lists every form, in Civil Code order.
+var All = []*Form{ConditionalProgress, UnconditionalProgress, ConditionalFinal, UnconditionalFinal}
+
+// Lookup finds a form by its Civil Code section.
+func Lookup(code string) (*Form, bool) {
+	code = strings.TrimSpace(code)
+	for _, f := range All {
+		if f.Code == code {
+			return f, true
+		}
+	}
+	return nil, false
+}
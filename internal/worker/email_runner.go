@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -9,12 +10,12 @@ import (
 )
 
 type EmailRunner struct {
-	db          *storage.DB
+	db          storage.LeadStore
 	emailClient *email.Client
 	campaign    []email.CampaignStep
 }
 
-func NewEmailRunner(db *storage.DB, emailClient *email.Client) *EmailRunner {
+func NewEmailRunner(db storage.LeadStore, emailClient *email.Client) *EmailRunner {
 	return &EmailRunner{
 		db:          db,
 		emailClient: emailClient,
@@ -34,10 +35,12 @@ func (r *EmailRunner) Start() {
 }
 
 func (r *EmailRunner) processCampaign() {
+	ctx := context.Background()
+
 	for _, step := range r.campaign {
 		targetCurrentStep := step.StepID - 1
 		
-		leads, err := r.db.GetStaleLeads(step.Delay, targetCurrentStep)
+		leads, err := r.db.GetStaleLeads(ctx, step.Delay, targetCurrentStep)
 		if err != nil {
 			log.Printf("Error fetching leads for step %d: %v", step.StepID, err)
 			continue
@@ -54,7 +57,7 @@ func (r *EmailRunner) processCampaign() {
 				continue
 			}
 
-			if err := r.db.IncrementEmailStep(lead.ID, step.StepID); err != nil {
+			if err := r.db.IncrementEmailStep(ctx, lead.ID, step.StepID); err != nil {
 				log.Printf("Failed to update step for %s: %v", lead.Email, err)
 			} else {
 				log.Printf("✅ Sent Email #%d to %s", step.StepID, lead.Email)
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sendmynotice/internal/orders"
+	"sendmynotice/internal/storage"
+)
+
+// Orders untouched for this long are assumed to belong to a request that
+// died (crash, deploy, timeout) rather than one still in progress.
+const stuckOrderIdle = 2 * time.Minute
+
+type OrderResumer struct {
+	db        storage.Store
+	processor *orders.Processor
+}
+
+func NewOrderResumer(db storage.Store, processor *orders.Processor) *OrderResumer {
+	return &OrderResumer{
+		db:        db,
+		processor: processor,
+	}
+}
+
+func (r *OrderResumer) Start() {
+	log.Println("🔁 Order Resumer Started...")
+
+	r.resumeStuckOrders()
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.resumeStuckOrders()
+	}
+}
+
+func (r *OrderResumer) resumeStuckOrders() {
+	stuck, err := r.db.GetStuckOrders(context.Background(), stuckOrderIdle)
+	if err != nil {
+		log.Printf("Error fetching stuck orders: %v", err)
+		return
+	}
+
+	if len(stuck) > 0 {
+		log.Printf("🔍 Found %d stuck orders", len(stuck))
+	}
+
+	for i := range stuck {
+		o := &stuck[i]
+		from := o.Status
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := r.processor.Resume(ctx, o)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to resume order #%d (%s): %v", o.ID, from, err)
+			continue
+		}
+		log.Printf("✅ Resumed order #%d: %s -> %s", o.ID, from, o.Status)
+	}
+}
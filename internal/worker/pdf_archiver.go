@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"sendmynotice/internal/blob"
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/storage"
+	"sendmynotice/internal/transport"
+)
+
+// ErrPDFNotReady is returned by Archive while Lob is still rendering the
+// letter.
+var ErrPDFNotReady = errors.New("letter PDF is not rendered yet")
+
+// errLinkExpired means Lob's signed PDF link has run out; fetching the
+// letter again gives a new one.
+var errLinkExpired = errors.New("letter PDF link has expired")
+
+// maxPDFSize caps a download; a one-page notice is well under a megabyte.
+const maxPDFSize = 20 << 20
+
+// archiveRetryDelay spaces out attempts on a letter Lob has not finished
+// rendering.
+const archiveRetryDelay = 5 * time.Minute
+
+// PDFArchiver copies the PDF of every mailed letter from Lob into blob
+// storage, where it is kept as evidence that the notice was served. Lob's
+// copy is only reachable through links that expire.
+type PDFArchiver struct {
+	db         storage.Store
+	mailer     mailer.Mailer
+	blobs      blob.Store
+	httpClient *http.Client
+}
+
+func NewPDFArchiver(db storage.Store, m mailer.Mailer, blobs blob.Store) *PDFArchiver {
+	return &PDFArchiver{
+		db:         db,
+		mailer:     m,
+		blobs:      blobs,
+		httpClient: transport.NewClient(transport.Config{Name: "lob-pdf", AttemptTimeout: 30 * time.Second}),
+	}
+}
+
+func (a *PDFArchiver) Start() {
+	log.Println("🗄️  PDF Archiver Started...")
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.archiveDue()
+	}
+}
+
+func (a *PDFArchiver) archiveDue() {
+	letters, err := a.db.GetLettersToArchive(context.Background(), time.Now().Add(-archiveRetryDelay), 20)
+	if err != nil {
+		log.Printf("Error fetching letters to archive: %v", err)
+		return
+	}
+
+	for i := range letters {
+		l := &letters[i]
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := a.Archive(ctx, l)
+		cancel()
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrPDFNotReady) {
+			log.Printf("Archiving letter %s of order #%d failed: %v", l.LetterID, l.OrderID, err)
+		}
+		if err := a.db.RecordArchiveAttempt(context.Background(), l.ID, time.Now()); err != nil {
+			log.Printf("Failed to record archive attempt for letter %d: %v", l.ID, err)
+		}
+	}
+}
+
+// ArchiveKey is where the PDF of l is kept.
+func ArchiveKey(l *storage.OrderLetter) string {
+	return fmt.Sprintf("letters/%d/%s.pdf", l.OrderID, l.LetterID)
+}
+
+// Archive stores the PDF of l unless it already has been. It is also called
+// when a customer asks for a proof before the worker has got to it.
+func (a *PDFArchiver) Archive(ctx context.Context, l *storage.OrderLetter) error {
+	if l.ArchivedAt != nil {
+		return nil
+	}
+	if l.LetterID == "" {
+		return fmt.Errorf("letter %d was never mailed", l.ID)
+	}
+
+	pdf, err := a.download(ctx, l.PDFURL)
+	if errors.Is(err, errLinkExpired) {
+		var fresh *mailer.LetterResponse
+		fresh, err = a.mailer.GetLetter(ctx, l.LetterID)
+		if err != nil {
+			return fmt.Errorf("renewing PDF link: %w", err)
+		}
+		pdf, err = a.download(ctx, fresh.URL)
+	}
+	if err != nil {
+		return err
+	}
+
+	key := ArchiveKey(l)
+	if err := a.blobs.Put(ctx, key, pdf, "application/pdf"); err != nil {
+		return fmt.Errorf("storing PDF: %w", err)
+	}
+	now := time.Now().UTC()
+	if err := a.db.MarkLetterArchived(ctx, l.ID, key, now); err != nil {
+		return fmt.Errorf("recording archived PDF: %w", err)
+	}
+	l.ArchiveKey = key
+	l.ArchivedAt = &now
+	log.Printf("🗄️  Archived PDF of letter %s (order #%d)", l.LetterID, l.OrderID)
+	return nil
+}
+
+func (a *PDFArchiver) download(ctx context.Context, pdfURL string) ([]byte, error) {
+	if pdfURL == "" {
+		return nil, errLinkExpired
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pdfURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request creation error: %w", err)
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrPDFNotReady
+	case http.StatusForbidden:
+		return nil, errLinkExpired
+	default:
+		return nil, fmt.Errorf("downloading PDF failed (status %d)", resp.StatusCode)
+	}
+
+	pdf, err := io.ReadAll(io.LimitReader(resp.Body, maxPDFSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	if len(pdf) > maxPDFSize {
+		return nil, fmt.Errorf("PDF is larger than %d bytes", maxPDFSize)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		return nil, errors.New("download is not a PDF")
+	}
+	return pdf, nil
+}
@@ -0,0 +1,267 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/payment"
+	"sendmynotice/internal/storage"
+)
+
+// The provider and storage dependencies are interfaces so the job can run
+// against in-memory fakes.
+type PaymentLister interface {
+	ListPayments(ctx context.Context, begin, end time.Time) ([]payment.Summary, error)
+}
+
+type LetterLister interface {
+	ListLetters(ctx context.Context, begin, end time.Time) ([]mailer.LetterSummary, error)
+}
+
+type ReconciliationStore interface {
+	GetOrdersCreatedBetween(ctx context.Context, begin, end time.Time) ([]storage.Order, error)
+	SaveReconciliationReport(ctx context.Context, rep *storage.ReconciliationReport) error
+}
+
+const (
+	// Runs at 10:00 UTC, which is the small hours in California.
+	reconcileHourUTC = 10
+	// Each run looks back two days so a missed night is still covered.
+	reconcileLookback = 48 * time.Hour
+	// Orders younger than this may still be moving through the pipeline.
+	reconcileSettle = 1 * time.Hour
+	// A letter is created shortly after its payment. Every list is fetched
+	// this much wider than the window, so that whatever is checked inside it
+	// can find its counterpart just outside.
+	reconcileSlack = 1 * time.Hour
+)
+
+type Reconciler struct {
+	store    ReconciliationStore
+	payments PaymentLister
+	letters  LetterLister
+	alert    func(subject, body string)
+}
+
+func NewReconciler(store ReconciliationStore, payments PaymentLister, letters LetterLister, alert func(subject, body string)) *Reconciler {
+	return &Reconciler{
+		store:    store,
+		payments: payments,
+		letters:  letters,
+		alert:    alert,
+	}
+}
+
+func (r *Reconciler) Start() {
+	log.Println("🧾 Reconciliation Worker Started...")
+
+	for {
+		next := nextRunAt(time.Now().UTC(), reconcileHourUTC)
+		time.Sleep(time.Until(next))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if _, err := r.Run(ctx, time.Now()); err != nil {
+			log.Printf("Reconciliation failed: %v", err)
+			r.alert("🧾 Reconciliation FAILED", err.Error())
+		}
+		cancel()
+	}
+}
+
+func nextRunAt(now time.Time, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next
+}
+
+// Run reconciles the window ending an hour before now, saves the report and
+// emails it to admins.
+func (r *Reconciler) Run(ctx context.Context, now time.Time) (*storage.ReconciliationReport, error) {
+	end := now.Add(-reconcileSettle)
+	begin := end.Add(-reconcileLookback)
+
+	// Payments and orders come before their letters, so they are fetched
+	// from earlier and letters until later.
+	payments, err := r.payments.ListPayments(ctx, begin.Add(-reconcileSlack), end)
+	if err != nil {
+		return nil, err
+	}
+	letters, err := r.letters.ListLetters(ctx, begin.Add(-reconcileSlack), end.Add(reconcileSlack))
+	if err != nil {
+		return nil, err
+	}
+	orders, err := r.store.GetOrdersCreatedBetween(ctx, begin.Add(-reconcileSlack), end)
+	if err != nil {
+		return nil, fmt.Errorf("loading orders: %w", err)
+	}
+
+	rep := &storage.ReconciliationReport{
+		WindowStart:     begin,
+		WindowEnd:       end,
+		PaymentsChecked: len(payments),
+		LettersChecked:  len(letters),
+		OrdersChecked:   len(orders),
+		Findings:        Reconcile(begin, end, payments, letters, orders),
+	}
+	if err := r.store.SaveReconciliationReport(ctx, rep); err != nil {
+		return nil, fmt.Errorf("saving report: %w", err)
+	}
+
+	log.Printf("🧾 Reconciled %d payments, %d letters, %d orders: %d findings",
+		rep.PaymentsChecked, rep.LettersChecked, rep.OrdersChecked, len(rep.Findings))
+	r.alert(reportSubject(rep), reportBody(rep))
+	return rep, nil
+}
+
+// Reconcile cross-checks completed Square payments, Lob letters and our order
+// records, returning every payment that produced no letter, every letter with
+// no payment behind it, and every payment that was refunded while its letter
+// still went out. Only payments and letters created in [begin, end) are
+// reported; the rest are there to be matched against.
+func Reconcile(begin, end time.Time, payments []payment.Summary, letters []mailer.LetterSummary, orders []storage.Order) []storage.Finding {
+	inWindow := func(t time.Time) bool {
+		return !t.Before(begin) && t.Before(end)
+	}
+
+	ordersByPayment := map[string]*storage.Order{}
+	ordersByLetter := map[string]*storage.Order{}
+	for i := range orders {
+		o := &orders[i]
+		if o.PaymentID != "" {
+			ordersByPayment[o.PaymentID] = o
+		}
+		for _, l := range o.Letters {
+			if l.LetterID != "" {
+				ordersByLetter[l.LetterID] = o
+			}
+		}
+	}
+
+	// An order sends one letter per recipient, so a payment can be behind
+	// several letters.
+	lettersByPayment := map[string][]*mailer.LetterSummary{}
+	for i := range letters {
+		l := &letters[i]
+		ref := paymentRef(l.Description)
+		if o := ordersByLetter[l.ID]; o != nil {
+			ref = o.PaymentID
+		}
+		if ref != "" {
+			lettersByPayment[ref] = append(lettersByPayment[ref], l)
+		}
+	}
+
+	paymentsByID := map[string]*payment.Summary{}
+	for i := range payments {
+		paymentsByID[payments[i].ID] = &payments[i]
+	}
+
+	var findings []storage.Finding
+
+	for i := range payments {
+		p := &payments[i]
+		if p.Status != "COMPLETED" || !inWindow(p.CreatedAt) {
+			continue
+		}
+
+		o := ordersByPayment[p.ID]
+		var l *mailer.LetterSummary
+		for _, candidate := range lettersByPayment[p.ID] {
+			if !candidate.Deleted {
+				l = candidate
+				break
+			}
+		}
+		mailed := l != nil
+		refunded := p.AmountCents > 0 && p.RefundedCents >= p.AmountCents
+
+		f := storage.Finding{PaymentID: p.ID, AmountCents: p.AmountCents}
+		if o != nil {
+			f.OrderID = o.ID
+		}
+		switch {
+		case refunded && mailed:
+			f.Kind = storage.FindingRefundedButMailed
+			f.LetterID = l.ID
+			f.Detail = fmt.Sprintf("Payment fully refunded but letter %s was not cancelled", l.ID)
+		case !refunded && !mailed:
+			f.Kind = storage.FindingChargedWithoutLetter
+			if o == nil {
+				f.Detail = "Completed payment has no order record and no Lob letter"
+			} else {
+				f.Detail = fmt.Sprintf("Order is %s but Lob has no live letter for it", o.Status)
+			}
+		default:
+			continue
+		}
+		findings = append(findings, f)
+	}
+
+	for i := range letters {
+		l := &letters[i]
+		if l.Deleted {
+			continue
+		}
+		if created, err := time.Parse(time.RFC3339, l.DateCreated); err == nil && !inWindow(created) {
+			continue
+		}
+
+		f := storage.Finding{Kind: storage.FindingLetterWithoutPayment, LetterID: l.ID}
+		paymentID := paymentRef(l.Description)
+		if o := ordersByLetter[l.ID]; o != nil {
+			f.OrderID = o.ID
+			paymentID = o.PaymentID
+		}
+		f.PaymentID = paymentID
+
+		p := paymentsByID[paymentID]
+		switch {
+		case paymentID == "":
+			f.Detail = "Letter has no order record and no payment reference"
+		case p == nil:
+			f.Detail = "No Square payment found for the letter's payment reference"
+		case p.Status != "COMPLETED":
+			f.AmountCents = p.AmountCents
+			f.Detail = fmt.Sprintf("Square payment is %s", p.Status)
+		default:
+			continue
+		}
+		findings = append(findings, f)
+	}
+
+	return findings
+}
+
+// paymentRef pulls the Square payment ID out of a letter description of the
+// form "Notice - Ref: <payment id>".
+func paymentRef(description string) string {
+	const marker = "Ref: "
+	i := strings.LastIndex(description, marker)
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(description[i+len(marker):])
+}
+
+func reportSubject(rep *storage.ReconciliationReport) string {
+	if len(rep.Findings) == 0 {
+		return "✅ Reconciliation: all clear"
+	}
+	return fmt.Sprintf("🧾 Reconciliation: %d issue(s) found", len(rep.Findings))
+}
+
+func reportBody(rep *storage.ReconciliationReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Window: %s to %s\n", rep.WindowStart.Format(time.RFC822), rep.WindowEnd.Format(time.RFC822))
+	fmt.Fprintf(&b, "Checked %d payments, %d letters, %d orders.\n\n", rep.PaymentsChecked, rep.LettersChecked, rep.OrdersChecked)
+	for _, f := range rep.Findings {
+		fmt.Fprintf(&b, "[%s] order #%d payment %s letter %s: %s\n", f.Kind, f.OrderID, f.PaymentID, f.LetterID, f.Detail)
+	}
+	return b.String()
+}
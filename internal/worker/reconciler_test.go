@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sendmynotice/internal/mailer"
+	"sendmynotice/internal/payment"
+	"sendmynotice/internal/storage"
+)
+
+func TestReconcile(t *testing.T) {
+	begin := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	end := begin.Add(reconcileLookback)
+	inside := begin.Add(6 * time.Hour)
+
+	completed := func(id string, at time.Time) payment.Summary {
+		return payment.Summary{ID: id, Status: "COMPLETED", AmountCents: 2900, CreatedAt: at}
+	}
+	letter := func(id, paymentID string, at time.Time) mailer.LetterSummary {
+		return mailer.LetterSummary{ID: id, Description: "Notice - Ref: " + paymentID, DateCreated: at.Format(time.RFC3339)}
+	}
+
+	tests := []struct {
+		name     string
+		payments []payment.Summary
+		letters  []mailer.LetterSummary
+		orders   []storage.Order
+		want     []storage.Finding
+	}{
+		{
+			name:     "matched",
+			payments: []payment.Summary{completed("pay_1", inside)},
+			letters:  []mailer.LetterSummary{letter("ltr_1", "pay_1", inside.Add(time.Minute))},
+		},
+		{
+			name:     "charged without letter",
+			payments: []payment.Summary{completed("pay_1", inside)},
+			orders:   []storage.Order{{ID: 7, Status: storage.OrderCharged, PaymentID: "pay_1"}},
+			want:     []storage.Finding{{Kind: storage.FindingChargedWithoutLetter, OrderID: 7, PaymentID: "pay_1", AmountCents: 2900}},
+		},
+		{
+			name:     "deleted letter does not count",
+			payments: []payment.Summary{completed("pay_1", inside)},
+			letters: []mailer.LetterSummary{
+				{ID: "ltr_1", Description: "Notice - Ref: pay_1", DateCreated: inside.Format(time.RFC3339), Deleted: true},
+			},
+			want: []storage.Finding{{Kind: storage.FindingChargedWithoutLetter, PaymentID: "pay_1", AmountCents: 2900}},
+		},
+		{
+			name:    "letter without payment",
+			letters: []mailer.LetterSummary{letter("ltr_1", "pay_1", inside)},
+			want:    []storage.Finding{{Kind: storage.FindingLetterWithoutPayment, LetterID: "ltr_1", PaymentID: "pay_1"}},
+		},
+		{
+			name:     "letter on a failed payment",
+			payments: []payment.Summary{{ID: "pay_1", Status: "FAILED", AmountCents: 2900, CreatedAt: inside}},
+			letters:  []mailer.LetterSummary{letter("ltr_1", "pay_1", inside)},
+			want:     []storage.Finding{{Kind: storage.FindingLetterWithoutPayment, LetterID: "ltr_1", PaymentID: "pay_1", AmountCents: 2900}},
+		},
+		{
+			name:     "refunded but mailed",
+			payments: []payment.Summary{{ID: "pay_1", Status: "COMPLETED", AmountCents: 2900, RefundedCents: 2900, CreatedAt: inside}},
+			letters:  []mailer.LetterSummary{letter("ltr_1", "pay_1", inside)},
+			want:     []storage.Finding{{Kind: storage.FindingRefundedButMailed, LetterID: "ltr_1", PaymentID: "pay_1", AmountCents: 2900}},
+		},
+		{
+			name:     "partly refunded and mailed",
+			payments: []payment.Summary{{ID: "pay_1", Status: "COMPLETED", AmountCents: 5800, RefundedCents: 2900, CreatedAt: inside}},
+			letters:  []mailer.LetterSummary{letter("ltr_1", "pay_1", inside)},
+		},
+		{
+			name:     "letter matched through its order",
+			payments: []payment.Summary{completed("pay_1", inside)},
+			letters:  []mailer.LetterSummary{{ID: "ltr_1", Description: "no reference", DateCreated: inside.Format(time.RFC3339)}},
+			orders: []storage.Order{{ID: 7, PaymentID: "pay_1", Letters: []storage.OrderLetter{
+				{LetterID: "ltr_1"},
+			}}},
+		},
+		{
+			name:     "payment just before begin with its letter inside",
+			payments: []payment.Summary{completed("pay_1", begin.Add(-time.Minute))},
+			letters:  []mailer.LetterSummary{letter("ltr_1", "pay_1", begin.Add(time.Minute))},
+		},
+		{
+			name:     "payment just before end with its letter after it",
+			payments: []payment.Summary{completed("pay_1", end.Add(-time.Minute))},
+			letters:  []mailer.LetterSummary{letter("ltr_1", "pay_1", end.Add(time.Minute))},
+		},
+		{
+			name:     "payment in the slack before begin is not reported",
+			payments: []payment.Summary{completed("pay_1", begin.Add(-30*time.Minute))},
+		},
+		{
+			name:    "letter in the slack after end is not reported",
+			letters: []mailer.LetterSummary{letter("ltr_1", "pay_1", end.Add(30*time.Minute))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Reconcile(begin, end, tt.payments, tt.letters, tt.orders)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d findings %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i, f := range got {
+				w := tt.want[i]
+				if f.Kind != w.Kind || f.OrderID != w.OrderID || f.PaymentID != w.PaymentID || f.LetterID != w.LetterID || f.AmountCents != w.AmountCents {
+					t.Errorf("finding %d = %+v, want %+v", i, f, w)
+				}
+			}
+		})
+	}
+}
+
+type fakePayments []payment.Summary
+
+func (f fakePayments) ListPayments(ctx context.Context, begin, end time.Time) ([]payment.Summary, error) {
+	var out []payment.Summary
+	for _, p := range f {
+		if !p.CreatedAt.Before(begin) && p.CreatedAt.Before(end) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+type fakeLetters []mailer.LetterSummary
+
+func (f fakeLetters) ListLetters(ctx context.Context, begin, end time.Time) ([]mailer.LetterSummary, error) {
+	var out []mailer.LetterSummary
+	for _, l := range f {
+		created, err := time.Parse(time.RFC3339, l.DateCreated)
+		if err != nil {
+			return nil, err
+		}
+		if !created.Before(begin) && created.Before(end) {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// TestRunWindowEdges checks that Run fetches wide enough for payments made
+// at either edge of its window to find their letters.
+func TestRunWindowEdges(t *testing.T) {
+	now := time.Date(2026, 3, 3, 10, 0, 0, 0, time.UTC)
+	end := now.Add(-reconcileSettle)
+	begin := end.Add(-reconcileLookback)
+
+	payments := fakePayments{
+		{ID: "pay_early", Status: "COMPLETED", AmountCents: 2900, CreatedAt: begin.Add(-time.Minute)},
+		{ID: "pay_first", Status: "COMPLETED", AmountCents: 2900, CreatedAt: begin.Add(time.Minute)},
+		{ID: "pay_last", Status: "COMPLETED", AmountCents: 2900, CreatedAt: end.Add(-time.Minute)},
+	}
+	letters := fakeLetters{
+		{ID: "ltr_early", Description: "Notice - Ref: pay_early", DateCreated: begin.Add(time.Minute).Format(time.RFC3339)},
+		{ID: "ltr_first", Description: "Notice - Ref: pay_first", DateCreated: begin.Add(2 * time.Minute).Format(time.RFC3339)},
+		{ID: "ltr_last", Description: "Notice - Ref: pay_last", DateCreated: end.Add(time.Minute).Format(time.RFC3339)},
+	}
+
+	var subject string
+	r := NewReconciler(storage.NewMemory(), payments, letters, func(s, _ string) { subject = s })
+	rep, err := r.Run(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Findings) != 0 {
+		t.Errorf("got findings %+v, want none", rep.Findings)
+	}
+	if subject != "✅ Reconciliation: all clear" {
+		t.Errorf("alert subject = %q", subject)
+	}
+}
@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sendmynotice/internal/orders"
+	"sendmynotice/internal/storage"
+)
+
+type RefundRunner struct {
+	db        storage.Store
+	processor *orders.Processor
+}
+
+func NewRefundRunner(db storage.Store, processor *orders.Processor) *RefundRunner {
+	return &RefundRunner{
+		db:        db,
+		processor: processor,
+	}
+}
+
+func (r *RefundRunner) Start() {
+	log.Println("💸 Refund Retry Worker Started...")
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.retryDueRefunds()
+	}
+}
+
+func (r *RefundRunner) retryDueRefunds() {
+	due, err := r.db.GetDueRefunds(context.Background(), 20)
+	if err != nil {
+		log.Printf("Error fetching due refunds: %v", err)
+		return
+	}
+
+	for i := range due {
+		rf := &due[i]
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := r.processor.RetryRefund(ctx, rf)
+		cancel()
+		if err != nil {
+			log.Printf("Refund retry %d for %s failed (attempt %d): %v", rf.ID, rf.PaymentID, rf.Attempts, err)
+			continue
+		}
+		log.Printf("✅ Refunded %s from the retry queue", rf.PaymentID)
+	}
+}